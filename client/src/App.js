@@ -2,6 +2,7 @@ import Item from "./Item";
 import React, { useEffect } from "react";
 import { connect } from "react-redux";
 import {
+  getBoard,
   getItems,
   isErrored,
   isLoading,
@@ -49,6 +50,7 @@ const Form = ({ uploading, uploadErrored, uploadItem }) => {
 };
 
 const App = ({
+  board,
   loading,
   items,
   errored,
@@ -71,14 +73,15 @@ const App = ({
         uploadErrored={uploadErrored}
         uploadItem={uploadItem}
       />
-      {items.map((id) => (
-        <Item id={id} key={id} />
+      {items.map((item) => (
+        <Item board={board} id={item.id} key={item.id} />
       ))}
     </>
   );
 };
 
 const mapStateToProps = (state) => ({
+  board: getBoard(state),
   loading: isLoading(state),
   items: getItems(state),
   errored: isErrored(state),
@@ -1,6 +1,8 @@
 import "./Item.css";
 import React from "react";
 
-const Item = ({ id }) => <img className="Item" src={`/api/image/${id}`} />;
+const Item = ({ board, id }) => (
+  <img className="Item" src={`/api/boards/${board}/image/${id}`} />
+);
 
 export default Item;
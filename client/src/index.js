@@ -4,6 +4,7 @@ import React from "react";
 import { Provider } from "react-redux";
 import { configureStore } from "@reduxjs/toolkit";
 import {
+  boardLoaded,
   itemUploadFailed,
   itemUploaded,
   itemUploading,
@@ -20,6 +21,7 @@ import { render } from "react-dom";
 const store = configureStore({
   devTools: {
     actionCreators: {
+      boardLoaded,
       itemUploadFailed,
       itemUploaded,
       itemUploading,
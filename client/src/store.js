@@ -1,5 +1,7 @@
 import { createAction, createReducer } from "@reduxjs/toolkit";
 
+export const boardLoaded = createAction("boardLoaded");
+
 export const itemsLoading = createAction("itemsLoading");
 export const itemsLoaded = createAction("itemsLoaded");
 export const itemsLoadFailed = createAction("itemsLoadFailed");
@@ -9,11 +11,47 @@ export const itemUploaded = createAction("itemUploaded");
 export const itemUploadFailed = createAction("itemUploadFailed");
 export const clearItemUploadError = createAction("clearItemUploadError");
 
-export const loadItems = () => async (dispatch) => {
+// findBoard returns the ID of the first board, creating one if there aren't any.
+const findBoard = async () => {
+  const response = await fetch("/api/boards");
+
+  if (response.status !== 200) {
+    throw new Error(`failed to list boards: ${response.status}`);
+  }
+
+  const boards = await response.json();
+
+  if (boards.length > 0) {
+    return boards[0].id;
+  }
+
+  const created = await fetch("/api/boards", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ name: "Default" }),
+  });
+
+  if (created.status !== 200) {
+    throw new Error(`failed to create board: ${created.status}`);
+  }
+
+  const board = await created.json();
+
+  return board.id;
+};
+
+export const loadItems = () => async (dispatch, getState) => {
   dispatch(itemsLoading());
 
   try {
-    const response = await fetch("/api");
+    let board = getBoard(getState());
+
+    if (board === null) {
+      board = await findBoard();
+      dispatch(boardLoaded(board));
+    }
+
+    const response = await fetch(`/api/boards/${board}`);
 
     if (response.status !== 200) {
       dispatch(itemsLoadFailed());
@@ -28,19 +66,22 @@ export const loadItems = () => async (dispatch) => {
   }
 };
 
-export const uploadItem = (file) => async (dispatch) => {
+export const uploadItem = (file) => async (dispatch, getState) => {
   dispatch(itemUploading());
 
   try {
     const body = new FormData();
     body.set("file", file);
 
-    const response = await fetch("/api", {
+    const response = await fetch(`/api/boards/${getBoard(getState())}/`, {
       method: "POST",
       body,
     });
 
-    if (response.status === 200) {
+    // The response contains a result for each file, which has an ID if the file was stored.
+    const results = response.status === 200 ? await response.json() : [];
+
+    if (results.length > 0 && results.every((result) => result.id)) {
       dispatch(itemUploaded());
     } else {
       dispatch(itemUploadFailed());
@@ -74,6 +115,7 @@ export const middleware = (store) => {
 
 export const reducer = createReducer(
   {
+    board: null,
     loading: 0,
     items: null,
     error: false,
@@ -82,6 +124,10 @@ export const reducer = createReducer(
     uploadError: false,
   },
   {
+    [boardLoaded]: (state, { payload }) => ({
+      ...state,
+      board: payload,
+    }),
     [itemsLoading]: (state) => ({
       ...state,
       loading: state.loading + 1,
@@ -118,6 +164,7 @@ export const reducer = createReducer(
   }
 );
 
+export const getBoard = (state) => state.board;
 export const isLoading = (state) => state.loading > 0;
 export const getItems = (state) => state.items;
 export const isErrored = (state) => state.error;
@@ -0,0 +1,332 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// boardsBucket contains board records, keyed by ID.
+	boardsBucket = []byte("boards")
+
+	// itemsBucket contains item records, keyed by ID.
+	itemsBucket = []byte("items")
+
+	// boardItemsBucket contains an empty value for each item, keyed by the ID of its board followed by its own ID (see
+	// boardItemKey). This allows the items on a board to be found without looking through every item.
+	boardItemsBucket = []byte("boardItems")
+
+	// imagesBucket contains images, keyed by item ID.
+	imagesBucket = []byte("images")
+)
+
+// boardItemKey returns the key of an item in the board items bucket.
+//
+// The IDs are separated by a zero byte, which can't appear in either of them, so that the items on a board are next
+// to each other and can't be mixed up with the items on a board whose ID starts with the same characters.
+func boardItemKey(boardID, id string) []byte {
+	return []byte(boardID + "\x00" + id)
+}
+
+// tx represents a transaction against a bbolt backend.
+type tx struct {
+	tx *bolt.Tx
+}
+
+// Boards returns all board records.
+func (t *tx) Boards() ([]core.Board, error) {
+	var boards []core.Board
+
+	err := t.tx.Bucket(boardsBucket).ForEach(func(_, data []byte) error {
+		var board core.Board
+
+		if err := json.Unmarshal(data, &board); err != nil {
+			return fmt.Errorf("failed to decode board: %w", err)
+		}
+
+		boards = append(boards, board)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boards, nil
+}
+
+// Board returns the board record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (t *tx) Board(id string) (core.Board, error) {
+	data := t.tx.Bucket(boardsBucket).Get([]byte(id))
+
+	if data == nil {
+		return core.Board{}, moodboard.ErrNoSuchBoard
+	}
+
+	var board core.Board
+
+	if err := json.Unmarshal(data, &board); err != nil {
+		return core.Board{}, fmt.Errorf("failed to decode board: %w", err)
+	}
+
+	return board, nil
+}
+
+// PutBoard creates or replaces a board record.
+func (t *tx) PutBoard(board core.Board) error {
+	data, err := json.Marshal(board)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode board: %w", err)
+	}
+
+	if err := t.tx.Bucket(boardsBucket).Put([]byte(board.ID), data); err != nil {
+		return fmt.Errorf("failed to store board: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBoard removes a board record.
+func (t *tx) DeleteBoard(id string) error {
+	if err := t.tx.Bucket(boardsBucket).Delete([]byte(id)); err != nil {
+		return fmt.Errorf("failed to delete board: %w", err)
+	}
+
+	return nil
+}
+
+// Items returns all item records on the specified board, in no particular order.
+func (t *tx) Items(boardID string) ([]core.Item, error) {
+	var items []core.Item
+
+	prefix := boardItemKey(boardID, "")
+	c := t.tx.Bucket(boardItemsBucket).Cursor()
+
+	for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+		item, err := t.Item(string(key[len(prefix):]))
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Item returns the item record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
+func (t *tx) Item(id string) (core.Item, error) {
+	data := t.tx.Bucket(itemsBucket).Get([]byte(id))
+
+	if data == nil {
+		return core.Item{}, moodboard.ErrNoSuchItem
+	}
+
+	var item core.Item
+
+	if err := json.Unmarshal(data, &item); err != nil {
+		return core.Item{}, fmt.Errorf("failed to decode item: %w", err)
+	}
+
+	return item, nil
+}
+
+// PutItem creates or replaces an item record.
+func (t *tx) PutItem(item core.Item) error {
+	data, err := json.Marshal(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %w", err)
+	}
+
+	// Make sure that the item isn't left behind on its old board if it has moved.
+	if err := t.DeleteItem(item.ID); err != nil {
+		return err
+	}
+
+	if err := t.tx.Bucket(itemsBucket).Put([]byte(item.ID), data); err != nil {
+		return fmt.Errorf("failed to store item: %w", err)
+	}
+
+	if err := t.tx.Bucket(boardItemsBucket).Put(boardItemKey(item.Board, item.ID), nil); err != nil {
+		return fmt.Errorf("failed to store item: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteItem removes an item record.
+func (t *tx) DeleteItem(id string) error {
+	item, err := t.Item(id)
+
+	if errors.Is(err, moodboard.ErrNoSuchItem) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := t.tx.Bucket(boardItemsBucket).Delete(boardItemKey(item.Board, id)); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	if err := t.tx.Bucket(itemsBucket).Delete([]byte(id)); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	return nil
+}
+
+// CreateImage stores the image for the item with the specified ID.
+func (t *tx) CreateImage(id string, img io.Reader) error {
+	// Read the whole image into memory, as it needs to be passed to bbolt in one go.
+	buf, err := ioutil.ReadAll(img)
+
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	if err := t.tx.Bucket(imagesBucket).Put([]byte(id), buf); err != nil {
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+
+	return nil
+}
+
+// Image returns the image for the item with the specified ID.
+func (t *tx) Image(id string) (io.Reader, error) {
+	data := t.tx.Bucket(imagesBucket).Get([]byte(id))
+
+	if data == nil {
+		return nil, moodboard.ErrNoSuchItem
+	}
+
+	// The data is only valid until the end of the transaction, so we need to take a copy of it.
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	return bytes.NewReader(buf), nil
+}
+
+// DeleteImage removes the image for the item with the specified ID.
+func (t *tx) DeleteImage(id string) error {
+	if err := t.tx.Bucket(imagesBucket).Delete([]byte(id)); err != nil {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+
+	return nil
+}
+
+// backend is a bbolt core.Backend.
+type backend struct {
+	db *bolt.DB
+}
+
+// View runs fn within a read-only transaction.
+func (b *backend) View(ctx context.Context, fn func(core.Tx) error) error {
+	return b.db.View(func(btx *bolt.Tx) error {
+		// We may have been waiting on the database for a while - make sure we still need to run.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return fn(&tx{tx: btx})
+	})
+}
+
+// Update runs fn within a read-write transaction.
+//
+// If fn returns an error then none of the changes made within the transaction are kept.
+func (b *backend) Update(ctx context.Context, fn func(core.Tx) error) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		// We may have been waiting on the database for a while - make sure we still need to run.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(&tx{tx: btx}); err != nil {
+			return err
+		}
+
+		// Returning an error here rolls back the transaction, so nothing is kept if we've been cancelled.
+		return ctx.Err()
+	})
+}
+
+// Store represents a collection of moodboards stored in a bbolt database.
+type Store struct {
+	*core.Store
+	db *bolt.DB
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// NewStore creates a new moodboard collection, backed by the bbolt database at the specified path.
+//
+// The database is created if it does not already exist. Only one process can have the database open at a time, so
+// once the store is no longer needed it should be closed.
+func NewStore(path string) (*Store, error) {
+	// Don't wait forever if another process has the database open.
+	db, err := bolt.Open(path, 0o666, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Make sure all of our buckets exist so that transactions don't need to check for them.
+	err = db.Update(func(btx *bolt.Tx) error {
+		// Databases from before the board items bucket existed need it filling in.
+		indexed := btx.Bucket(boardItemsBucket) != nil
+
+		for _, name := range [][]byte{boardsBucket, itemsBucket, boardItemsBucket, imagesBucket} {
+			if _, err := btx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %q: %w", name, err)
+			}
+		}
+
+		if indexed {
+			return nil
+		}
+
+		return btx.Bucket(itemsBucket).ForEach(func(id, data []byte) error {
+			var item core.Item
+
+			if err := json.Unmarshal(data, &item); err != nil {
+				return fmt.Errorf("failed to decode item: %w", err)
+			}
+
+			if err := btx.Bucket(boardItemsBucket).Put(boardItemKey(item.Board, string(id)), nil); err != nil {
+				return fmt.Errorf("failed to store item: %w", err)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return &Store{Store: core.NewStore(&backend{db: db}), db: db}, nil
+}
@@ -0,0 +1,99 @@
+package bolt_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/bolt"
+	"github.com/jackwilsdon/moodboard/storetest"
+	bbolt "go.etcd.io/bbolt"
+	"path"
+	"testing"
+)
+
+// newStore creates a new moodboard store for testing.
+//
+// A temporary directory is used to hold the database, which is cleaned up once the test and all its subtests complete.
+func newStore(t *testing.T) *bolt.Store {
+	dir := storetest.TempDir(t)
+
+	s, err := bolt.NewStore(path.Join(dir, "moodboard.db"))
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// Close the database before the directory is deleted.
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) moodboard.Store {
+		return newStore(t)
+	})
+}
+
+func TestStoreBoardItemsMigration(t *testing.T) {
+	ctx := context.Background()
+	p := path.Join(storetest.TempDir(t), "moodboard.db")
+	s, err := bolt.NewStore(p)
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	boardID := storetest.NewBoard(t, s)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Create(ctx, boardID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	db, err := bbolt.Open(p, 0o666, nil)
+
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	// Databases from before items were indexed by board don't have the bucket.
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte("boardItems"))
+	})
+
+	if err != nil {
+		t.Fatalf("failed to delete bucket: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	s, err = bolt.NewStore(p)
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 {
+		t.Fatalf("expected to get 2 items but got %d", len(all))
+	}
+}
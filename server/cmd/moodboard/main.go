@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/jackwilsdon/moodboard/bolt"
 	"github.com/jackwilsdon/moodboard/file"
 	"github.com/jackwilsdon/moodboard/memory"
+	"github.com/jackwilsdon/moodboard/s3"
+	"github.com/jackwilsdon/moodboard/sqlite"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/jackwilsdon/moodboard"
 )
@@ -17,7 +25,241 @@ func (logger) Error(msg string) {
 	log.Print(msg)
 }
 
+// backfiller is implemented by stores which can bring their items up to date.
+type backfiller interface {
+	Backfill(context.Context) (int, error)
+}
+
+// quotaSetter is implemented by stores which support quotas.
+type quotaSetter interface {
+	SetQuota(moodboard.Quota)
+}
+
+// duplicatePolicySetter is implemented by stores which can find near-duplicates.
+type duplicatePolicySetter interface {
+	SetDuplicatePolicy(moodboard.DuplicatePolicy)
+}
+
+// decodeLimitsSetter is implemented by stores which decode images to analyse them.
+type decodeLimitsSetter interface {
+	SetDecodeLimits(moodboard.DecodeLimits)
+}
+
+// configure sets the quota, duplicate policy and decode limits of a store.
+func configure(
+	s moodboard.Store,
+	quota moodboard.Quota,
+	duplicates moodboard.DuplicatePolicy,
+	decodes moodboard.DecodeLimits,
+) error {
+	qs, ok := s.(quotaSetter)
+
+	if !ok {
+		return errors.New("store does not support quotas")
+	}
+
+	qs.SetQuota(quota)
+
+	ds, ok := s.(duplicatePolicySetter)
+
+	if !ok {
+		return errors.New("store does not support finding duplicates")
+	}
+
+	ds.SetDuplicatePolicy(duplicates)
+
+	dls, ok := s.(decodeLimitsSetter)
+
+	if !ok {
+		return errors.New("store does not support decode limits")
+	}
+
+	dls.SetDecodeLimits(decodes)
+
+	return nil
+}
+
+// storeTypes contains the types of store which can be given at the start of an argument to openStore.
+var storeTypes = map[string]bool{
+	"file":   true,
+	"sqlite": true,
+	"bolt":   true,
+	"s3":     true,
+}
+
+// openStore creates the store described by the specified argument.
+//
+// Arguments are of the form "type:path", where type is one of "file", "sqlite", "bolt" or "s3". Arguments without a
+// type use the file-based store, including paths which contain a colon but don't start with one of the types.
+//
+// For S3 the path is the name of the bucket, optionally followed by a prefix (e.g. "bucket/prefix/"). The endpoint and
+// credentials are read from the environment.
+func openStore(arg string) (moodboard.Store, error) {
+	typ, path := "file", arg
+
+	if i := strings.IndexByte(arg, ':'); i != -1 && storeTypes[arg[:i]] {
+		typ, path = arg[:i], arg[i+1:]
+	}
+
+	switch typ {
+	case "file":
+		log.Printf("using file-based store %q", path)
+
+		return file.NewStore(path), nil
+	case "sqlite":
+		log.Printf("using SQLite store %q", path)
+
+		return sqlite.NewStore(path)
+	case "bolt":
+		log.Printf("using bbolt store %q", path)
+
+		return bolt.NewStore(path)
+	case "s3":
+		bucket, prefix := path, ""
+
+		if i := strings.IndexByte(path, '/'); i != -1 {
+			bucket, prefix = path[:i], path[i+1:]
+		}
+
+		endpoint := os.Getenv("S3_ENDPOINT")
+
+		if endpoint == "" {
+			endpoint = "https://s3.amazonaws.com"
+		}
+
+		log.Printf("using S3 store %q at %q", path, endpoint)
+
+		return s3.NewStore(s3.Options{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("AWS_REGION"),
+			Bucket:          bucket,
+			Prefix:          prefix,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown store type %q", typ)
+	}
+}
+
+// defaultMaxUploadSize is the maximum size of each uploaded image if MAX_UPLOAD_SIZE isn't set.
+const defaultMaxUploadSize = 32 << 20
+
+// sizeFromEnv reads a number of bytes from the specified environment variable, using def if it isn't set.
+func sizeFromEnv(name string, def int64) (int64, error) {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", name, value)
+	}
+
+	return n, nil
+}
+
+// fsck checks the file-based store at the specified path for inconsistencies, optionally repairing them.
+func fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "remove orphaned images and dangling items, and set aside a damaged journal")
+
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "usage: %s fsck [--repair] data\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	s := file.NewStore(fs.Arg(0))
+
+	var (
+		report file.Report
+		err    error
+	)
+
+	if *repair {
+		report, err = s.Repair(context.Background())
+	} else {
+		report, err = s.Check(context.Background())
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, name := range report.Orphans {
+		fmt.Printf("orphaned image: %s\n", name)
+	}
+
+	for _, id := range report.Dangling {
+		fmt.Printf("dangling item: %s\n", id)
+	}
+
+	if report.SkippedEntries > 0 {
+		fmt.Printf("damaged journal: %d entries skipped\n", report.SkippedEntries)
+	}
+
+	if report.OK() {
+		fmt.Println("no problems found")
+	} else if *repair {
+		fmt.Println("all problems repaired")
+	} else {
+		os.Exit(1)
+	}
+}
+
+// migrate brings the items in the store described by the specified argument up to date.
+func migrate(args []string) {
+	if len(args) != 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s migrate [file:]data | sqlite:data.db | bolt:data.db | s3:bucket[/prefix]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	s, err := openStore(args[0])
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, ok := s.(backfiller)
+
+	if !ok {
+		log.Fatalf("store %q does not support migrating", args[0])
+	}
+
+	n, err := b.Backfill(context.Background())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("updated %d items\n", n)
+}
+
 func main() {
+	// Check the store instead of starting the server if we've been asked to.
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		fsck(os.Args[2:])
+
+		return
+	}
+
+	// Likewise for migrating the store.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+
+		return
+	}
+
 	var s moodboard.Store
 
 	// Create the right type of store based on the number of arguments we were given.
@@ -26,16 +268,79 @@ func main() {
 
 		log.Print("using in-memory store")
 	} else if len(os.Args) == 2 {
-		s = file.NewStore(os.Args[1])
+		var err error
 
-		log.Printf("using file-based store %q", os.Args[1])
+		if s, err = openStore(os.Args[1]); err != nil {
+			log.Fatal(err)
+		}
 	} else {
-		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [data.json]\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [[file:]data | sqlite:data.db | bolt:data.db | s3:bucket[/prefix]]\n", os.Args[0])
 		os.Exit(1)
 	}
 
+	maxUploadSize, err := sizeFromEnv("MAX_UPLOAD_SIZE", defaultMaxUploadSize)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var quota moodboard.Quota
+
+	if quota.Board, err = sizeFromEnv("BOARD_QUOTA", 0); err != nil {
+		log.Fatal(err)
+	}
+
+	if quota.Total, err = sizeFromEnv("TOTAL_QUOTA", 0); err != nil {
+		log.Fatal(err)
+	}
+
+	duplicates := moodboard.DuplicatePolicy{Distance: moodboard.DefaultDuplicateDistance}
+
+	// Hashes are 64 bits long, so any larger distance would be the same as 64.
+	if value := os.Getenv("DUPLICATE_DISTANCE"); value != "" {
+		if duplicates.Distance, err = strconv.Atoi(value); err != nil || duplicates.Distance < 0 || duplicates.Distance > 64 {
+			log.Fatalf("invalid value for DUPLICATE_DISTANCE: %q", value)
+		}
+	}
+
+	if value := os.Getenv("REJECT_DUPLICATES"); value != "" {
+		if duplicates.Reject, err = strconv.ParseBool(value); err != nil {
+			log.Fatalf("invalid value for REJECT_DUPLICATES: %q", value)
+		}
+	}
+
+	decodes := moodboard.DecodeLimits{
+		MaxPixels:  moodboard.DefaultMaxDecodePixels,
+		Concurrent: moodboard.DefaultConcurrentDecodes,
+	}
+
+	if value := os.Getenv("MAX_DECODE_PIXELS"); value != "" {
+		if decodes.MaxPixels, err = strconv.Atoi(value); err != nil || decodes.MaxPixels < 0 {
+			log.Fatalf("invalid value for MAX_DECODE_PIXELS: %q", value)
+		}
+	}
+
+	if value := os.Getenv("CONCURRENT_DECODES"); value != "" {
+		if decodes.Concurrent, err = strconv.Atoi(value); err != nil || decodes.Concurrent < 0 {
+			log.Fatalf("invalid value for CONCURRENT_DECODES: %q", value)
+		}
+	}
+
+	if err := configure(s, quota, duplicates, decodes); err != nil {
+		log.Fatal(err)
+	}
+
+	h := moodboard.NewHandler(logger{}, s)
+	h.MaxUploadSize = maxUploadSize
+
+	if value := os.Getenv("STRIP_METADATA"); value != "" {
+		if h.StripMetadata, err = strconv.ParseBool(value); err != nil {
+			log.Fatalf("invalid value for STRIP_METADATA: %q", value)
+		}
+	}
+
 	// Handle requests to the root with the moodboard handler.
-	http.Handle("/", moodboard.NewHandler(logger{}, s))
+	http.Handle("/", h)
 
 	log.Print("starting on http://localhost:3001...")
 
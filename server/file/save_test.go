@@ -0,0 +1,413 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard/internal/core"
+	"github.com/jackwilsdon/moodboard/storetest"
+)
+
+// errDiskFull is returned by failingWriter once it runs out of space.
+var errDiskFull = errors.New("disk full")
+
+// failingWriter is an io.Writer which fails once a certain number of bytes have been written.
+type failingWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.remaining {
+		n, _ := f.w.Write(p[:f.remaining])
+		f.remaining = 0
+
+		return n, errDiskFull
+	}
+
+	f.remaining -= len(p)
+
+	return f.w.Write(p)
+}
+
+// readFile returns the contents of a file, or nil if it doesn't exist.
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	buf, err := ioutil.ReadFile(name)
+
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to read %q: %v", name, err)
+	}
+
+	return buf
+}
+
+// boardName returns the name of the only board in a store.
+func boardName(t *testing.T, s *core.Store) string {
+	t.Helper()
+
+	boards, err := s.Boards(context.Background())
+
+	if err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	if len(boards) != 1 {
+		t.Fatalf("expected to get 1 board but got %d", len(boards))
+	}
+
+	return boards[0].Name
+}
+
+func TestBackendSaveFailure(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	b := &backend{path: dir}
+	s := core.NewStore(b)
+
+	failWrites := func(w io.Writer) io.Writer {
+		return &failingWriter{w: w, remaining: 10}
+	}
+
+	// Fail part of the way through writing the first index.
+	b.wrapWriter = failWrites
+
+	if _, err := s.CreateBoard(ctx, "test"); !errors.Is(err, errDiskFull) {
+		t.Fatalf("expected error to be %q but got %q", errDiskFull, err)
+	}
+
+	// The partially written index shouldn't be left behind.
+	if _, err := os.Stat(path.Join(dir, ".index.json.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary index to have been removed but got %v", err)
+	}
+
+	b.wrapWriter = nil
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	if _, err := s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	index := readFile(t, path.Join(dir, "index.json"))
+	journal := readFile(t, path.Join(dir, "index.journal"))
+
+	// Fail part of the way through writing the next journal entry.
+	b.wrapWriter = failWrites
+
+	if err := s.RenameBoard(ctx, board.ID, "renamed"); !errors.Is(err, errDiskFull) {
+		t.Fatalf("expected error to be %q but got %q", errDiskFull, err)
+	}
+
+	if after := readFile(t, path.Join(dir, "index.json")); !bytes.Equal(index, after) {
+		t.Fatalf("expected index to be %q but got %q", index, after)
+	}
+
+	// The partially written entry shouldn't be left behind.
+	if after := readFile(t, path.Join(dir, "index.journal")); !bytes.Equal(journal, after) {
+		t.Fatalf("expected journal to be %q but got %q", journal, after)
+	}
+
+	b.wrapWriter = nil
+
+	// Neither the store which failed nor a new one should see the change.
+	if name := boardName(t, s); name != "test" {
+		t.Fatalf("expected board to be named %q but got %q", "test", name)
+	}
+
+	if name := boardName(t, core.NewStore(&backend{path: dir})); name != "test" {
+		t.Fatalf("expected board to be named %q but got %q", "test", name)
+	}
+
+	all, err := s.All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 1 {
+		t.Fatalf("expected to get 1 item but got %d", len(all))
+	}
+
+	// Writing should work again once there's space.
+	if err := s.RenameBoard(ctx, board.ID, "renamed"); err != nil {
+		t.Fatalf("failed to rename board: %v", err)
+	}
+
+	if name := boardName(t, core.NewStore(&backend{path: dir})); name != "renamed" {
+		t.Fatalf("expected board to be named %q but got %q", "renamed", name)
+	}
+}
+
+func TestBackendSaveCrash(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := core.NewStore(&backend{path: dir})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	// A crash part of the way through a write leaves a partial temporary index behind.
+	if err := ioutil.WriteFile(path.Join(dir, ".index.json.tmp"), []byte(`{"boards":[{"id":`), 0o666); err != nil {
+		t.Fatalf("failed to write temporary index: %v", err)
+	}
+
+	boards, err := s.Boards(ctx)
+
+	if err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	if len(boards) != 1 || boards[0].ID != board.ID {
+		t.Fatalf("expected boards to be [%v] but got %v", board, boards)
+	}
+
+	// The next write should replace the partial index.
+	if err := s.RenameBoard(ctx, board.ID, "renamed"); err != nil {
+		t.Fatalf("failed to rename board: %v", err)
+	}
+
+	boards, err = s.Boards(ctx)
+
+	if err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	if len(boards) != 1 || boards[0].Name != "renamed" {
+		t.Fatalf("expected board to be named %q but got %v", "renamed", boards)
+	}
+
+	// A crash part of the way through writing to the journal leaves a partial entry at the end of it.
+	f, err := os.OpenFile(path.Join(dir, "index.journal"), os.O_WRONLY|os.O_APPEND, 0)
+
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+
+	if _, err := f.WriteString(`{"boards":[{"id":`); err != nil {
+		_ = f.Close()
+
+		t.Fatalf("failed to write journal: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to close journal: %v", err)
+	}
+
+	s = core.NewStore(&backend{path: dir})
+
+	if name := boardName(t, s); name != "renamed" {
+		t.Fatalf("expected board to be named %q but got %q", "renamed", name)
+	}
+
+	// The next write should replace the partial entry.
+	if err := s.RenameBoard(ctx, board.ID, "renamed again"); err != nil {
+		t.Fatalf("failed to rename board: %v", err)
+	}
+
+	if name := boardName(t, core.NewStore(&backend{path: dir})); name != "renamed again" {
+		t.Fatalf("expected board to be named %q but got %q", "renamed again", name)
+	}
+}
+
+func TestBackendCache(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := core.NewStore(&backend{path: dir})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	if _, err := s.Boards(ctx); err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	// Changes made to the index from outside of the store should still be seen.
+	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
+
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+
+	buf = bytes.Replace(buf, []byte(`"name":"test"`), []byte(`"name":"changed"`), 1)
+
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), buf, 0o666); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	boards, err := s.Boards(ctx)
+
+	if err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	if len(boards) != 1 || boards[0].ID != board.ID || boards[0].Name != "changed" {
+		t.Fatalf("expected board to be named %q but got %v", "changed", boards)
+	}
+}
+
+func TestBackendJournal(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := core.NewStore(&backend{path: dir})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	var ids []string
+
+	for i := 0; i < 3; i++ {
+		id, err := s.Create(ctx, board.ID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	index := readFile(t, path.Join(dir, "index.json"))
+
+	// Changes should only be added to the journal, rather than rewriting the index.
+	if err := s.MoveToStart(ctx, board.ID, ids[2]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if after := readFile(t, path.Join(dir, "index.json")); !bytes.Equal(index, after) {
+		t.Fatalf("expected index to be %q but got %q", index, after)
+	}
+
+	journal := readFile(t, path.Join(dir, "index.journal"))
+
+	if n := bytes.Count(journal, []byte("\n")); n != 4 {
+		t.Fatalf("expected journal to have 4 entries but got %d", n)
+	}
+
+	// The journal should be replayed when the store is opened again.
+	all, err := core.NewStore(&backend{path: dir}).All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 3 || all[0].ID != ids[2] || all[1].ID != ids[0] || all[2].ID != ids[1] {
+		t.Fatalf("expected all to be [%q, %q, %q] but got %v", ids[2], ids[0], ids[1], all)
+	}
+
+	// The journal should be folded back into the index once it gets too big.
+	compacted := false
+	name := strings.Repeat("a", 1024)
+
+	for i := 0; i < 2*minCompactSize/len(name); i++ {
+		if err := s.RenameBoard(ctx, board.ID, name+strconv.Itoa(i)); err != nil {
+			t.Fatalf("failed to rename board: %v", err)
+		}
+
+		if _, err := os.Stat(path.Join(dir, "index.journal")); os.IsNotExist(err) {
+			compacted = true
+		}
+	}
+
+	if !compacted {
+		t.Fatalf("expected journal to have been compacted")
+	}
+
+	expected := name + strconv.Itoa(2*minCompactSize/len(name)-1)
+
+	if name := boardName(t, core.NewStore(&backend{path: dir})); name != expected {
+		t.Fatalf("expected board to be named %q but got %q", expected, name)
+	}
+}
+
+func TestBackendJournalDamaged(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := NewStore(dir)
+
+	board, err := s.CreateBoard(ctx, "first")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	for _, name := range []string{"second", "third", "fourth"} {
+		if err := s.RenameBoard(ctx, board.ID, name); err != nil {
+			t.Fatalf("failed to rename board: %v", err)
+		}
+	}
+
+	// Damage the second entry in the journal, which should stop it and everything after it from being replayed.
+	name := path.Join(dir, "index.journal")
+	entries := bytes.SplitAfter(readFile(t, name), []byte("\n"))
+	entries[1] = []byte("{\"boards\":\n")
+
+	if err := ioutil.WriteFile(name, bytes.Join(entries, nil), 0o666); err != nil {
+		t.Fatalf("failed to write journal: %v", err)
+	}
+
+	s = NewStore(dir)
+
+	if name := boardName(t, s.Store); name != "second" {
+		t.Fatalf("expected board to be named %q but got %q", "second", name)
+	}
+
+	report, err := s.Check(ctx)
+
+	if err != nil {
+		t.Fatalf("failed to check store: %v", err)
+	}
+
+	if report.SkippedEntries != 2 || report.OK() {
+		t.Fatalf("expected 2 entries to be skipped but got %+v", report)
+	}
+
+	if report, err = s.Repair(ctx); err != nil || report.SkippedEntries != 2 {
+		t.Fatalf("expected repair to skip 2 entries but got [%+v, %v]", report, err)
+	}
+
+	// The damaged journal should be kept to one side, and the store should carry on from what could be replayed.
+	if readFile(t, name) != nil || readFile(t, name+".damaged") == nil {
+		t.Fatal("expected journal to have been set aside")
+	}
+
+	if err := s.RenameBoard(ctx, board.ID, "fifth"); err != nil {
+		t.Fatalf("failed to rename board: %v", err)
+	}
+
+	s = NewStore(dir)
+
+	if name := boardName(t, s.Store); name != "fifth" {
+		t.Fatalf("expected board to be named %q but got %q", "fifth", name)
+	}
+
+	if report, err := s.Check(ctx); err != nil || !report.OK() {
+		t.Fatalf("expected no problems but got [%+v, %v]", report, err)
+	}
+}
@@ -1,470 +1,778 @@
 package file
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/jackwilsdon/moodboard"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/core"
 )
 
-// Store represents an on-disk collection of moodboard items.
-type Store struct {
-	path  string
-	mutex sync.RWMutex
+// ErrNoIndex is returned by Store.Check and Store.Repair when there isn't an index, as every image would look orphaned
+// without one.
+var ErrNoIndex = errors.New("index not found")
+
+// legacyBoardID is the ID of the board which items from a single-board index are migrated to.
+const legacyBoardID = "default"
+
+// minCompactSize is the size that the journal needs to reach before it's compacted into the index.
+const minCompactSize = 64 * 1024
+
+// tx represents a transaction against an on-disk backend.
+type tx struct {
+	*core.Overlay
+	backend *backend
+
+	// created contains the images which have been written during the transaction.
+	created []string
+
+	// deleted contains the images which should be removed once the transaction has been committed.
+	deleted []string
 }
 
-// saveImage saves an image for a moodboard item in the collection.
-func (s *Store) saveImage(img io.Reader, id string) (string, error) {
-	f, err := os.OpenFile(path.Join(s.path, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+// CreateImage stores the image for the item with the specified ID.
+func (t *tx) CreateImage(id string, img io.Reader) error {
+	f, err := os.OpenFile(path.Join(t.backend.path, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
 
 	// If the file doesn't exist, try making the containing directory.
 	if os.IsNotExist(err) {
-		if err := os.MkdirAll(s.path, 0o777); err != nil {
-			return "", fmt.Errorf("failed to create path: %w", err)
+		if err := os.MkdirAll(t.backend.path, 0o777); err != nil {
+			return fmt.Errorf("failed to create path: %w", err)
 		}
 
 		// Re-open the file now that we've created the containing directory.
-		f, err = os.OpenFile(path.Join(s.path, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		f, err = os.OpenFile(path.Join(t.backend.path, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("failed to open image: %w", err)
+		return fmt.Errorf("failed to open image: %w", err)
 	}
 
+	// Keep track of the file so that we can remove it if the transaction fails.
+	t.created = append(t.created, f.Name())
+
 	if _, err := io.Copy(f, img); err != nil {
 		_ = f.Close()
 
-		return "", fmt.Errorf("failed to write image: %w", err)
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+
+	// Make sure the image has made it to disk before the index refers to it.
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to sync image: %w", err)
 	}
 
 	if err := f.Close(); err != nil {
-		return "", fmt.Errorf("failed to close image: %w", err)
+		return fmt.Errorf("failed to close image: %w", err)
 	}
 
-	return f.Name(), nil
+	return nil
 }
 
-// Create creates a new moodboard item in the collection.
-func (s *Store) Create(img io.Reader) (string, error) {
-	// We're going to be writing to disk - lock for writing.
-	s.mutex.Lock()
-
-	// Unlock once we're done.
-	defer s.mutex.Unlock()
+// Image returns the image for the item with the specified ID.
+func (t *tx) Image(id string) (io.Reader, error) {
+	f, err := os.OpenFile(path.Join(t.backend.path, id), os.O_RDONLY, 0)
 
-	id := uuid.New().String()
+	if os.IsNotExist(err) {
+		return nil, moodboard.ErrNoSuchItem
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
 
-	// Save the image - we can delete it later if something goes wrong.
-	imgPath, err := s.saveImage(img, id)
+	return f, nil
+}
 
-	if err != nil {
-		return "", fmt.Errorf("failed to save image: %w", err)
-	}
+// DeleteImage removes the image for the item with the specified ID.
+func (t *tx) DeleteImage(id string) error {
+	// We can't remove the image until the transaction has been committed, as the transaction may still fail.
+	t.deleted = append(t.deleted, path.Join(t.backend.path, id))
 
-	// Open the file as R/W whilst optionally creating it if it doesn't exist.
-	f, err := os.OpenFile(path.Join(s.path, "index.json"), os.O_RDWR|os.O_CREATE, 0o666)
+	return nil
+}
 
-	// If the file doesn't exist, try making the containing directory.
-	if os.IsNotExist(err) {
-		if err := os.MkdirAll(s.path, 0o777); err != nil {
-			return "", fmt.Errorf("failed to create path: %w", err)
-		}
+// backend is an on-disk core.Backend.
+//
+// Records are kept in an index file, with each image stored in its own file alongside it. Rather than rewriting the
+// whole index on every change, the records changed by each transaction are appended to a journal, which is folded back
+// into the index once it grows larger than the index itself.
+type backend struct {
+	path  string
+	mutex sync.RWMutex
 
-		// Re-open the file now that we've created the containing directory.
-		f, err = os.OpenFile(path.Join(s.path, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
-	}
+	// cache contains the records as they were when they were last read from or written to disk, and indexInfo and
+	// journalInfo contain the file info of the index and journal at that point (or nil if they didn't exist). This
+	// means that the records only need to be decoded again once something else changes them.
+	cache       *core.Index
+	indexInfo   os.FileInfo
+	journalInfo os.FileInfo
+	cacheMutex  sync.Mutex
 
-	if err != nil {
-		_ = os.Remove(imgPath)
+	// journalSize is the size of the journal up to the end of the last entry which was replayed.
+	journalSize int64
 
-		return "", fmt.Errorf("failed to open store: %w", err)
-	}
+	// journalSkipped is the number of complete entries at the end of the journal which weren't replayed, as the first
+	// of them couldn't be decoded.
+	journalSkipped int
 
-	var items []string
+	// wrapWriter is used by tests to intercept writes to the index and journal.
+	wrapWriter func(io.Writer) io.Writer
+}
 
-	// Read the current item list.
-	if err = json.NewDecoder(f).Decode(&items); err != nil && err != io.EOF {
-		_ = os.Remove(imgPath)
-		_ = f.Close()
+// stat returns the file info of the file with the specified name, or nil if it doesn't exist.
+func (b *backend) stat(name string) (os.FileInfo, error) {
+	info, err := os.Stat(path.Join(b.path, name))
 
-		return "", fmt.Errorf("failed to read store: %w", err)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat store: %w", err)
 	}
 
-	// Jump back to the start of the file so that we can overwrite the existing item list.
-	if _, err = f.Seek(0, io.SeekStart); err != nil {
-		_ = os.Remove(imgPath)
-		_ = f.Close()
+	return info, nil
+}
 
-		return "", fmt.Errorf("failed to seek to start of file: %w", err)
+// unchanged returns whether a file is the same as it was when it was last read or written.
+//
+// The index is replaced with a new file whenever it's written and the journal only ever grows (or is removed), so the
+// same file with the same size and modification time means that nothing has changed.
+func unchanged(info, cached os.FileInfo) bool {
+	if info == nil || cached == nil {
+		return info == nil && cached == nil
 	}
 
-	items = append(items, id)
+	return os.SameFile(info, cached) && info.Size() == cached.Size() && info.ModTime().Equal(cached.ModTime())
+}
 
-	// Write the new item list.
-	if err = json.NewEncoder(f).Encode(items); err != nil {
-		_ = os.Remove(imgPath)
-		_ = f.Close()
+// load reads the records from disk.
+//
+// The records are only decoded if they have changed since they were last read or written, otherwise the cached
+// records are returned. The returned index must only be modified through commit.
+func (b *backend) load() (*core.Index, error) {
+	// Multiple reads can happen at once, so the cache needs a lock of its own.
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
 
-		return "", fmt.Errorf("failed to write store: %w", err)
+	indexInfo, err := b.stat("index.json")
+
+	if err != nil {
+		return nil, err
 	}
 
-	// Close the file.
-	if err = f.Close(); err != nil {
-		_ = os.Remove(imgPath)
+	journalInfo, err := b.stat("index.journal")
 
-		return "", fmt.Errorf("failed to close file: %w", err)
+	if err != nil {
+		return nil, err
 	}
 
-	return id, nil
-}
+	if b.cache != nil && unchanged(indexInfo, b.indexInfo) && unchanged(journalInfo, b.journalInfo) {
+		return b.cache, nil
+	}
 
-// All returns all moodboard items in the collection.
-func (s *Store) All() ([]string, error) {
-	// We're only going to be reading from the disk - lock for reading.
-	s.mutex.RLock()
+	// If the index doesn't exist then we don't have anything stored yet.
+	idx := &core.Index{}
 
-	// Unlock once we're done.
-	defer s.mutex.RUnlock()
+	if indexInfo != nil {
+		buf, err := ioutil.ReadFile(path.Join(b.path, "index.json"))
 
-	// Open the file as read-only.
-	f, err := os.Open(path.Join(s.path, "index.json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read store: %w", err)
+		}
 
-	// If the file doesn't exist then we can exit early (as there's nothing to list).
-	if os.IsNotExist(err) {
-		return nil, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to open store: %w", err)
+		if idx, err = decode(buf); err != nil {
+			return nil, err
+		}
 	}
 
-	var items []string
+	var (
+		journalSize    int64
+		journalSkipped int
+	)
 
-	// Read the current item list.
-	if err = json.NewDecoder(f).Decode(&items); err != nil && err != io.EOF {
-		_ = f.Close()
+	if journalInfo != nil {
+		buf, err := ioutil.ReadFile(path.Join(b.path, "index.journal"))
 
-		return nil, fmt.Errorf("failed to read store: %w", err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		journalSize, journalSkipped = replay(idx, buf)
 	}
 
-	// We can ignore close errors here as we haven't written to the file.
-	_ = f.Close()
+	b.cache = idx
+	b.indexInfo = indexInfo
+	b.journalInfo = journalInfo
+	b.journalSize = journalSize
+	b.journalSkipped = journalSkipped
 
-	return items, nil
+	return idx, nil
 }
 
-// GetImage returns the image for the specified moodboard item in the collection.
+// replay applies the entries in a journal to an index, returning the size of the entries which were applied and the
+// number of complete entries which weren't.
 //
-// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
-func (s *Store) GetImage(id string) (io.Reader, error) {
-	// We're only going to be reading from the disk - lock for reading.
-	s.mutex.RLock()
+// Each entry is a single line. A crash part of the way through writing an entry leaves an incomplete line at the end
+// of the journal, which is ignored (and replaced by the next entry to be written). Replaying stops at the first
+// complete entry which can't be decoded, as the entries after it build on the changes that it made, and the store is
+// opened with what came before it.
+func replay(idx *core.Index, buf []byte) (int64, int) {
+	var size int64
+
+	for {
+		end := bytes.IndexByte(buf[size:], '\n')
+
+		if end == -1 {
+			return size, 0
+		}
 
-	// Unlock once we're done.
-	defer s.mutex.RUnlock()
+		var changes core.Changes
 
-	// Open the file as read-only.
-	f, err := os.OpenFile(path.Join(s.path, "index.json"), os.O_RDONLY, 0)
+		if err := json.Unmarshal(buf[size:size+int64(end)], &changes); err != nil {
+			return size, bytes.Count(buf[size:], []byte("\n"))
+		}
 
-	// If the file doesn't exist then we can exit early (as we don't have any images).
-	if os.IsNotExist(err) {
-		return nil, moodboard.ErrNoSuchItem
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to open store: %w", err)
+		changes.Apply(idx)
+		size += int64(end) + 1
 	}
+}
 
-	var items []string
-
-	// Read the current item list.
-	if err = json.NewDecoder(f).Decode(&items); err != nil {
-		_ = f.Close()
-
-		// If it's an EOF error then we can ignore the error and exit early (as the file is empty).
-		if err == io.EOF {
-			return nil, moodboard.ErrNoSuchItem
-		}
+// decode decodes an index.
+func decode(buf []byte) (*core.Index, error) {
+	buf = bytes.TrimSpace(buf)
 
-		return nil, fmt.Errorf("failed to read store: %w", err)
+	// An empty file is the same as an empty index.
+	if len(buf) == 0 {
+		return &core.Index{}, nil
 	}
 
-	// We can ignore close errors here as we haven't written to the file.
-	_ = f.Close()
+	// Indexes from before boards existed are a list of item IDs - move them to a board of their own.
+	if buf[0] == '[' {
+		var ids []string
 
-	var exists bool
+		if err := json.Unmarshal(buf, &ids); err != nil {
+			return nil, fmt.Errorf("failed to decode store: %w", err)
+		}
 
-	for _, item := range items {
-		if item == id {
-			exists = true
-			break
+		idx := &core.Index{
+			BoardRecords: []core.Board{
+				{Board: moodboard.Board{ID: legacyBoardID, Name: "Default"}},
+			},
 		}
-	}
 
-	if !exists {
-		return nil, moodboard.ErrNoSuchItem
+		for i, id := range ids {
+			idx.ItemRecords = append(idx.ItemRecords, core.Item{
+				Item:     moodboard.Item{ID: id},
+				Board:    legacyBoardID,
+				Position: i,
+			})
+		}
+
+		return idx, nil
 	}
 
-	f, err = os.OpenFile(path.Join(s.path, id), os.O_RDONLY, 0)
+	var idx core.Index
 
-	if os.IsNotExist(err) {
-		return nil, moodboard.ErrNoSuchItem
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to open image: %w", err)
+	if err := json.Unmarshal(buf, &idx); err != nil {
+		return nil, fmt.Errorf("failed to decode store: %w", err)
 	}
 
-	return f, nil
+	return &idx, nil
 }
 
-// move moves a moodboard item before or after another one in the collection.
-func (s *Store) move(id, targetID string, before bool) error {
-	// We're going to be writing to disk - lock for writing.
-	s.mutex.Lock()
+// syncDir flushes changes to the entries of the directory at the specified path to disk.
+func syncDir(name string) error {
+	d, err := os.Open(name)
 
-	// Unlock once we're done.
-	defer s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
 
-	// Open the file as R/W.
-	f, err := os.OpenFile(path.Join(s.path, "index.json"), os.O_RDWR, 0)
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
 
-	// If the file doesn't exist then we can exit early (as there's nothing to delete).
-	if os.IsNotExist(err) {
-		return moodboard.ErrNoSuchItem
-	} else if err != nil {
-		return fmt.Errorf("failed to open store: %w", err)
+		return fmt.Errorf("failed to sync directory: %w", err)
 	}
 
-	var items []string
+	// We can ignore close errors here as we haven't written to the directory.
+	_ = d.Close()
 
-	// Read the current item list.
-	if err = json.NewDecoder(f).Decode(&items); err != nil {
-		_ = f.Close()
+	return nil
+}
+
+// save writes the index to disk.
+//
+// The index is written to a temporary file which is then renamed over the existing index, which means that the index
+// on disk is always either the old version or the new version - even if we crash or run out of space part of the way
+// through writing it.
+func (b *backend) save(idx *core.Index) error {
+	tmpPath := path.Join(b.path, ".index.json.tmp")
 
-		// If it's an EOF error then we can ignore the error and exit early (as the file is empty).
-		if err == io.EOF {
-			return moodboard.ErrNoSuchItem
+	// Open the temporary file, replacing anything left behind by an earlier failure.
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+
+	// If the file doesn't exist, try making the containing directory.
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(b.path, 0o777); err != nil {
+			return fmt.Errorf("failed to create path: %w", err)
 		}
 
-		return fmt.Errorf("failed to read store: %w", err)
+		// Re-open the file now that we've created the containing directory.
+		f, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
 	}
 
-	index := -1
-	target := -1
+	if err != nil {
+		return fmt.Errorf("failed to open temporary store: %w", err)
+	}
 
-	// Find the indexes of the items we're moving.
-	for i, item := range items {
-		if item == id {
-			index = i
-		}
+	var w io.Writer = f
 
-		if item == targetID {
-			target = i
-		}
+	// Allow tests to intercept the write.
+	if b.wrapWriter != nil {
+		w = b.wrapWriter(w)
+	}
 
-		// We can break early if we've found both indexes.
-		if index != -1 && target != -1 {
-			break
-		}
+	// Write the new index.
+	if err = json.NewEncoder(w).Encode(idx); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("failed to write store: %w", err)
 	}
 
-	// If either of the indexes is missing, return an error.
-	if index == -1 || target == -1 {
+	// Make sure the new index has actually made it to disk before we replace the old one.
+	if err = f.Sync(); err != nil {
 		_ = f.Close()
+		_ = os.Remove(tmpPath)
 
-		return moodboard.ErrNoSuchItem
+		return fmt.Errorf("failed to sync store: %w", err)
 	}
 
-	item := items[index]
+	if err = f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
 
-	if index < target {
-		// If we're moving the item before the target and it's already before the target then we need to take
-		// 1 off the target to ensure we don't insert it after the target.
-		if before {
-			target--
-		}
+		return fmt.Errorf("failed to close store: %w", err)
+	}
 
-		// index = 1
-		// target = 4
-		//
-		// 0 1 2 3 4 5
-		// -----------
-		// A B C D E F
-		// |  / / /  |
-		// A C D E X F
-		// | | | | | |
-		// A C D E B F
-		copy(items[index:], items[index+1:target+1])
-	} else if index > target {
-		// If we're moving the item after the target and it's already after the target then we need to add 1
-		// to the target to ensure we don't insert it before the target.
-		if !before {
-			target++
-		}
+	// Replace the old index with the new one.
+	if err = os.Rename(tmpPath, path.Join(b.path, "index.json")); err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("failed to replace store: %w", err)
+	}
+
+	// Make sure the rename has made it to disk.
+	return syncDir(b.path)
+}
+
+// appendJournal writes an entry to the end of the journal.
+//
+// If the write fails then the journal is truncated back to the end of the last complete entry, so that the entry
+// isn't replayed.
+func (b *backend) appendJournal(changes core.Changes) error {
+	buf, err := json.Marshal(changes)
 
-		// move([]string{"A", "B", "C", "D", "E", "F"}, 4, 1)
-		//
-		// 0 1 2 3 4 5
-		// -----------
-		// A B C D E F
-		// |  \ \ \  |
-		// A X B C D F
-		// | | | | | |
-		// A E B C D F
-		copy(items[target+1:], items[target:index])
+	if err != nil {
+		return fmt.Errorf("failed to encode journal: %w", err)
 	}
 
-	items[target] = item
+	name := path.Join(b.path, "index.journal")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0o666)
+
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
 
-	// Jump back to the start of the file so that we can overwrite the existing item list.
-	if _, err = f.Seek(0, io.SeekStart); err != nil {
+	// Drop anything after the last complete entry, such as an entry which was being written when we crashed.
+	if err := f.Truncate(b.journalSize); err != nil {
 		_ = f.Close()
 
-		return fmt.Errorf("failed to seek to start of file: %w", err)
+		return fmt.Errorf("failed to truncate journal: %w", err)
 	}
 
-	// Write the modified item list.
-	if err = json.NewEncoder(f).Encode(items); err != nil {
-		_ = f.Close()
+	var w io.Writer = f
 
-		return fmt.Errorf("failed to write store: %w", err)
+	// Allow tests to intercept the write.
+	if b.wrapWriter != nil {
+		w = b.wrapWriter(w)
 	}
 
-	// Work out our current position so that we can truncate the remainder of the file.
-	pos, err := f.Seek(0, io.SeekCurrent)
+	_, err = f.Seek(b.journalSize, io.SeekStart)
+
+	if err == nil {
+		_, err = w.Write(append(buf, '\n'))
+	}
 
 	if err != nil {
+		_ = f.Truncate(b.journalSize)
 		_ = f.Close()
 
-		return fmt.Errorf("failed to find position in file: %w", err)
+		return fmt.Errorf("failed to write journal: %w", err)
 	}
 
-	// Truncate the remainder of the file.
-	if err = f.Truncate(pos); err != nil {
+	if err := f.Sync(); err != nil {
+		_ = f.Truncate(b.journalSize)
 		_ = f.Close()
 
-		return fmt.Errorf("failed to truncate file: %w", err)
+		return fmt.Errorf("failed to sync journal: %w", err)
 	}
 
-	// Close the file.
-	if err = f.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %w", err)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
 	}
 
+	// Make sure the journal itself has made it to disk if we've just created it.
+	if b.journalSize == 0 {
+		if err := syncDir(b.path); err != nil {
+			return err
+		}
+	}
+
+	b.journalSize += int64(len(buf)) + 1
+
 	return nil
 }
 
-// MoveBefore moves a moodboard item before another one in the collection.
+// compact folds the journal back into the index.
 //
-// This method will return moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist.
-func (s *Store) MoveBefore(id string, beforeID string) error {
-	return s.move(id, beforeID, true)
+// Replaying the journal on top of an index which already contains its changes leaves the index as it was, so nothing
+// is lost if we crash between replacing the index and removing the journal. A journal with entries which couldn't be
+// replayed is kept as index.journal.damaged (replacing any earlier one) rather than being removed, so that they can
+// be recovered by hand.
+func (b *backend) compact() error {
+	if err := b.save(b.cache); err != nil {
+		return err
+	}
+
+	name := path.Join(b.path, "index.journal")
+
+	if b.journalSkipped > 0 {
+		if err := os.Rename(name, name+".damaged"); err != nil {
+			return fmt.Errorf("failed to set aside journal: %w", err)
+		}
+	} else if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+
+	b.journalSize = 0
+	b.journalSkipped = 0
+
+	return syncDir(b.path)
 }
 
-// MoveAfter moves a moodboard item after another one in the collection.
+// commit writes the changes made by a transaction to disk, and applies them to the cached records.
 //
-// This method will return moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist.
-func (s *Store) MoveAfter(id string, afterID string) error {
-	return s.move(id, afterID, false)
+// The records must have been loaded first. A journal with entries which couldn't be replayed is compacted by the next
+// commit, even if nothing has changed, as appending to it would drop them.
+func (b *backend) commit(changes core.Changes) error {
+	if changes.Empty() && b.journalSkipped == 0 {
+		return nil
+	}
+
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+
+	var err error
+
+	if b.indexInfo == nil {
+		// The journal only makes sense on top of an index, so the first change writes the index itself.
+		changes.Apply(b.cache)
+		err = b.save(b.cache)
+	} else if b.journalSkipped > 0 {
+		changes.Apply(b.cache)
+		err = b.compact()
+	} else if err = b.appendJournal(changes); err == nil {
+		changes.Apply(b.cache)
+
+		// Keep the journal from growing forever. The changes have already been written, so we don't need to fail if
+		// this does.
+		if b.journalSize > minCompactSize && b.journalSize > b.indexInfo.Size() {
+			_ = b.compact()
+		}
+	}
+
+	// Keep hold of what we just wrote, so that the next read doesn't need to decode it. If anything went wrong or we
+	// can't tell what the files look like then the next read will just decode them again.
+	if err == nil {
+		b.indexInfo, err = b.stat("index.json")
+	}
+
+	if err == nil {
+		b.journalInfo, err = b.stat("index.journal")
+	}
+
+	if err != nil {
+		b.cache = nil
+	}
+
+	return err
+}
+
+// View runs fn within a read-only transaction.
+func (b *backend) View(ctx context.Context, fn func(core.Tx) error) error {
+	// We're only going to be reading from the disk - lock for reading.
+	b.mutex.RLock()
+
+	// Unlock once we're done.
+	defer b.mutex.RUnlock()
+
+	// We may have been waiting on the lock for a while - make sure we still need to run.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	idx, err := b.load()
+
+	if err != nil {
+		return err
+	}
+
+	return fn(&tx{Overlay: core.NewOverlay(idx), backend: b})
 }
 
-// Delete removes a moodboard item from the collection.
+// Update runs fn within a read-write transaction.
 //
-// This method will return moodboard.ErrNoSuchItem an item with the specified ID does not exist.
-func (s *Store) Delete(id string) error {
+// If fn returns an error then none of the changes made within the transaction are kept.
+func (b *backend) Update(ctx context.Context, fn func(core.Tx) error) error {
 	// We're going to be writing to disk - lock for writing.
-	s.mutex.Lock()
+	b.mutex.Lock()
 
 	// Unlock once we're done.
-	defer s.mutex.Unlock()
+	defer b.mutex.Unlock()
 
-	// Open the file as R/W.
-	f, err := os.OpenFile(path.Join(s.path, "index.json"), os.O_RDWR, 0)
+	// We may have been waiting on the lock for a while - make sure we still need to run.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	// If the file doesn't exist then we can exit early (as there's nothing to delete).
-	if os.IsNotExist(err) {
-		return moodboard.ErrNoSuchItem
-	} else if err != nil {
-		return fmt.Errorf("failed to open store: %w", err)
+	idx, err := b.load()
+
+	if err != nil {
+		return err
 	}
 
-	var items []string
+	t := &tx{Overlay: core.NewOverlay(idx), backend: b}
 
-	// Read the current item list.
-	if err = json.NewDecoder(f).Decode(&items); err != nil {
-		_ = f.Close()
+	err = fn(t)
 
-		// If it's an EOF error then we can ignore the error and exit early (as the file is empty).
-		if err == io.EOF {
-			return moodboard.ErrNoSuchItem
+	// Don't write the changes if the context was cancelled whilst we were running.
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	// Only write the changes if the transaction succeeded.
+	if err == nil {
+		err = b.commit(t.Changes())
+	}
+
+	// Remove any images we created if anything went wrong, as nothing refers to them.
+	if err != nil {
+		for _, name := range t.created {
+			_ = os.Remove(name)
 		}
 
-		return fmt.Errorf("failed to read store: %w", err)
+		return err
 	}
 
-	remainingItems := make([]string, 0, len(items))
+	// Now that the index no longer refers to them we can remove any deleted images.
+	//
+	// If the deletion fails then the image is just left behind, as the index has already been written.
+	for _, name := range t.deleted {
+		_ = os.Remove(name)
+	}
 
-	// Only keep items which do not match the ID provided.
-	for _, item := range items {
-		if item != id {
-			remainingItems = append(remainingItems, item)
+	return nil
+}
+
+// Report describes the inconsistencies between the index and the images on disk.
+type Report struct {
+	// Orphans contains the names of image files which are not referred to by the index.
+	Orphans []string
+
+	// Dangling contains the IDs of items in the index which do not have an image file.
+	Dangling []string
+
+	// SkippedEntries is the number of entries at the end of the journal which were left out of the index, as the
+	// first of them couldn't be decoded.
+	SkippedEntries int
+}
+
+// imageName returns whether a file is named like an image written by the store.
+//
+// Images are named after the ID of their item, with the size of thumbnails after a dot. Anything else in the directory
+// wasn't written by the store, so it's never treated as an orphan.
+func imageName(name string) bool {
+	id := name
+
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		id = name[:i]
+
+		if _, err := strconv.ParseUint(name[i+1:], 10, 0); err != nil {
+			return false
 		}
 	}
 
-	// If the number of items is the same then we haven't found anything to delete.
-	if len(items) == len(remainingItems) {
-		_ = f.Close()
+	_, err := uuid.Parse(id)
+
+	// Parse also accepts IDs in other forms (such as with braces around them), which the store never uses.
+	return err == nil && len(id) == 36
+}
+
+// OK returns whether the report is free of inconsistencies.
+func (r Report) OK() bool {
+	return len(r.Orphans) == 0 && len(r.Dangling) == 0 && r.SkippedEntries == 0
+}
 
-		return moodboard.ErrNoSuchItem
+// check looks for inconsistencies between the index and the images on disk, optionally fixing them.
+func (b *backend) check(ctx context.Context, repair bool) (Report, error) {
+	var report Report
+
+	// Only lock for writing if we're going to be fixing things.
+	if repair {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+	} else {
+		b.mutex.RLock()
+		defer b.mutex.RUnlock()
 	}
 
-	// Try deleting the image file.
-	//
-	// If the deletion fails because of some reason other than the file not existing, we return an error.
-	if err := os.Remove(path.Join(s.path, id)); err != nil && !os.IsNotExist(err) {
-		_ = f.Close()
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
 
-		return fmt.Errorf("failed to delete image: %w", err)
+	// The store starts off empty if there's no index, which would make every image look orphaned.
+	if _, err := os.Stat(path.Join(b.path, "index.json")); os.IsNotExist(err) {
+		return Report{}, ErrNoIndex
+	} else if err != nil {
+		return Report{}, fmt.Errorf("failed to read index: %w", err)
 	}
 
-	// Jump back to the start of the file so that we can overwrite the existing item list.
-	if _, err = f.Seek(0, io.SeekStart); err != nil {
-		_ = f.Close()
+	idx, err := b.load()
 
-		return fmt.Errorf("failed to seek to start of file: %w", err)
+	if err != nil {
+		return Report{}, err
 	}
 
-	// Write the new item list.
-	if err = json.NewEncoder(f).Encode(remainingItems); err != nil {
-		_ = f.Close()
+	// Other reads can load the records at the same time, so we need to hold the cache lock to look at them.
+	b.cacheMutex.Lock()
+	report.SkippedEntries = b.journalSkipped
+	b.cacheMutex.Unlock()
 
-		return fmt.Errorf("failed to write store: %w", err)
+	infos, err := ioutil.ReadDir(b.path)
+
+	// If the directory doesn't exist then we can't have any images.
+	if err != nil && !os.IsNotExist(err) {
+		return Report{}, fmt.Errorf("failed to list images: %w", err)
 	}
 
-	// Work out our current position so that we can truncate the remainder of the file.
-	pos, err := f.Seek(0, io.SeekCurrent)
+	images := make(map[string]bool, len(infos))
 
-	if err != nil {
-		_ = f.Close()
+	for _, info := range infos {
+		// Skip anything which can't be an image.
+		if info.IsDir() || info.Name() == "index.json" || info.Name() == ".index.json.tmp" ||
+			strings.HasPrefix(info.Name(), "index.journal") {
+			continue
+		}
 
-		return fmt.Errorf("failed to find position in file: %w", err)
+		images[info.Name()] = true
 	}
 
-	// Truncate the remainder of the file.
-	if err = f.Truncate(pos); err != nil {
-		_ = f.Close()
+	// referenced contains the names of all of the images which are referred to by an item.
+	referenced := make(map[string]bool, len(idx.ItemRecords))
+
+	for _, item := range idx.ItemRecords {
+		referenced[item.ID] = true
 
-		return fmt.Errorf("failed to truncate file: %w", err)
+		// The thumbnails of a dangling item are orphaned, as the item will be removed when it's repaired.
+		if !images[item.ID] {
+			report.Dangling = append(report.Dangling, item.ID)
+
+			continue
+		}
+
+		// Thumbnails are stored alongside the original image. A missing thumbnail doesn't matter, as the original is
+		// served instead.
+		for _, id := range item.ThumbnailIDs() {
+			referenced[id] = true
+		}
 	}
 
-	// Close the file.
-	if err = f.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %w", err)
+	for _, info := range infos {
+		if images[info.Name()] && !referenced[info.Name()] && imageName(info.Name()) {
+			report.Orphans = append(report.Orphans, info.Name())
+		}
 	}
 
-	return nil
+	if !repair {
+		return report, nil
+	}
+
+	// Drop the dangling items first, as that's the only part which can fail in a way that matters. Committing also sets
+	// aside a damaged journal.
+	if len(report.Dangling) > 0 || report.SkippedEntries > 0 {
+		t := &tx{Overlay: core.NewOverlay(idx), backend: b}
+
+		for _, id := range report.Dangling {
+			if err := core.DropItem(t, id); err != nil {
+				return Report{}, err
+			}
+		}
+
+		if err := b.commit(t.Changes()); err != nil {
+			return Report{}, err
+		}
+	}
+
+	for _, name := range report.Orphans {
+		if err := os.Remove(path.Join(b.path, name)); err != nil && !os.IsNotExist(err) {
+			return Report{}, fmt.Errorf("failed to delete image: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+// Store represents an on-disk collection of moodboards.
+type Store struct {
+	*core.Store
+	backend *backend
+}
+
+// Check looks for inconsistencies between the index and the images on disk.
+//
+// Images can be orphaned if the store fails part of the way through creating an item, and items can be left dangling
+// if their image is removed from outside of the store. Only files which are named like images are checked. Entries in
+// the journal which couldn't be replayed when the store was opened are reported as well.
+//
+// This method will return ErrNoIndex if the directory doesn't contain an index.
+func (s *Store) Check(ctx context.Context) (Report, error) {
+	return s.backend.check(ctx, false)
+}
+
+// Repair fixes inconsistencies between the index and the images on disk, returning what was fixed.
+//
+// Orphaned images are deleted, and dangling items are removed from the index along with their history. A journal with
+// entries which couldn't be replayed is folded into the index without them, and kept as index.journal.damaged.
+//
+// This method will return ErrNoIndex if the directory doesn't contain an index, rather than deleting every image.
+func (s *Store) Repair(ctx context.Context) (Report, error) {
+	return s.backend.check(ctx, true)
 }
 
 // NewStore creates a new moodboard collection, backed by the directory at the specified path.
 func NewStore(path string) *Store {
-	return &Store{path: path}
+	b := &backend{path: path}
+
+	return &Store{Store: core.NewStore(b), backend: b}
 }
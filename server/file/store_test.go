@@ -2,9 +2,13 @@ package file_test
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"github.com/google/uuid"
 	"github.com/jackwilsdon/moodboard"
 	"github.com/jackwilsdon/moodboard/file"
+	"github.com/jackwilsdon/moodboard/storetest"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"os"
 	"path"
@@ -15,491 +19,439 @@ import (
 //
 // A temporary directory is used to back the store, which is cleaned up once the test and all its subtests complete.
 func newStore(t *testing.T) *file.Store {
-	dir, err := ioutil.TempDir("", "")
+	dir := storetest.TempDir(t)
+
+	return file.NewStore(path.Join(dir, "data"))
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) moodboard.Store {
+		return newStore(t)
+	})
+}
+
+func TestStoreLegacyIndex(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	// Indexes from before boards existed only contain a list of item IDs.
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), []byte(`["first","second"]`), 0o666); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+
+	boards, err := s.Boards(ctx)
 
 	if err != nil {
-		t.Fatalf("failed to create temporary directory: %v", err)
+		t.Fatalf("failed to get boards: %v", err)
 	}
 
-	// Delete the directory at the end of the test.
-	t.Cleanup(func() {
-		_ = os.RemoveAll(dir)
-	})
+	if len(boards) != 1 {
+		t.Fatalf("expected to get 1 board but got %d", len(boards))
+	}
 
-	return file.NewStore(path.Join(dir, "data"))
+	all, err := s.All(ctx, boards[0].ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 || all[0].ID != "first" || all[1].ID != "second" {
+		t.Fatalf("expected all to be [\"first\", \"second\"] but got %v", all)
+	}
 }
 
-func TestStoreCreate(t *testing.T) {
-	s := newStore(t)
+func TestStoreCheck(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+	boardID := storetest.NewBoard(t, s)
+
+	keptID, err := s.Create(ctx, boardID, bytes.NewReader(nil))
 
-	firstID, err := s.Create(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	danglingID, err := s.Create(ctx, boardID, bytes.NewReader(nil))
 
 	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if report, err := s.Check(ctx); err != nil || !report.OK() {
+		t.Fatalf("expected result to be [OK, nil] but got [%v, %q]", report, err)
+	}
+
+	// Remove an image from underneath the store, and add one which the store doesn't know about.
+	if err := os.Remove(path.Join(dir, danglingID)); err != nil {
+		t.Fatalf("failed to remove image: %v", err)
+	}
+
+	orphanID := uuid.New().String()
+
+	if err := ioutil.WriteFile(path.Join(dir, orphanID), nil, 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	// Files which aren't named like images should be left alone.
+	if err := ioutil.WriteFile(path.Join(dir, "notes.txt"), nil, 0o666); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	for _, repair := range []bool{false, true} {
+		var report file.Report
+
+		if repair {
+			report, err = s.Repair(ctx)
+		} else {
+			report, err = s.Check(ctx)
+		}
+
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err)
+		}
+
+		if len(report.Orphans) != 1 || report.Orphans[0] != orphanID {
+			t.Fatalf("expected orphans to be [%q] but got %q", orphanID, report.Orphans)
+		}
+
+		if len(report.Dangling) != 1 || report.Dangling[0] != danglingID {
+			t.Fatalf("expected dangling to be [%q] but got %q", danglingID, report.Dangling)
+		}
+	}
+
+	if report, err := s.Check(ctx); err != nil || !report.OK() {
+		t.Fatalf("expected result to be [OK, nil] but got [%v, %q]", report, err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, orphanID)); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan to have been removed but got %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "notes.txt")); err != nil {
+		t.Fatalf("expected file to have been kept but got %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 1 || all[0].ID != keptID {
+		t.Fatalf("expected all to be [%q] but got %v", keptID, all)
+	}
+
+	// The dangling item should have been removed from the history, so that it doesn't stop anything being undone.
+	if err := s.Undo(ctx, boardID); err != nil {
 		t.Fatalf("expected error to be nil but got %q", err)
 	}
 
-	secondID, err := s.Create(bytes.NewReader(nil))
+	if err := s.Undo(ctx, boardID); err != moodboard.ErrNothingToUndo {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNothingToUndo, err)
+	}
+}
+
+func TestStoreCheckNoIndex(t *testing.T) {
+	dir := storetest.TempDir(t)
+	name := path.Join(dir, uuid.New().String())
+
+	if err := ioutil.WriteFile(name, nil, 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+
+	// Without an index every image would look orphaned, so nothing should be checked or removed.
+	if _, err := s.Check(ctx); err != file.ErrNoIndex {
+		t.Fatalf("expected error to be %q but got %q", file.ErrNoIndex, err)
+	}
+
+	if _, err := s.Repair(ctx); err != file.ErrNoIndex {
+		t.Fatalf("expected error to be %q but got %q", file.ErrNoIndex, err)
+	}
+
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("expected image to have been kept but got %v", err)
+	}
+}
+
+func TestStoreUndoPersisted(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+	boardID := storetest.NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
 
 	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	title := "title"
+
+	if _, err := s.Update(ctx, boardID, id, moodboard.ItemUpdate{Title: &title}); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+
+	// The history should be available to a new store using the same directory.
+	s = file.NewStore(dir)
+
+	if err := s.Undo(ctx, boardID); err != nil {
 		t.Fatalf("expected error to be nil but got %q", err)
 	}
 
-	all, err := s.All()
+	all, err := s.All(ctx, boardID)
 
 	if err != nil {
 		t.Fatalf("failed to get store contents: %v", err)
 	}
 
-	if len(all) != 2 {
-		t.Fatalf("expected to get 2 items but got %d", len(all))
+	if len(all) != 1 || all[0].Title != "" {
+		t.Fatalf("expected all to contain an item without a title but got %v", all)
 	}
 
-	if all[0] != firstID || all[1] != secondID {
-		t.Fatalf("expected all to be [%q, %q] but got [%q, %q]", firstID, secondID, all[0], all[1])
+	if err := file.NewStore(dir).Redo(ctx, boardID); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
 	}
 }
 
-func TestStoreGetImage(t *testing.T) {
-	cs := []struct {
-		name   string
-		create int
-		get    int
-		err    error
-	}{
-		{
-			name:   "get",
-			create: 3,
-			get:    0,
-			err:    nil,
-		},
-		{
-			name:   "get nonexistent",
-			create: 0,
-			get:    -1,
-			err:    moodboard.ErrNoSuchItem,
-		},
-	}
-
-	for _, c := range cs {
-		t.Run(c.name, func(t *testing.T) {
-			s := newStore(t)
-
-			var targetID string
-			var expectedImg []byte
-
-			for i := 0; i < c.create; i++ {
-				img := []byte(fmt.Sprintf("image %d", i))
-				id, err := s.Create(bytes.NewReader(img))
-
-				if err != nil {
-					t.Fatalf("failed to create item: %v", err)
-				}
-
-				if i == c.get {
-					targetID = id
-					expectedImg = img
-				}
-			}
-
-			img, err := s.GetImage(targetID)
-
-			switch {
-			case err != nil && c.err == nil:
-				t.Fatalf("expected error to be nil but got %q", err)
-			case err == nil && c.err != nil:
-				t.Fatalf("expected error to be %q but got nil", c.err)
-			case err != c.err:
-				t.Fatalf("expected error to be %q but got %q", c.err, err)
-			}
-
-			if err == nil {
-				imgBytes, err := ioutil.ReadAll(img)
-
-				if err != nil {
-					t.Fatalf("failed to read returned image: %v", err)
-				}
-
-				if !bytes.Equal(imgBytes, expectedImg) {
-					t.Fatalf("expected returned reader to read %q but got %q", expectedImg, imgBytes)
-				}
-			}
-		})
+func TestStoreThumbnailFiles(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+	boardID := storetest.NewBoard(t, s)
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	id, err := s.Create(ctx, boardID, &buf)
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	// Thumbnails should be stored next to the original.
+	for _, name := range []string{id, id + ".256", id + ".512"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Fatalf("expected %q to exist but got %v", name, err)
+		}
+	}
+
+	if report, err := s.Check(ctx); err != nil || !report.OK() {
+		t.Fatalf("expected result to be [OK, nil] but got [%v, %q]", report, err)
+	}
+
+	// Thumbnails which don't belong to an item are orphaned.
+	orphan := uuid.New().String() + ".256"
+
+	if err := ioutil.WriteFile(path.Join(dir, orphan), nil, 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	if report, err := s.Repair(ctx); err != nil || len(report.Orphans) != 1 || report.Orphans[0] != orphan {
+		t.Fatalf("expected result to be [[%q], nil] but got [%v, %q]", orphan, report.Orphans, err)
+	}
+
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	// Purging the item should remove its thumbnails too.
+	for _, name := range []string{id, id + ".256", id + ".512"} {
+		if _, err := os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %q to have been removed but got %v", name, err)
+		}
 	}
 }
 
-func TestStoreMoveBefore(t *testing.T) {
-	cs := []struct {
-		name   string
-		index  int
-		before int
-		order  []int
-		err    error
-	}{
-		{
-			name:   "move first before first",
-			index:  0,
-			before: 0,
-			order:  []int{0, 1, 2},
-		},
-		{
-			name:   "move first before second",
-			index:  0,
-			before: 1,
-			order:  []int{0, 1, 2},
-		},
-		{
-			name:   "move first before third",
-			index:  0,
-			before: 2,
-			order:  []int{1, 0, 2},
-		},
-		{
-			name:   "move second before first",
-			index:  1,
-			before: 0,
-			order:  []int{1, 0, 2},
-		},
-		{
-			name:   "move second before second",
-			index:  1,
-			before: 1,
-			order:  []int{0, 1, 2},
-		},
-		{
-			name:   "move second before third",
-			index:  1,
-			before: 2,
-			order:  []int{0, 1, 2},
-		},
-		{
-			name:   "move third before first",
-			index:  2,
-			before: 0,
-			order:  []int{2, 0, 1},
-		},
-		{
-			name:   "move third before second",
-			index:  2,
-			before: 1,
-			order:  []int{0, 2, 1},
-		},
-		{
-			name:   "move third before third",
-			index:  2,
-			before: 2,
-			order:  []int{0, 1, 2},
-		},
-		{
-			name:   "move non-existent before first",
-			index:  -1,
-			before: 0,
-			order:  []int{0, 1, 2},
-			err:    moodboard.ErrNoSuchItem,
-		},
-		{
-			name:   "move first before non-existent",
-			index:  0,
-			before: -1,
-			order:  []int{0, 1, 2},
-			err:    moodboard.ErrNoSuchItem,
-		},
-	}
-
-	for _, c := range cs {
-		t.Run(c.name, func(t *testing.T) {
-			s := newStore(t)
-
-			var targetID string
-			var beforeID string
-
-			indexes := make(map[string]int)
-
-			for i := 0; i < len(c.order); i++ {
-				id, err := s.Create(bytes.NewReader(nil))
-
-				if err != nil {
-					t.Fatalf("failed to create item: %v", err)
-				}
-
-				if c.index == i {
-					targetID = id
-				}
-
-				if c.before == i {
-					beforeID = id
-				}
-
-				indexes[id] = i
-			}
-
-			switch err := s.MoveBefore(targetID, beforeID); {
-			case err != nil && c.err == nil:
-				t.Fatalf("expected error to be nil but got %q", err)
-			case err == nil && c.err != nil:
-				t.Fatalf("expected error to be %q but got nil", c.err)
-			case err != c.err:
-				t.Fatalf("expected error to be %q but got %q", c.err, err)
-			}
-
-			all, err := s.All()
-
-			if err != nil {
-				t.Fatalf("failed to get store contents: %v", err)
-			}
-
-			if len(all) != len(c.order) {
-				verb := "items"
-
-				if len(c.order) == 1 {
-					verb = "item"
-				}
-
-				t.Fatalf("expected to get %d %s but got %d", len(c.order), verb, len(all))
-			}
-
-			for i, order := range c.order {
-				id := all[i]
-				index, ok := indexes[id]
-
-				if !ok {
-					t.Fatalf("no initial index for %q", id)
-				}
-
-				if index != order {
-					t.Errorf("expected %q to be at index %d but got %d", id, order, i)
-				}
-			}
-		})
+func TestStoreBackfill(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	// Items from before dimensions and palettes were recorded don't have any.
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), []byte(`["first","second","missing"]`), 0o666); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 400))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, "first"), buf.Bytes(), 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, "second"), []byte("image"), 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+
+	// Images which can't be decoded or which are missing should be skipped.
+	if n, err := s.Backfill(ctx); err != nil || n != 1 {
+		t.Fatalf("expected result to be [1, nil] but got [%d, %q]", n, err)
+	}
+
+	all, err := s.All(ctx, "default")
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 3 || all[0].Width != 100 || all[0].Height != 400 || all[0].AspectRatio != 0.25 {
+		t.Fatalf("expected first item to have dimensions [100, 400, 0.25] but got %v", all)
+	}
+
+	if all[1].Width != 0 || all[2].Width != 0 {
+		t.Fatalf("expected other items to have no dimensions but got %v", all[1:])
+	}
+
+	// The palette of the image should have been worked out too.
+	if len(all[0].Palette) != 1 || all[0].Palette[0] != (moodboard.Colour{Hex: "#000000", Weight: 1}) {
+		t.Fatalf("expected first item to have palette [{#000000 1}] but got %v", all[0].Palette)
+	}
+
+	// Running it again shouldn't find anything new.
+	if n, err := s.Backfill(ctx); err != nil || n != 0 {
+		t.Fatalf("expected result to be [0, nil] but got [%d, %q]", n, err)
 	}
 }
 
-func TestStoreMoveAfter(t *testing.T) {
-	cs := []struct {
-		name  string
-		index int
-		after int
-		order []int
-		err   error
-	}{
-		{
-			name:  "move first after first",
-			index: 0,
-			after: 0,
-			order: []int{0, 1, 2},
-		},
-		{
-			name:  "move first after second",
-			index: 0,
-			after: 1,
-			order: []int{1, 0, 2},
-		},
-		{
-			name:  "move first after third",
-			index: 0,
-			after: 2,
-			order: []int{1, 2, 0},
-		},
-		{
-			name:  "move second after first",
-			index: 1,
-			after: 0,
-			order: []int{0, 1, 2},
-		},
-		{
-			name:  "move second after second",
-			index: 1,
-			after: 1,
-			order: []int{0, 1, 2},
-		},
-		{
-			name:  "move second after third",
-			index: 1,
-			after: 2,
-			order: []int{0, 2, 1},
-		},
-		{
-			name:  "move third after first",
-			index: 2,
-			after: 0,
-			order: []int{0, 2, 1},
-		},
-		{
-			name:  "move third after second",
-			index: 2,
-			after: 1,
-			order: []int{0, 1, 2},
-		},
-		{
-			name:  "move third after third",
-			index: 2,
-			after: 2,
-			order: []int{0, 1, 2},
-		},
-		{
-			name:  "move non-existent after first",
-			index: -1,
-			after: 0,
-			order: []int{0, 1, 2},
-			err:   moodboard.ErrNoSuchItem,
-		},
-		{
-			name:  "move first after non-existent",
-			index: 0,
-			after: -1,
-			order: []int{0, 1, 2},
-			err:   moodboard.ErrNoSuchItem,
-		},
-	}
-
-	for _, c := range cs {
-		t.Run(c.name, func(t *testing.T) {
-			s := newStore(t)
-
-			var targetID string
-			var afterID string
-
-			indexes := make(map[string]int)
-
-			for i := 0; i < len(c.order); i++ {
-				id, err := s.Create(bytes.NewReader(nil))
-
-				if err != nil {
-					t.Fatalf("failed to create item: %v", err)
-				}
-
-				if c.index == i {
-					targetID = id
-				}
-
-				if c.after == i {
-					afterID = id
-				}
-
-				indexes[id] = i
-			}
-
-			switch err := s.MoveAfter(targetID, afterID); {
-			case err != nil && c.err == nil:
-				t.Fatalf("expected error to be nil but got %q", err)
-			case err == nil && c.err != nil:
-				t.Fatalf("expected error to be %q but got nil", c.err)
-			case err != c.err:
-				t.Fatalf("expected error to be %q but got %q", c.err, err)
-			}
-
-			all, err := s.All()
-
-			if err != nil {
-				t.Fatalf("failed to get store contents: %v", err)
-			}
-
-			if len(all) != len(c.order) {
-				verb := "items"
-
-				if len(c.order) == 1 {
-					verb = "item"
-				}
-
-				t.Fatalf("expected to get %d %s but got %d", len(c.order), verb, len(all))
-			}
-
-			for i, order := range c.order {
-				id := all[i]
-				index, ok := indexes[id]
-
-				if !ok {
-					t.Fatalf("no initial index for %q", id)
-				}
-
-				if index != order {
-					t.Errorf("expected %q to be at index %d but got %d", id, order, i)
-				}
-			}
-		})
+func TestStoreBackfillAnalysed(t *testing.T) {
+	dir := storetest.TempDir(t)
+	analysedID, undecodableID := uuid.New().String(), uuid.New().String()
+
+	// Items which were partly analysed by an earlier version can already have thumbnails, but no palette or hash.
+	index := `{"boards":[{"id":"default","name":"Default"}],"items":[` +
+		`{"id":"` + analysedID + `","board":"default","key":"a","width":512,"height":512,"thumbnails":[256]},` +
+		`{"id":"` + undecodableID + `","board":"default","key":"b"}]}`
+
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), []byte(index), 0o666); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	files := map[string][]byte{
+		analysedID:          buf.Bytes(),
+		analysedID + ".256": []byte("thumbnail"),
+		undecodableID:       []byte("RIFF\x0c\x00\x00\x00WEBPVP8 "),
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0o666); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+
+	if n, err := s.Backfill(ctx); err != nil || n != 1 {
+		t.Fatalf("expected result to be [1, nil] but got [%d, %q]", n, err)
+	}
+
+	// The existing thumbnail should have been kept, rather than failing to write over it.
+	thumb, err := ioutil.ReadFile(path.Join(dir, analysedID+".256"))
+
+	if err != nil {
+		t.Fatalf("failed to read thumbnail: %v", err)
+	}
+
+	if string(thumb) != "thumbnail" {
+		t.Fatalf("expected thumbnail to be %q but got %q", "thumbnail", thumb)
+	}
+
+	all, err := s.All(ctx, "default")
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 || all[0].Hash == "" || len(all[0].Palette) != 1 {
+		t.Fatalf("expected first item to have a hash and palette but got %v", all)
+	}
+
+	// Images which couldn't be decoded shouldn't be tried again, even if they could be decoded now.
+	if err := ioutil.WriteFile(path.Join(dir, undecodableID), buf.Bytes(), 0o666); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	if n, err := s.Backfill(ctx); err != nil || n != 0 {
+		t.Fatalf("expected result to be [0, nil] but got [%d, %q]", n, err)
 	}
 }
 
-func TestStoreDelete(t *testing.T) {
-	cs := []struct {
-		name   string
-		create int
-		delete int
-		err    error
-	}{
-		{
-			name:   "delete",
-			create: 3,
-			delete: 0,
-		},
-		{
-			name:   "delete nonexistent",
-			create: 1,
-			delete: -1,
-			err:    moodboard.ErrNoSuchItem,
-		}, {
-			name:   "delete empty",
-			delete: -1,
-			err:    moodboard.ErrNoSuchItem,
-		},
-	}
-
-	for _, c := range cs {
-		t.Run(c.name, func(t *testing.T) {
-			s := newStore(t)
-			items := make([]string, c.create)
-
-			for i := 0; i < c.create; i++ {
-				id, err := s.Create(bytes.NewReader(nil))
-
-				if err != nil {
-					t.Fatalf("failed to create item: %v", err)
-				}
-
-				items[i] = id
-			}
-
-			var id string
-
-			if c.delete != -1 {
-				id = items[c.delete]
-
-				// Move all items after the deleted one left.
-				copy(items[c.delete:], items[c.delete+1:])
-
-				// Remove the last (now duplicated) element.
-				items = items[:len(items)-1]
-			}
-
-			switch err := s.Delete(id); {
-			case err != nil && c.err == nil:
-				t.Fatalf("expected error to be nil but got %q", err)
-			case err == nil && c.err != nil:
-				t.Fatalf("expected error to be %q but got nil", c.err)
-			case err != c.err:
-				t.Fatalf("expected error to be %q but got %q", c.err, err)
-			}
-
-			all, err := s.All()
-
-			if err != nil {
-				t.Fatalf("failed to get store contents: %v", err)
-			}
-
-			if len(all) != len(items) {
-				verb := "items"
-
-				if len(items) == 1 {
-					verb = "item"
-				}
-
-				t.Fatalf("expected to get %d %s but got %d", len(items), verb, len(all))
-			}
-
-			for i := range all {
-				if all[i] != items[i] {
-					t.Errorf("expected all[%d] to be %v but got %v", i, items[i], all[i])
-				}
-			}
-		})
+func TestStoreBackfillDamaged(t *testing.T) {
+	dir := storetest.TempDir(t)
+	damagedID, goodID := uuid.New().String(), uuid.New().String()
+
+	index := `{"boards":[{"id":"default","name":"Default"}],"items":[` +
+		`{"id":"` + damagedID + `","board":"default","key":"a"},` +
+		`{"id":"` + goodID + `","board":"default","key":"b"}]}`
+
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), []byte(index), 0o666); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// The header of the damaged image is intact, so it only fails once the rest of it is decoded.
+	files := map[string][]byte{
+		damagedID: buf.Bytes()[:buf.Len()/2],
+		goodID:    buf.Bytes(),
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0o666); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	s := file.NewStore(dir)
+
+	// Both items get their dimensions, even though only one of them can be analysed.
+	if n, err := s.Backfill(ctx); err != nil || n != 2 {
+		t.Fatalf("expected result to be [2, nil] but got [%d, %q]", n, err)
+	}
+
+	all, err := s.All(ctx, "default")
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 || all[0].Width != 512 || all[0].Hash != "" || all[1].Hash == "" {
+		t.Fatalf("expected only the second item to have a hash but got %v", all)
+	}
+
+	// The damaged image shouldn't stop backfilling from finishing next time.
+	if n, err := s.Backfill(ctx); err != nil || n != 0 {
+		t.Fatalf("expected result to be [0, nil] but got [%d, %q]", n, err)
 	}
 }
@@ -2,4 +2,8 @@ module github.com/jackwilsdon/moodboard
 
 go 1.14
 
-require github.com/google/uuid v1.1.2
+require (
+	github.com/google/uuid v1.1.2
+	github.com/mattn/go-sqlite3 v1.14.6
+	go.etcd.io/bbolt v1.3.5
+)
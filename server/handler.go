@@ -6,8 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/jackwilsdon/moodboard/internal/limitio"
+	"github.com/jackwilsdon/moodboard/internal/metadata"
+	"github.com/jackwilsdon/moodboard/internal/sniff"
+	"github.com/jackwilsdon/moodboard/internal/svg"
 )
 
 // logger represents a simple logger.
@@ -17,19 +25,135 @@ type logger interface {
 
 // Handler is a HTTP handler for moodboard requests.
 type Handler struct {
+	// MaxUploadSize is the maximum size of each uploaded image in bytes. Images of any size are accepted if it is zero.
+	MaxUploadSize int64
+
+	// StripMetadata removes EXIF, XMP and IPTC metadata from uploaded JPEGs, PNGs and WebPs before they're stored,
+	// turning them the right way round first if the metadata says that they're rotated. AVIFs are rejected, as their
+	// metadata can't be removed.
+	StripMetadata bool
+
 	logger logger
 	store  Store
 }
 
 // validContentTypes is a list of allowed content types for uploaded images.
 var validContentTypes = []string{
+	"image/avif",
 	"image/gif",
 	"image/jpeg",
 	"image/png",
+	"image/svg+xml",
+	"image/webp",
+}
+
+// createBoard handles creating new boards.
+func (h *Handler) createBoard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept", "application/json")
+
+	// Make sure we have the right content type.
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+
+	// Try reading in the request, making sure that we were given a name.
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(strings.TrimSpace(body.Name)) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	board, err := h.store.CreateBoard(r.Context(), strings.TrimSpace(body.Name))
+
+	if err != nil {
+		// This error is unexpected - log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to create board: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(board)
+}
+
+// boards handles listing boards.
+func (h *Handler) boards(w http.ResponseWriter, r *http.Request) {
+	bs, err := h.store.Boards(r.Context())
+
+	// If we can't get a list of boards then log the error and return a generic error to the client.
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("failed to list boards: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	// If we don't have any boards then use a zero-length slice.
+	//
+	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
+	if bs == nil {
+		bs = make([]Board, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(bs)
+}
+
+// renameBoard handles renaming boards.
+func (h *Handler) renameBoard(w http.ResponseWriter, r *http.Request, boardID string) {
+	w.Header().Set("Accept", "application/json")
+
+	// Make sure we have the right content type.
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+
+	// Try reading in the request, making sure that we were given a name.
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(strings.TrimSpace(body.Name)) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	err := h.store.RenameBoard(r.Context(), boardID, strings.TrimSpace(body.Name))
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to rename board: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// deleteBoard handles deleting boards.
+func (h *Handler) deleteBoard(w http.ResponseWriter, r *http.Request, boardID string) {
+	err := h.store.DeleteBoard(r.Context(), boardID)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to delete board: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
-// create handles reordering moodboard items.
-func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
+// move handles reordering moodboard items.
+func (h *Handler) move(w http.ResponseWriter, r *http.Request, boardID, id string) {
 	w.Header().Set("Accept", "application/json")
 
 	// Make sure we have the right content type.
@@ -42,6 +166,8 @@ func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
 	var target struct {
 		Before string `json:"before"`
 		After  string `json:"after"`
+		Index  *int   `json:"index"`
+		To     string `json:"to"`
 	}
 
 	// Try reading in the request.
@@ -51,30 +177,49 @@ func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var err error
+	// We only want one of "before", "after", "index" or "to".
+	targets := 0
 
-	// The ID of the item being moved comes after "/move/".
-	id := r.URL.Path[6:]
+	for _, given := range []bool{len(target.Before) > 0, len(target.After) > 0, target.Index != nil, len(target.To) > 0} {
+		if given {
+			targets++
+		}
+	}
 
-	// We only want "before" or "after" - not both.
-	if len(target.Before) > 0 && len(target.After) > 0 {
+	// If we have more than one target, or none at all, then it's a bad request.
+	if targets != 1 {
 		w.WriteHeader(http.StatusBadRequest)
 
 		return
-	} else if len(target.Before) > 0 {
-		err = h.store.MoveBefore(id, target.Before)
-	} else if len(target.After) > 0 {
-		err = h.store.MoveAfter(id, target.After)
-	} else {
-		// If we lack both "before" and "after" then it's a bad request.
+	}
+
+	var err error
+
+	switch {
+	case len(target.Before) > 0:
+		err = h.store.MoveBefore(r.Context(), boardID, id, target.Before)
+	case len(target.After) > 0:
+		err = h.store.MoveAfter(r.Context(), boardID, id, target.After)
+	case target.Index != nil:
+		err = h.store.MoveToIndex(r.Context(), boardID, id, *target.Index)
+	case target.To == "start":
+		err = h.store.MoveToStart(r.Context(), boardID, id)
+	case target.To == "end":
+		err = h.store.MoveToEnd(r.Context(), boardID, id)
+	default:
 		w.WriteHeader(http.StatusBadRequest)
 
 		return
 	}
 
-	if errors.Is(err, ErrNoSuchItem) {
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
 		w.WriteHeader(http.StatusNotFound)
 
+		return
+	} else if errors.Is(err, ErrIndexOutOfRange) {
+		// Let the client know which indexes are valid.
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
 		return
 	} else if err != nil {
 		// If we don't know how to handle this error then log it and return a generic error to the user.
@@ -83,16 +228,53 @@ func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// reorder handles replacing the order of all of the moodboard items on a board.
+func (h *Handler) reorder(w http.ResponseWriter, r *http.Request, boardID string) {
+	w.Header().Set("Accept", "application/json")
+
+	// Make sure we have the right content type.
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var ids []string
+
+	// Try reading in the request, making sure that we were given an array.
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil || ids == nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	err := h.store.Reorder(r.Context(), boardID, ids)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, ErrOrderMismatch) {
+		// The board has probably changed since the client last saw it.
+		w.WriteHeader(http.StatusConflict)
+	} else if errors.Is(err, ErrUnsupported) {
+		w.WriteHeader(http.StatusNotImplemented)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to reorder items: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 // validateContentType checks the content type of the specified reader against validContentTypes.
 //
-// A new reader is returned which is prefixed with the result of any reads performed by this function.
-func validateContentType(r io.Reader) (io.Reader, bool, error) {
-	buf := make([]byte, 512)
+// A new reader is returned which is prefixed with the result of any reads performed by this function, along with the
+// detected content type. The content type is empty if it isn't valid.
+func validateContentType(r io.Reader) (io.Reader, string, error) {
+	buf := make([]byte, sniff.Size)
 	n, err := r.Read(buf)
 
 	// If we got a non-EOF error, something else has gone wrong.
 	if err != nil && !errors.Is(err, io.EOF) {
-		return nil, false, fmt.Errorf("failed to read header: %w", err)
+		return nil, "", fmt.Errorf("failed to read header: %w", err)
 	}
 
 	// Only keep up to where we managed to read.
@@ -101,20 +283,41 @@ func validateContentType(r io.Reader) (io.Reader, bool, error) {
 	// Create a new reader which prefixes the reader we were given with the bytes we just read from it.
 	r = io.MultiReader(bytes.NewReader(buf), r)
 
-	contentType := http.DetectContentType(buf)
+	contentType := sniff.ContentType(buf)
 
 	// Check if the detected content type is in our valid type list.
 	for _, validContentType := range validContentTypes {
 		if contentType == validContentType {
-			return r, true, nil
+			return r, contentType, nil
 		}
 	}
 
-	return r, false, nil
+	return r, "", nil
+}
+
+// uploadResult represents the outcome of uploading a single file.
+type uploadResult struct {
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// Duplicates contains the IDs of the items on the board which the image is a near-duplicate of.
+	Duplicates []string `json:"duplicates,omitempty"`
 }
 
 // create handles inserting new moodboard items.
-func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+//
+// Any number of files can be uploaded at once, each as a part named "file". The response contains the result of each
+// upload, in the same order as the files were uploaded.
+//
+// Files which are larger than the maximum upload size result in a 413, files which would take the board over its quota
+// result in a 507 and files which are rejected as near-duplicates result in a 409. The other files are still stored.
+// If the request can't be read part of the way through then the files before that point are still stored, and the
+// results are returned with a 400.
+//
+// Near-duplicates which the store doesn't reject are stored as normal, with a warning in the result. Images whose
+// metadata needs removing but which are too badly formed for it to be found are skipped.
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, boardID string) {
 	w.Header().Set("Accept", "multipart/form-data")
 
 	mr, err := r.MultipartReader()
@@ -131,53 +334,180 @@ func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	part, err := mr.NextPart()
+	results := make([]uploadResult, 0)
 
-	// If we got an error or the first part does not have the right name, the request is bad.
-	if err != nil || part.FormName() != "file" {
-		w.WriteHeader(http.StatusBadRequest)
+	// The status is only changed by files which are too large, over quota or duplicates, or if the request is cut short.
+	status := http.StatusOK
 
-		return
-	}
+	for {
+		part, err := mr.NextPart()
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && len(results) == 0 {
+			// If we can't read the request before we get to any files then it's just bad.
+			w.WriteHeader(http.StatusBadRequest)
 
-	// Check the content type of the file being uploaded.
-	partReader, isValid, err := validateContentType(part)
+			return
+		} else if err != nil {
+			// If we can't read the next part then the rest of the request is bad, but the files before it have already
+			// been stored.
+			results = append(results, uploadResult{Error: "malformed request"})
+			status = http.StatusBadRequest
 
-	if err != nil {
-		// This error is unexpected - log it and return a generic error to the user.
-		h.logger.Error(fmt.Sprintf("failed to detect content type: %v", err))
-		w.WriteHeader(http.StatusInternalServerError)
+			break
+		}
 
-		return
+		// Ignore anything which isn't a file.
+		if part.FormName() != "file" {
+			continue
+		}
+
+		result := uploadResult{Name: part.FileName()}
+
+		// Check the content type of the file being uploaded.
+		partReader, contentType, err := validateContentType(part)
+
+		if err != nil {
+			// The request body can't be read any further, but any files before this one have already been stored.
+			result.Error = "failed to read image"
+			results = append(results, result)
+			status = http.StatusBadRequest
+
+			break
+		}
+
+		// If the content type of the file isn't valid then skip it, letting the user know why.
+		if contentType == "" {
+			result.Error = "unsupported content type"
+			results = append(results, result)
+
+			continue
+		}
+
+		limit := h.MaxUploadSize
+
+		if limit <= 0 {
+			limit = math.MaxInt64
+		}
+
+		// Stop reading the file once it goes over the maximum size, rather than passing all of it to the store.
+		lr := limitio.NewReader(partReader, limit)
+
+		var (
+			img          io.Reader = lr
+			id           string
+			duplicateErr *DuplicateError
+		)
+
+		if contentType == "image/svg+xml" {
+			// SVGs can contain scripts, which would run if someone opened the image directly.
+			img, err = svg.Sanitise(lr)
+		} else if h.StripMetadata {
+			// Remove anything which could say where the image came from before it's stored, as images are served
+			// publicly.
+			img, err = metadata.Strip(lr)
+		}
+
+		if err == nil {
+			id, err = h.store.Create(r.Context(), boardID, img)
+		}
+
+		if errors.Is(err, ErrNoSuchBoard) {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		} else if lr.Exceeded {
+			result.Error = "image too large"
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, metadata.ErrMalformed) || errors.Is(err, svg.ErrMalformed) {
+			result.Error = "malformed image"
+		} else if errors.Is(err, metadata.ErrUnsupported) {
+			result.Error = "unsupported content type"
+		} else if errors.Is(err, ErrQuotaExceeded) {
+			result.Error = "quota exceeded"
+
+			// Files which are too large take priority, as they'll never fit.
+			if status == http.StatusOK {
+				status = http.StatusInsufficientStorage
+			}
+		} else if errors.As(err, &duplicateErr) {
+			result.Error = "duplicate image"
+			result.Duplicates = duplicateErr.IDs
+
+			if status == http.StatusOK {
+				status = http.StatusConflict
+			}
+		} else if err != nil {
+			// This error is unexpected - log it and return a generic error to the user.
+			h.logger.Error(fmt.Sprintf("failed to insert item: %v", err))
+			result.Error = "failed to store image"
+		} else {
+			result.ID = id
+			result.Duplicates = h.similar(r, boardID, id)
+		}
+
+		results = append(results, result)
 	}
 
-	// If the content type of the file isn't valid, return an error.
-	if !isValid {
-		w.WriteHeader(http.StatusUnsupportedMediaType)
+	// We need at least one file.
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
 
 		return
 	}
 
-	id, err := h.store.Create(partReader)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// similar returns the IDs of the items which are near-duplicates of a newly uploaded item.
+//
+// This is only used to warn about duplicates, so errors are logged rather than failing the upload.
+func (h *Handler) similar(r *http.Request, boardID, id string) []string {
+	items, err := h.store.Similar(r.Context(), boardID, id)
 
 	if err != nil {
-		// This error is unexpected - log it and return a generic error to the user.
-		h.logger.Error(fmt.Sprintf("failed to insert item: %v", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Error(fmt.Sprintf("failed to find similar items: %v", err))
 
-		return
+		return nil
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	_ = json.NewEncoder(w).Encode(id)
+	ids := make([]string, len(items))
+
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	return ids
 }
 
 // image handles getting images for moodboard items.
-func (h *Handler) image(w http.ResponseWriter, r *http.Request) {
-	// The ID of the image comes after "/image/".
-	img, err := h.store.GetImage(r.URL.Path[7:])
+//
+// Passing a "size" query parameter returns the smallest thumbnail which is at least that many pixels along its long
+// edge, falling back to the original image if there isn't one.
+func (h *Handler) image(w http.ResponseWriter, r *http.Request, boardID, id string) {
+	var (
+		img io.Reader
+		err error
+	)
+
+	if size := r.URL.Query().Get("size"); size != "" {
+		var n int
+
+		if n, err = strconv.Atoi(size); err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
 
-	if errors.Is(err, ErrNoSuchItem) {
+			return
+		}
+
+		img, err = h.store.GetThumbnail(r.Context(), boardID, id, n)
+	} else {
+		img, err = h.store.GetImage(r.Context(), boardID, id)
+	}
+
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
 		w.WriteHeader(http.StatusNotFound)
 
 		return
@@ -189,78 +519,744 @@ func (h *Handler) image(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Close the image if we can.
+	if closer, ok := img.(io.ReadCloser); ok {
+		defer closer.Close()
+	}
+
+	// Work out the content type ourselves, as the response writer doesn't know about every format that we accept.
+	buf := make([]byte, sniff.Size)
+	n, err := io.ReadFull(img, buf)
+
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		h.logger.Error(fmt.Sprintf("failed to read image: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	contentType := sniff.ContentType(buf[:n])
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Sanitised SVGs shouldn't be able to do anything when opened directly, but make sure that they can't run scripts or
+	// load anything from elsewhere even if something slips through.
+	if contentType == "image/svg+xml" {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'; sandbox")
+	}
+
 	// Ask the client to cache the image.
 	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
 
 	// Pipe the image out to the response.
+	_, _ = w.Write(buf[:n])
 	_, _ = io.Copy(w, img)
+}
 
-	// Close the image if we can.
-	if closer, ok := img.(io.ReadCloser); ok {
-		_ = closer.Close()
+// normalizeTag tidies up a tag so that tags which only differ by case or surrounding whitespace are treated the same.
+//
+// The boolean returned by this function indicates whether the tag is valid.
+func normalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+
+	// Tags are used as part of a path, so they can't contain slashes.
+	return tag, len(tag) > 0 && !strings.Contains(tag, "/")
+}
+
+// hasTags checks whether an item has the specified tags.
+//
+// If matchAny is true then the item only needs to have one of the tags, otherwise it needs to have all of them.
+func hasTags(item Item, tags []string, matchAny bool) bool {
+	for _, tag := range tags {
+		found := false
+
+		for _, itemTag := range item.Tags {
+			if itemTag == tag {
+				found = true
+				break
+			}
+		}
+
+		if found && matchAny {
+			return true
+		} else if !found && !matchAny {
+			return false
+		}
 	}
+
+	return !matchAny
 }
 
-// list handles listing moodboard items.
-func (h *Handler) list(w http.ResponseWriter) {
-	es, err := h.store.All()
+// Orientations which items can be filtered by.
+const (
+	orientationPortrait  = "portrait"
+	orientationLandscape = "landscape"
+	orientationSquare    = "square"
+)
 
-	// If we can't get a list of items then log the error and return a generic error to the client.
-	if err != nil {
-		h.logger.Error(fmt.Sprintf("failed to list items: %v", err))
-		w.WriteHeader(http.StatusInternalServerError)
+// shape describes the shape of image that an item needs to have to be listed.
+type shape struct {
+	// orientation is the orientation that the image needs to have, or empty for any orientation.
+	orientation string
 
-		return
+	// minAspectRatio and maxAspectRatio are the bounds of the aspect ratio of the image, if they're not zero.
+	minAspectRatio float64
+	maxAspectRatio float64
+}
+
+// matches checks whether the image of an item has the right shape.
+//
+// Items with unknown dimensions never match.
+func (s shape) matches(item Item) bool {
+	if item.Width <= 0 || item.Height <= 0 {
+		return false
 	}
 
-	// If we don't have any items then use a zero-length slice.
-	//
-	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
-	if es == nil {
-		es = make([]string, 0)
+	switch s.orientation {
+	case orientationPortrait:
+		if item.Width >= item.Height {
+			return false
+		}
+	case orientationLandscape:
+		if item.Width <= item.Height {
+			return false
+		}
+	case orientationSquare:
+		if item.Width != item.Height {
+			return false
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	_ = json.NewEncoder(w).Encode(es)
+	if s.minAspectRatio > 0 && item.AspectRatio < s.minAspectRatio {
+		return false
+	}
+
+	return s.maxAspectRatio == 0 || item.AspectRatio <= s.maxAspectRatio
 }
 
-func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
-	// The ID of the item being deleted comes after "/".
-	id := r.URL.Path[1:]
+// tag handles attaching tags to and removing tags from moodboard items.
+func (h *Handler) tag(w http.ResponseWriter, r *http.Request, boardID, path string) {
+	// The item ID is followed by the tag.
+	i := strings.IndexByte(path, '/')
+
+	if i == -1 {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	id := path[:i]
+	tag, ok := normalizeTag(path[i+1:])
+
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	var item Item
+	var err error
+
+	switch r.Method {
+	case http.MethodPut:
+		item, err = h.store.AddTag(r.Context(), boardID, id, tag)
+	case http.MethodDelete:
+		item, err = h.store.RemoveTag(r.Context(), boardID, id, tag)
+	default:
+		w.Header().Add("Allow", "PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
 
-	err := h.store.Delete(id)
+		return
+	}
 
-	if errors.Is(err, ErrNoSuchItem) {
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
 		w.WriteHeader(http.StatusNotFound)
+
+		return
 	} else if err != nil {
 		// If we don't know how to handle this error then log it and return a generic error to the user.
-		h.logger.Error(fmt.Sprintf("failed to delete item: %v", err))
+		h.logger.Error(fmt.Sprintf("failed to tag item: %v", err))
 		w.WriteHeader(http.StatusInternalServerError)
+
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(item)
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		if strings.HasPrefix(r.URL.Path, "/move/") {
-			h.move(w, r)
-		} else {
-			h.create(w, r)
-		}
-	case http.MethodGet:
-		if strings.HasPrefix(r.URL.Path, "/image/") {
-			h.image(w, r)
-		} else {
-			h.list(w)
-		}
-	case http.MethodDelete:
-		h.delete(w, r)
+// list handles listing moodboard items.
+//
+// Items can be filtered by passing one or more "tag" query parameters. By default items need to have all of the
+// tags, unless the "match" query parameter is set to "any".
+//
+// Items can also be filtered by the shape of their image, using the "orientation" query parameter or the
+// "minAspectRatio" and "maxAspectRatio" query parameters.
+//
+// Passing a "limit" or "cursor" query parameter returns a page of items along with the cursor for the next page,
+// instead of returning all of the items.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, boardID string) {
+	query := r.URL.Query()
+	tags := make([]string, 0, len(query["tag"]))
+
+	for _, tag := range query["tag"] {
+		tag, ok := normalizeTag(tag)
+
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		tags = append(tags, tag)
+	}
+
+	var matchAny bool
+
+	switch query.Get("match") {
+	case "", "all":
+		matchAny = false
+	case "any":
+		matchAny = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	paged := query.Get("limit") != "" || query.Get("cursor") != ""
+	opts := PageOptions{Cursor: query.Get("cursor")}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+
+		// A limit of zero would never make any progress.
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	var imageShape shape
+
+	switch o := query.Get("orientation"); o {
+	case "", orientationPortrait, orientationLandscape, orientationSquare:
+		imageShape.orientation = o
 	default:
-		w.Header().Add("Allow", "POST, GET, DELETE")
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value *float64
+	}{
+		{name: "minAspectRatio", value: &imageShape.minAspectRatio},
+		{name: "maxAspectRatio", value: &imageShape.maxAspectRatio},
+	} {
+		if v := query.Get(bound.name); v != "" {
+			var err error
+
+			// Aspect ratios are always positive.
+			if *bound.value, err = strconv.ParseFloat(v, 64); err != nil || !(*bound.value > 0) {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+		}
+	}
+
+	filterShape := imageShape != shape{}
+
+	if len(tags) > 0 || filterShape {
+		opts.Filter = func(item Item) bool {
+			return (len(tags) == 0 || hasTags(item, tags, matchAny)) && (!filterShape || imageShape.matches(item))
+		}
+	}
+
+	var (
+		page Page
+		err  error
+	)
+
+	if paged {
+		page, err = h.store.Page(r.Context(), boardID, opts)
+	} else {
+		page.Items, err = h.store.All(r.Context(), boardID)
+	}
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if errors.Is(err, ErrInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	} else if err != nil {
+		// If we can't get a list of items then log the error and return a generic error to the client.
+		h.logger.Error(fmt.Sprintf("failed to list items: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	es := page.Items
+
+	// Filter the items in place, which keeps them in the order that they appear on the board. Pages have already been
+	// filtered by the store.
+	if !paged && opts.Filter != nil {
+		filtered := es[:0]
+
+		for _, e := range es {
+			if opts.Filter(e) {
+				filtered = append(filtered, e)
+			}
+		}
+
+		es = filtered
+	}
+
+	// If we don't have any items then use a zero-length slice.
+	//
+	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
+	if es == nil {
+		es = make([]Item, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if paged {
+		page.Items = es
+		_ = json.NewEncoder(w).Encode(page)
+	} else {
+		_ = json.NewEncoder(w).Encode(es)
+	}
+}
+
+// validSource checks that the specified source is either empty or an absolute HTTP(S) URL.
+func validSource(source string) bool {
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// update handles changing the metadata of moodboard items.
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, boardID, id string) {
+	w.Header().Set("Accept", "application/json")
+
+	// Make sure we have the right content type.
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var update ItemUpdate
+
+	// Try reading in the request.
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	// Sources need to be something the client can link to.
+	if update.Source != nil && !validSource(*update.Source) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	item, err := h.store.Update(r.Context(), boardID, id, update)
+
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to update item: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+// delete handles moving moodboard items to the trash.
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, boardID, id string) {
+	err := h.store.Delete(r.Context(), boardID, id)
+
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, ErrUnsupported) {
+		w.WriteHeader(http.StatusNotImplemented)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to delete item: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// trash handles listing moodboard items in the trash.
+func (h *Handler) trash(w http.ResponseWriter, r *http.Request, boardID string) {
+	es, err := h.store.Trash(r.Context(), boardID)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		// If we can't get a list of items then log the error and return a generic error to the client.
+		h.logger.Error(fmt.Sprintf("failed to list trash: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	// If we don't have any items then use a zero-length slice.
+	//
+	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
+	if es == nil {
+		es = make([]TrashedItem, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(es)
+}
+
+// usage handles reporting how much space the images on a board take up.
+func (h *Handler) usage(w http.ResponseWriter, r *http.Request, boardID string) {
+	u, err := h.store.Usage(r.Context(), boardID)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		// This error is unexpected - log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to get usage: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(u)
+}
+
+// palette handles reporting the dominant colours across the moodboard items on a board.
+func (h *Handler) palette(w http.ResponseWriter, r *http.Request, boardID string) {
+	colours, err := h.store.Palette(r.Context(), boardID)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		// This error is unexpected - log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to get palette: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	// If none of the items have a palette then use a zero-length slice.
+	//
+	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
+	if colours == nil {
+		colours = make([]Colour, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(colours)
+}
+
+// duplicates handles listing groups of moodboard items on a board which are near-duplicates of each other.
+func (h *Handler) duplicates(w http.ResponseWriter, r *http.Request, boardID string) {
+	groups, err := h.store.Duplicates(r.Context(), boardID)
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		// This error is unexpected - log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to find duplicates: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	// If there aren't any duplicates then use a zero-length slice.
+	//
+	// This is needed to ensure that the JSON encoder does not return null instead of an empty array.
+	if groups == nil {
+		groups = make([][]Item, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(groups)
+}
+
+// restore handles moving moodboard items out of the trash.
+func (h *Handler) restore(w http.ResponseWriter, r *http.Request, boardID, id string) {
+	err := h.store.Restore(r.Context(), boardID, id)
+
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to restore item: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// purge handles permanently removing moodboard items from the trash.
+func (h *Handler) purge(w http.ResponseWriter, r *http.Request, boardID, id string) {
+	err := h.store.Purge(r.Context(), boardID, id)
+
+	if errors.Is(err, ErrNoSuchBoard) || errors.Is(err, ErrNoSuchItem) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to purge item: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// undo handles undoing and redoing operations on boards.
+func (h *Handler) undo(w http.ResponseWriter, r *http.Request, boardID string, redo bool) {
+	var err error
+
+	if redo {
+		err = h.store.Redo(r.Context(), boardID)
+	} else {
+		err = h.store.Undo(r.Context(), boardID)
+	}
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, ErrNothingToUndo) || errors.Is(err, ErrNothingToRedo) {
+		w.WriteHeader(http.StatusConflict)
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to undo operation: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// batch handles deleting and moving several moodboard items at once.
+//
+// The response contains the problem with each item in the batch (if any), keyed by ID. Batches are all-or-nothing, so
+// if there are any problems then nothing is changed.
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request, boardID, action string) {
+	w.Header().Set("Accept", "application/json")
+
+	// Make sure we have the right content type.
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var body struct {
+		IDs    []string `json:"ids"`
+		Before string   `json:"before"`
+		After  string   `json:"after"`
+	}
+
+	// Try reading in the request, making sure that we were given some items.
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.IDs) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	var err error
+
+	switch {
+	case action == "delete" && len(body.Before) == 0 && len(body.After) == 0:
+		err = h.store.DeleteMany(r.Context(), boardID, body.IDs)
+	case action == "move" && len(body.Before) > 0 && len(body.After) == 0:
+		err = h.store.MoveManyBefore(r.Context(), boardID, body.IDs, body.Before)
+	case action == "move" && len(body.After) > 0 && len(body.Before) == 0:
+		err = h.store.MoveManyAfter(r.Context(), boardID, body.IDs, body.After)
+	case action == "delete" || action == "move":
+		// Moves need exactly one of "before" or "after", and deletes need neither.
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	problems := make(map[string]string)
+
+	var batchErr *BatchError
+
+	if errors.Is(err, ErrNoSuchBoard) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	} else if errors.Is(err, ErrUnsupported) {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	} else if errors.As(err, &batchErr) {
+		for id, err := range batchErr.Errors {
+			problems[id] = err.Error()
+		}
+	} else if err != nil {
+		// If we don't know how to handle this error then log it and return a generic error to the user.
+		h.logger.Error(fmt.Sprintf("failed to %s items: %v", action, err))
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Errors map[string]string `json:"errors"`
+	}{problems})
+}
+
+// serveBoards handles requests for the list of boards.
+func (h *Handler) serveBoards(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.createBoard(w, r)
+	case http.MethodGet:
+		h.boards(w, r)
+	default:
+		w.Header().Add("Allow", "POST, GET")
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// serveBoard handles requests for a single board.
+//
+// The path should be relative to the board (i.e. the board ID should already have been removed).
+func (h *Handler) serveBoard(w http.ResponseWriter, r *http.Request, boardID, path string) {
+	// Requests for the board itself don't have an item path.
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r, boardID)
+		case http.MethodPatch:
+			h.renameBoard(w, r, boardID)
+		case http.MethodDelete:
+			h.deleteBoard(w, r, boardID)
+		default:
+			w.Header().Add("Allow", "GET, PATCH, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	// Tags have their own set of methods.
+	if strings.HasPrefix(path, "/tags/") {
+		// The ID of the item being tagged comes after "/tags/".
+		h.tag(w, r, boardID, path[6:])
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if strings.HasPrefix(path, "/move/") {
+			// The ID of the item being moved comes after "/move/".
+			h.move(w, r, boardID, path[6:])
+		} else if strings.HasPrefix(path, "/restore/") {
+			// The ID of the item being restored comes after "/restore/".
+			h.restore(w, r, boardID, path[9:])
+		} else if strings.HasPrefix(path, "/batch/") {
+			// The action being performed comes after "/batch/".
+			h.batch(w, r, boardID, path[7:])
+		} else if path == "/undo" || path == "/redo" {
+			h.undo(w, r, boardID, path == "/redo")
+		} else {
+			h.create(w, r, boardID)
+		}
+	case http.MethodGet:
+		if strings.HasPrefix(path, "/image/") {
+			// The ID of the image comes after "/image/".
+			h.image(w, r, boardID, path[7:])
+		} else if path == "/trash" {
+			h.trash(w, r, boardID)
+		} else if path == "/usage" {
+			h.usage(w, r, boardID)
+		} else if path == "/palette" {
+			h.palette(w, r, boardID)
+		} else if path == "/duplicates" {
+			h.duplicates(w, r, boardID)
+		} else {
+			h.list(w, r, boardID)
+		}
+	case http.MethodPut:
+		if path == "/order" {
+			h.reorder(w, r, boardID)
+		} else {
+			w.Header().Add("Allow", "POST, GET, PATCH, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case http.MethodPatch:
+		// The ID of the item being updated comes after "/".
+		h.update(w, r, boardID, path[1:])
+	case http.MethodDelete:
+		if strings.HasPrefix(path, "/trash/") {
+			// The ID of the item being purged comes after "/trash/".
+			h.purge(w, r, boardID, path[7:])
+		} else {
+			// The ID of the item being deleted comes after "/".
+			h.delete(w, r, boardID, path[1:])
+		}
+	default:
+		w.Header().Add("Allow", "POST, GET, PATCH, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Everything is served from underneath "/boards".
+	if r.URL.Path == "/boards" || r.URL.Path == "/boards/" {
+		h.serveBoards(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/boards/") {
+		// The board ID comes after "/boards/", and is followed by the path within the board.
+		boardID, path := r.URL.Path[8:], ""
+
+		if i := strings.IndexByte(boardID, '/'); i != -1 {
+			boardID, path = boardID[:i], boardID[i:]
+		}
+
+		h.serveBoard(w, r, boardID, path)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
 // NewHandler creates a new moodboard HTTP handler.
 func NewHandler(l logger, s Store) *Handler {
 	return &Handler{logger: l, store: s}
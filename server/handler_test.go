@@ -0,0 +1,366 @@
+package moodboard_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/memory"
+)
+
+// testLogger is a logger which fails the test if anything is logged.
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Error(msg string) {
+	l.t.Errorf("unexpected error: %s", msg)
+}
+
+// uploadResult represents the outcome of uploading a single file.
+type uploadResult struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// file represents a file being uploaded.
+type file struct {
+	name string
+	data []byte
+}
+
+// newHandler creates a handler backed by a new in-memory store, along with the ID of a board in the store.
+func newHandler(t *testing.T) (*moodboard.Handler, *memory.Store, string) {
+	t.Helper()
+
+	s := memory.NewStore()
+	board, err := s.CreateBoard(context.Background(), "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	return moodboard.NewHandler(testLogger{t: t}, s), s, board.ID
+}
+
+// pngImage returns a PNG image filled with the specified colour.
+func pngImage(t *testing.T, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// multipartBody returns a multipart request body containing the specified files, along with its content type.
+func multipartBody(t *testing.T, files ...file) ([]byte, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+
+	for _, f := range files {
+		w, err := mw.CreateFormFile("file", f.name)
+
+		if err != nil {
+			t.Fatalf("failed to create part: %v", err)
+		}
+
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatalf("failed to write part: %v", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close body: %v", err)
+	}
+
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+// upload sends a multipart request body to the handler, returning the status and the result of each file.
+func upload(t *testing.T, h http.Handler, boardID string, body []byte, contentType string) (int, []uploadResult) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/boards/"+boardID+"/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var results []uploadResult
+
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode results: %v", err)
+	}
+
+	return w.Code, results
+}
+
+// get sends a GET request to the handler, returning the response.
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status of %q to be %d but got %d", target, http.StatusOK, w.Code)
+	}
+
+	return w
+}
+
+func TestHandlerCreate(t *testing.T) {
+	h, s, boardID := newHandler(t)
+
+	body, contentType := multipartBody(
+		t,
+		file{name: "red.png", data: pngImage(t, color.RGBA{R: 0xff, A: 0xff})},
+		file{name: "notes.txt", data: []byte("not an image")},
+		file{name: "green.png", data: pngImage(t, color.RGBA{G: 0xff, A: 0xff})},
+		file{name: "blue.png", data: pngImage(t, color.RGBA{B: 0xff, A: 0xff})},
+	)
+
+	status, results := upload(t, h, boardID, body, contentType)
+
+	if status != http.StatusOK {
+		t.Fatalf("expected status to be %d but got %d", http.StatusOK, status)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("expected to get 4 results but got %d", len(results))
+	}
+
+	if results[1].Name != "notes.txt" || results[1].ID != "" || results[1].Error != "unsupported content type" {
+		t.Fatalf("expected notes.txt to be rejected but got %+v", results[1])
+	}
+
+	// The accepted images should end up on the board in the order that they were uploaded.
+	items, err := s.All(context.Background(), boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("expected to get 3 items but got %d", len(items))
+	}
+
+	for i, j := range []int{0, 2, 3} {
+		if results[j].ID == "" || items[i].ID != results[j].ID {
+			t.Fatalf("expected item %d to be %+v but got %q", i, results[j], items[i].ID)
+		}
+	}
+}
+
+func TestHandlerCreateLimits(t *testing.T) {
+	small := pngImage(t, color.RGBA{R: 0xff, A: 0xff})
+	large := append(pngImage(t, color.RGBA{G: 0xff, A: 0xff}), make([]byte, 1024)...)
+
+	tests := []struct {
+		name   string
+		setup  func(h *moodboard.Handler, s *memory.Store)
+		status int
+		error  string
+	}{
+		{
+			name: "too large",
+			setup: func(h *moodboard.Handler, s *memory.Store) {
+				h.MaxUploadSize = int64(len(small))
+			},
+			status: http.StatusRequestEntityTooLarge,
+			error:  "image too large",
+		},
+		{
+			name: "quota exceeded",
+			setup: func(h *moodboard.Handler, s *memory.Store) {
+				s.SetQuota(moodboard.Quota{Board: int64(len(small) + len(large) - 1)})
+			},
+			status: http.StatusInsufficientStorage,
+			error:  "quota exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		h, s, boardID := newHandler(t)
+		test.setup(h, s)
+
+		body, contentType := multipartBody(t, file{name: "small.png", data: small}, file{name: "large.png", data: large})
+
+		status, results := upload(t, h, boardID, body, contentType)
+
+		if status != test.status {
+			t.Fatalf("expected %s status to be %d but got %d", test.name, test.status, status)
+		}
+
+		// The files which could be stored should still have been stored.
+		if len(results) != 2 || results[0].ID == "" || results[1].ID != "" || results[1].Error != test.error {
+			t.Fatalf("expected %s results to be [{ID: ...}, {Error: %q}] but got %+v", test.name, test.error, results)
+		}
+	}
+}
+
+func TestHandlerCreatePartial(t *testing.T) {
+	first := pngImage(t, color.RGBA{R: 0xff, A: 0xff})
+	second := pngImage(t, color.RGBA{G: 0xff, A: 0xff})
+
+	body, contentType := multipartBody(t, file{name: "first.png", data: first}, file{name: "second.png", data: second})
+
+	// The start of the second file's headers and the start of its contents.
+	headers := bytes.LastIndex(body, []byte("Content-Disposition"))
+	contents := bytes.LastIndex(body, second[:8])
+
+	tests := []struct {
+		name  string
+		end   int
+		error string
+	}{
+		{name: "part headers", end: headers + 10, error: "malformed request"},
+		{name: "part contents", end: contents, error: "failed to read image"},
+	}
+
+	for _, test := range tests {
+		h, s, boardID := newHandler(t)
+
+		// Anything which was stored before the request was cut short should still be reported.
+		status, results := upload(t, h, boardID, body[:test.end], contentType)
+
+		if status != http.StatusBadRequest {
+			t.Fatalf("expected %s status to be %d but got %d", test.name, http.StatusBadRequest, status)
+		}
+
+		if len(results) != 2 || results[0].ID == "" || results[1].ID != "" || results[1].Error != test.error {
+			t.Fatalf("expected %s results to be [{ID: ...}, {Error: %q}] but got %+v", test.name, test.error, results)
+		}
+
+		items, err := s.All(context.Background(), boardID)
+
+		if err != nil {
+			t.Fatalf("failed to get store contents: %v", err)
+		}
+
+		if len(items) != 1 || items[0].ID != results[0].ID {
+			t.Fatalf("expected %s items to be [%q] but got %v", test.name, results[0].ID, items)
+		}
+	}
+}
+
+func TestHandlerCreateStripMetadata(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// Add an EXIF segment with an orientation of 1 (the right way up) after the start of image marker.
+	exif := append([]byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01"),
+		0, 1, 0, 0, 0, 0, 0, 0)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+
+	img := append(append(append([]byte{}, buf.Bytes()[:2]...), append(segment, exif...)...), buf.Bytes()[2:]...)
+
+	for _, strip := range []bool{false, true} {
+		h, _, boardID := newHandler(t)
+		h.StripMetadata = strip
+
+		body, contentType := multipartBody(t, file{name: "photo.jpg", data: img})
+
+		status, results := upload(t, h, boardID, body, contentType)
+
+		if status != http.StatusOK || results[0].ID == "" {
+			t.Fatalf("expected upload to succeed but got %d %+v", status, results)
+		}
+
+		stored := get(t, h, "/boards/"+boardID+"/image/"+results[0].ID).Body.Bytes()
+
+		if contains := bytes.Contains(stored, []byte("Exif")); contains == strip {
+			t.Fatalf("expected stored image to contain EXIF metadata to be %t with stripping set to %t", !strip, strip)
+		}
+	}
+
+	// AVIFs can't have their metadata removed, so they can't be uploaded when it's being stripped.
+	h, _, boardID := newHandler(t)
+	h.StripMetadata = true
+
+	avif := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf")
+	body, contentType := multipartBody(t, file{name: "image.avif", data: avif})
+
+	status, results := upload(t, h, boardID, body, contentType)
+
+	if status != http.StatusOK || len(results) != 1 || results[0].Error != "unsupported content type" {
+		t.Fatalf("expected AVIF to be rejected but got %d %+v", status, results)
+	}
+}
+
+func TestHandlerImageHeaders(t *testing.T) {
+	h, _, boardID := newHandler(t)
+
+	body, contentType := multipartBody(
+		t,
+		file{name: "image.svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)},
+		file{name: "image.png", data: pngImage(t, color.RGBA{R: 0xff, A: 0xff})},
+	)
+
+	status, results := upload(t, h, boardID, body, contentType)
+
+	if status != http.StatusOK || len(results) != 2 {
+		t.Fatalf("expected upload to succeed but got %d %+v", status, results)
+	}
+
+	svg := get(t, h, "/boards/"+boardID+"/image/"+results[0].ID)
+
+	if contentType := svg.Header().Get("Content-Type"); contentType != "image/svg+xml" {
+		t.Fatalf("expected content type to be %q but got %q", "image/svg+xml", contentType)
+	}
+
+	if csp := svg.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") ||
+		!strings.Contains(csp, "sandbox") {
+		t.Fatalf("expected content security policy to block everything but got %q", csp)
+	}
+
+	if nosniff := svg.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Fatalf("expected content type options to be %q but got %q", "nosniff", nosniff)
+	}
+
+	if bytes.Contains(svg.Body.Bytes(), []byte("script")) {
+		t.Fatalf("expected scripts to have been removed but got %q", svg.Body.Bytes())
+	}
+
+	png := get(t, h, "/boards/"+boardID+"/image/"+results[1].ID)
+
+	if contentType := png.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Fatalf("expected content type to be %q but got %q", "image/png", contentType)
+	}
+
+	// Raster images can't run scripts, so they don't need a policy.
+	if csp := png.Header().Get("Content-Security-Policy"); csp != "" {
+		t.Fatalf("expected no content security policy but got %q", csp)
+	}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// Board represents a stored board record.
+type Board struct {
+	moodboard.Board
+
+	// History contains the operations which can be undone, oldest first.
+	History []Operation `json:"history,omitempty"`
+
+	// Undone contains the operations which can be redone, most recently undone last.
+	Undone []Operation `json:"undone,omitempty"`
+
+	// Usage is the number of bytes used by the images and thumbnails on the board, or nil for boards which were stored
+	// before it was kept track of.
+	Usage *int64 `json:"usage,omitempty"`
+}
+
+// Item represents a stored item record.
+type Item struct {
+	moodboard.Item
+	Board string `json:"board"`
+
+	// Key determines the order of the items on a board. See keyBetween for details.
+	Key string `json:"key,omitempty"`
+
+	// Position is the index of the item on its board, for records which were stored before keys were introduced.
+	Position int `json:"position,omitempty"`
+
+	// DeletedAt is set once the item has been moved to the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Thumbnails contains the sizes of the thumbnails which have been stored for the item, in ascending order.
+	Thumbnails []int `json:"thumbnails,omitempty"`
+
+	// ThumbnailSize is the number of bytes used by the thumbnails of the item.
+	ThumbnailSize int64 `json:"thumbnailSize,omitempty"`
+
+	// Unanalysable is set once the image has been found to be in a format which can't be decoded, to be damaged or to
+	// be too large to decode, so that backfilling doesn't keep trying to analyse it.
+	Unanalysable bool `json:"unanalysable,omitempty"`
+}
+
+// Tx represents a transaction against a backend.
+//
+// Records returned from a transaction are copies - changes to them are only stored once they are passed back to
+// PutBoard or PutItem.
+type Tx interface {
+	// Boards returns all board records, in no particular order.
+	Boards() ([]Board, error)
+
+	// Board returns the board record with the specified ID.
+	//
+	// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+	Board(id string) (Board, error)
+
+	// PutBoard creates or replaces a board record.
+	PutBoard(Board) error
+
+	// DeleteBoard removes a board record.
+	//
+	// Note that this does not remove the items on the board.
+	DeleteBoard(id string) error
+
+	// Items returns all item records on the specified board, in no particular order.
+	Items(boardID string) ([]Item, error)
+
+	// Item returns the item record with the specified ID.
+	//
+	// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
+	Item(id string) (Item, error)
+
+	// PutItem creates or replaces an item record.
+	PutItem(Item) error
+
+	// DeleteItem removes an item record.
+	//
+	// Note that this does not remove the image for the item.
+	DeleteItem(id string) error
+
+	// CreateImage stores the image for the item with the specified ID.
+	CreateImage(id string, img io.Reader) error
+
+	// Image returns the image for the item with the specified ID.
+	//
+	// The reader returned by this method must remain usable once the transaction has finished, and may be an
+	// io.ReadCloser.
+	Image(id string) (io.Reader, error)
+
+	// DeleteImage removes the image for the item with the specified ID.
+	DeleteImage(id string) error
+}
+
+// Backend represents somewhere that records and images can be stored.
+type Backend interface {
+	// View runs fn within a read-only transaction.
+	//
+	// The context applies to the whole transaction - backends should return the context's error if it is done before
+	// the transaction starts, and should pass it through to any requests they make during the transaction.
+	View(ctx context.Context, fn func(Tx) error) error
+
+	// Update runs fn within a read-write transaction.
+	//
+	// If fn returns an error then none of the changes made within the transaction are kept. This includes the
+	// context being done before the transaction is committed.
+	Update(ctx context.Context, fn func(Tx) error) error
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/metadata"
+)
+
+// maxHeaderSize is the number of bytes at the start of an image which are kept for reading its dimensions.
+//
+// Most formats put their dimensions right at the start, but JPEGs can have large metadata segments before them.
+const maxHeaderSize = 1 << 20
+
+// headerWriter is an io.Writer which keeps the first maxHeaderSize bytes written to it, discarding the rest.
+type headerWriter struct {
+	buf []byte
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if n := maxHeaderSize - len(w.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+
+		w.buf = append(w.buf, p[:n]...)
+	}
+
+	return len(p), nil
+}
+
+// setDimensions reads the dimensions of an image from its header and stores them in an item.
+//
+// The dimensions are the ones the image is displayed at, once it's been turned the right way round.
+//
+// The item is left unchanged if the dimensions can't be read. The boolean returned by this function indicates whether
+// the dimensions were read.
+func setDimensions(item *Item, header []byte) bool {
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return false
+	}
+
+	// Orientations 5 to 8 are rotated by a quarter turn, which swaps the width and height that the image is displayed
+	// at.
+	if metadata.Orientation(header) >= 5 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+
+	item.Width = config.Width
+	item.Height = config.Height
+	item.AspectRatio = float64(config.Width) / float64(config.Height)
+
+	return true
+}
+
+// readHeader reads the header of the image for an item.
+func (s *Store) readHeader(ctx context.Context, boardID, id string) ([]byte, error) {
+	img, err := s.GetImage(ctx, boardID, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(img, maxHeaderSize))
+
+	if closer, ok := img.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	return buf, nil
+}
+
+// backfill records the dimensions, palette and hash of an item if they're missing, along with its thumbnails.
+//
+// The boolean returned by this function indicates whether anything was recorded.
+func (s *Store) backfill(ctx context.Context, record Item) (bool, error) {
+	updated := false
+
+	if record.Width == 0 {
+		header, err := s.readHeader(ctx, record.Board, record.ID)
+
+		if err != nil {
+			return false, err
+		}
+
+		err = s.backend.Update(ctx, func(tx Tx) error {
+			item, err := boardRecord(tx, record.Board, record.ID)
+
+			if err != nil {
+				return err
+			}
+
+			if item.Width != 0 || !setDimensions(&item, header) {
+				return nil
+			}
+
+			if err := tx.PutItem(item); err != nil {
+				return fmt.Errorf("failed to store item: %w", err)
+			}
+
+			updated = true
+
+			return nil
+		})
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Images which are completely transparent don't have a palette, so the hash is what says whether an item has been
+	// analysed.
+	if record.Hash == "" {
+		analysed, err := s.analyse(ctx, record.Board, record.ID)
+
+		if err != nil {
+			return false, err
+		}
+
+		updated = updated || analysed
+	}
+
+	return updated, nil
+}
+
+// Backfill records the dimensions, palettes, hashes and thumbnails of items which were stored before they were
+// recorded, returning the number of items which were updated.
+//
+// Items are updated one at a time, so other changes to the store aren't held up whilst this is running. Items whose
+// images can't be decoded are skipped, and are marked so that they're skipped straight away next time.
+func (s *Store) Backfill(ctx context.Context) (int, error) {
+	var missing []Item
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		boards, err := tx.Boards()
+
+		if err != nil {
+			return fmt.Errorf("failed to read boards: %w", err)
+		}
+
+		for _, board := range boards {
+			items, err := tx.Items(board.ID)
+
+			if err != nil {
+				return fmt.Errorf("failed to read items: %w", err)
+			}
+
+			for _, item := range items {
+				// Images which can't be decoded won't have anything more to record next time either.
+				if !item.Unanalysable && (item.Width == 0 || item.Hash == "") {
+					missing = append(missing, item)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for _, item := range missing {
+		updated, err := s.backfill(ctx, item)
+
+		// The item or its image may have gone away since we looked.
+		if errors.Is(err, moodboard.ErrNoSuchBoard) || errors.Is(err, moodboard.ErrNoSuchItem) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		if updated {
+			n++
+		}
+	}
+
+	return n, nil
+}
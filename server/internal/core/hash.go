@@ -0,0 +1,215 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// decoder decodes an image in the background whilst it's being written to it, so that an upload can be analysed as
+// it's being stored rather than having to be read back afterwards.
+type decoder struct {
+	w    *io.PipeWriter
+	done chan struct{}
+	img  *image.RGBA
+	err  error
+}
+
+func (d *decoder) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+// Close stops writing to the decoder, returning the decoded image.
+//
+// The image is nil if it couldn't be decoded (including if it was cut short) or if it was too big to decode safely,
+// and an error is returned if the context was done before the image could be decoded. Close must always be called, even
+// if the image isn't needed.
+func (d *decoder) Close() (*image.RGBA, error) {
+	_ = d.w.Close()
+	<-d.done
+
+	return d.img, d.err
+}
+
+// newDecoder creates a new decoder, which is ready to be written to.
+//
+// The context is only used whilst waiting for other images to finish decoding.
+func (s *Store) newDecoder(ctx context.Context) *decoder {
+	r, w := io.Pipe()
+	d := &decoder{w: w, done: make(chan struct{})}
+
+	go func() {
+		defer close(d.done)
+
+		d.img, d.err = s.decode(ctx, r)
+
+		// Decoders don't always read to the end of the image, and writes would block forever if nothing read them.
+		_, _ = io.Copy(ioutil.Discard, r)
+	}()
+
+	return d
+}
+
+// hash returns the perceptual hash of an image.
+//
+// The image is shrunk to 9x8 pixels and each bit of the hash records whether a pixel is brighter than the pixel to its
+// right. Resizing, recompressing or slightly adjusting an image doesn't change which pixels are brighter, so images
+// which look alike have hashes which only differ by a few bits.
+func hash(img *image.RGBA) string {
+	small := resize(img, 9, 8)
+
+	var h uint64
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			i := small.PixOffset(x, y)
+
+			// Compare the brightness of each pixel using the weights from ITU-R BT.601.
+			left := 299*int(small.Pix[i]) + 587*int(small.Pix[i+1]) + 114*int(small.Pix[i+2])
+			right := 299*int(small.Pix[i+4]) + 587*int(small.Pix[i+5]) + 114*int(small.Pix[i+6])
+
+			h <<= 1
+
+			if left > right {
+				h |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", h)
+}
+
+// hashDistance returns the number of bits that the hashes of two items differ by.
+//
+// The boolean returned by this function is false if either of the items doesn't have a valid hash.
+func hashDistance(a, b Item) (int, bool) {
+	ha, err := strconv.ParseUint(a.Hash, 16, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	hb, err := strconv.ParseUint(b.Hash, 16, 64)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return bits.OnesCount64(ha ^ hb), true
+}
+
+// similar returns the items which are near-duplicates of an item, most similar first.
+//
+// The item itself and items in the trash are skipped. Items which are equally similar are kept in the order they were
+// in.
+func similar(records []Item, item Item, distance int) []Item {
+	var (
+		matches   []Item
+		distances []int
+	)
+
+	for _, record := range records {
+		if record.ID == item.ID || record.DeletedAt != nil {
+			continue
+		}
+
+		if d, ok := hashDistance(item, record); ok && d <= distance {
+			matches = append(matches, record)
+			distances = append(distances, d)
+		}
+	}
+
+	sort.Stable(byDistance{items: matches, distances: distances})
+
+	return matches
+}
+
+// byDistance sorts items by their distance from another item.
+type byDistance struct {
+	items     []Item
+	distances []int
+}
+
+func (s byDistance) Len() int {
+	return len(s.items)
+}
+
+func (s byDistance) Less(i, j int) bool {
+	return s.distances[i] < s.distances[j]
+}
+
+func (s byDistance) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.distances[i], s.distances[j] = s.distances[j], s.distances[i]
+}
+
+// duplicates groups sorted items whose images are near-duplicates of each other.
+//
+// Images which are near-duplicates of the same image end up in the same group, even if they aren't near-duplicates of
+// each other. Groups are in the order of their first item, and the items in each group are kept in order.
+func duplicates(items []Item, distance int) [][]moodboard.Item {
+	// Each item starts in its own group, and groups are joined by pointing one at the other.
+	parents := make([]int, len(items))
+
+	for i := range parents {
+		parents[i] = i
+	}
+
+	root := func(i int) int {
+		for parents[i] != i {
+			parents[i] = parents[parents[i]]
+			i = parents[i]
+		}
+
+		return i
+	}
+
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if d, ok := hashDistance(items[i], items[j]); ok && d <= distance {
+				// Point the later group at the earlier one, so that each group is identified by its first item.
+				ri, rj := root(i), root(j)
+
+				if ri < rj {
+					parents[rj] = ri
+				} else if rj < ri {
+					parents[ri] = rj
+				}
+			}
+		}
+	}
+
+	var groups [][]moodboard.Item
+
+	// index contains the index of the group for each root.
+	index := make(map[int]int)
+
+	for i, item := range items {
+		r := root(i)
+
+		if r == i {
+			index[i] = len(groups)
+			groups = append(groups, nil)
+		}
+
+		groups[index[r]] = append(groups[index[r]], item.Item)
+	}
+
+	// Items which are on their own aren't duplicates of anything.
+	var dupes [][]moodboard.Item
+
+	for _, group := range groups {
+		if len(group) > 1 {
+			dupes = append(dupes, group)
+		}
+	}
+
+	return dupes
+}
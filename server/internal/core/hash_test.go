@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// noise returns an encoded image which is filled with a pattern based on the seed.
+func noise(t *testing.T, seed int) []byte {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 90, 80))
+
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x/10*seed + y/10*(seed+3)) * 37)})
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestStoreRejectDuplicates(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(&testBackend{})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	first, err := s.Create(ctx, board.ID, bytes.NewReader(noise(t, 1)))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	// Near-duplicates are stored unless the store is told to reject them.
+	if _, err := s.Create(ctx, board.ID, bytes.NewReader(noise(t, 1))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	s.SetDuplicatePolicy(moodboard.DuplicatePolicy{Distance: moodboard.DefaultDuplicateDistance, Reject: true})
+
+	var duplicateErr *moodboard.DuplicateError
+
+	_, err = s.Create(ctx, board.ID, bytes.NewReader(noise(t, 1)))
+
+	if !errors.As(err, &duplicateErr) {
+		t.Fatalf("expected error to be a duplicate error but got %q", err)
+	}
+
+	all, err := s.All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 {
+		t.Fatalf("expected to get 2 items but got %d", len(all))
+	}
+
+	if expected := []string{first, all[1].ID}; !reflect.DeepEqual(duplicateErr.IDs, expected) {
+		t.Fatalf("expected duplicates to be %v but got %v", expected, duplicateErr.IDs)
+	}
+
+	// Images which don't look alike are still stored, as are images which can't be decoded.
+	if _, err := s.Create(ctx, board.ID, bytes.NewReader(noise(t, 2))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if _, err := s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+}
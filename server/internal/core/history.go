@@ -0,0 +1,563 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// maxHistory is the maximum number of operations kept in the history of a board.
+const maxHistory = 100
+
+// Operation types which can be kept in the history of a board.
+const (
+	OperationCreate  = "create"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+	OperationMove    = "move"
+	OperationReorder = "reorder"
+	OperationEdit    = "edit"
+	OperationBatch   = "batch"
+)
+
+// Metadata represents the editable fields of an item.
+type Metadata struct {
+	Title   string   `json:"title"`
+	Caption string   `json:"caption"`
+	Source  string   `json:"source"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// metadataOf returns the metadata of an item.
+func metadataOf(item Item) Metadata {
+	return Metadata{
+		Title:   item.Title,
+		Caption: item.Caption,
+		Source:  item.Source,
+		Tags:    item.Tags,
+	}
+}
+
+// equal returns whether two sets of metadata are the same.
+func (m Metadata) equal(other Metadata) bool {
+	if m.Title != other.Title || m.Caption != other.Caption || m.Source != other.Source {
+		return false
+	}
+
+	if len(m.Tags) != len(other.Tags) {
+		return false
+	}
+
+	for i := range m.Tags {
+		if m.Tags[i] != other.Tags[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Place describes where an item is on a board by the items on either side of it, ignoring the items in the trash.
+//
+// An empty After means that the item is at the start of the board, and an empty Before means that it's at the end.
+// Unlike an index, a place still refers to the same spot once items elsewhere on the board are added or removed.
+type Place struct {
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// placeAt returns the place at the specified index amongst some items.
+func placeAt(items []Item, index int) *Place {
+	var p Place
+
+	if index > 0 {
+		p.After = items[index-1].ID
+	}
+
+	if index < len(items) {
+		p.Before = items[index].ID
+	}
+
+	return &p
+}
+
+// Operation represents a change to a board which can be undone.
+type Operation struct {
+	Type string `json:"type"`
+	Item string `json:"item"`
+
+	// FromPlace and ToPlace contain the place of the item before and after a move.
+	FromPlace *Place `json:"fromPlace,omitempty"`
+	ToPlace   *Place `json:"toPlace,omitempty"`
+
+	// From and To contain the index of the item before and after a move, for moves which were recorded before places
+	// were.
+	From int `json:"from,omitempty"`
+	To   int `json:"to,omitempty"`
+
+	// Before and After contain the metadata of the item before and after an edit.
+	Before *Metadata `json:"before,omitempty"`
+	After  *Metadata `json:"after,omitempty"`
+
+	// FromOrder and ToOrder contain the IDs of the items on the board before and after a reorder, for reorders which
+	// were recorded before they were recorded as a batch of moves.
+	FromOrder []string `json:"fromOrder,omitempty"`
+	ToOrder   []string `json:"toOrder,omitempty"`
+
+	// Operations contains the operations which make up a batch, which are undone and redone together.
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+// moveOperations returns the moves which turn one order of the items on a board into another.
+//
+// Only the items which are out of place are moved, so the operations stay small however big the board is. The longest
+// run of items which are already in the right order relative to each other stays where it is, and each of the others
+// is moved straight after the item which comes before it in the new order. Each move is recorded against the board as
+// the moves before it left it, so they need to be undone in the opposite order.
+func moveOperations(from, to []string) []Operation {
+	index := make(map[string]int, len(from))
+
+	for i, id := range from {
+		index[id] = i
+	}
+
+	// Find the longest increasing run of current indexes in the new order. tails contains the position (within to)
+	// of the item at the end of the best run of each length so far, and previous links each item to the one before it
+	// in its run.
+	var tails []int
+
+	previous := make([]int, len(to))
+
+	for i, id := range to {
+		n := sort.Search(len(tails), func(j int) bool {
+			return index[to[tails[j]]] >= index[id]
+		})
+
+		previous[i] = -1
+
+		if n > 0 {
+			previous[i] = tails[n-1]
+		}
+
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+
+	keep := make(map[string]bool, len(tails))
+
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i != -1; i = previous[i] {
+			keep[to[i]] = true
+		}
+	}
+
+	// Keep track of the neighbours of each item as they're moved, with an empty ID standing for either end.
+	before := make(map[string]string, len(from)+1)
+	after := make(map[string]string, len(from)+1)
+	last := ""
+
+	for _, id := range from {
+		before[id], after[last] = last, id
+		last = id
+	}
+
+	before[""], after[last] = last, ""
+
+	var ops []Operation
+
+	last = ""
+
+	for _, id := range to {
+		if !keep[id] && before[id] != last {
+			fromPlace := &Place{After: before[id], Before: after[id]}
+
+			// Take the item out from between its neighbours, and put it straight after the one before it.
+			after[before[id]], before[after[id]] = after[id], before[id]
+			before[id], after[id] = last, after[last]
+			after[last], before[after[id]] = id, id
+
+			ops = append(ops, Operation{
+				Type:      OperationMove,
+				Item:      id,
+				FromPlace: fromPlace,
+				ToPlace:   &Place{After: last, Before: after[id]},
+			})
+		}
+
+		last = id
+	}
+
+	return ops
+}
+
+// without returns the operation with anything involving the specified item removed.
+//
+// The boolean returned by this function indicates whether the operation still has anything left to do.
+func (op Operation) without(id string) (Operation, bool) {
+	if op.Type != OperationBatch {
+		return op, op.Item != id
+	}
+
+	var operations []Operation
+
+	for _, inner := range op.Operations {
+		if inner, ok := inner.without(id); ok {
+			operations = append(operations, inner)
+		}
+	}
+
+	op.Operations = operations
+
+	return op, len(operations) > 0
+}
+
+// record adds an operation to the history of a board.
+//
+// Recording an operation means that anything which has been undone can no longer be redone.
+func record(tx Tx, boardID string, op Operation) error {
+	board, err := tx.Board(boardID)
+
+	if err != nil {
+		return err
+	}
+
+	// Drop the oldest operations once the history is full.
+	start := 0
+
+	if len(board.History) >= maxHistory {
+		start = len(board.History) - maxHistory + 1
+	}
+
+	// Copy the history rather than appending in place, as the record may share it with the backend.
+	history := make([]Operation, 0, len(board.History)-start+1)
+	history = append(history, board.History[start:]...)
+
+	board.History = append(history, op)
+	board.Undone = nil
+
+	if err := tx.PutBoard(board); err != nil {
+		return fmt.Errorf("failed to store history: %w", err)
+	}
+
+	return nil
+}
+
+// recordEdit adds an edit to the history of a board, as long as the metadata of the item has actually changed.
+func recordEdit(tx Tx, boardID string, before Metadata, item Item) error {
+	after := metadataOf(item)
+
+	if before.equal(after) {
+		return nil
+	}
+
+	return record(tx, boardID, Operation{Type: OperationEdit, Item: item.ID, Before: &before, After: &after})
+}
+
+// forget removes all operations involving the specified item from the history of a board.
+//
+// This is used once an item no longer exists, as none of its operations can be undone or redone.
+func forget(tx Tx, boardID, id string) error {
+	board, err := tx.Board(boardID)
+
+	if err != nil {
+		return err
+	}
+
+	var history, undone []Operation
+
+	for _, op := range board.History {
+		if op, ok := op.without(id); ok {
+			history = append(history, op)
+		}
+	}
+
+	for _, op := range board.Undone {
+		if op, ok := op.without(id); ok {
+			undone = append(undone, op)
+		}
+	}
+
+	board.History = history
+	board.Undone = undone
+
+	if err := tx.PutBoard(board); err != nil {
+		return fmt.Errorf("failed to store history: %w", err)
+	}
+
+	return nil
+}
+
+// DropItem removes an item record along with anything involving it from the history of its board, leaving its images
+// alone.
+//
+// This is used by backends to remove items whose image has gone missing, so that they don't break undoing or redoing
+// other operations on the board.
+func DropItem(tx Tx, id string) error {
+	item, err := tx.Item(id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.DeleteItem(id); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	// There's no history or usage to fix if the board itself has gone missing.
+	if err := forget(tx, item.Board, id); errors.Is(err, moodboard.ErrNoSuchBoard) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return addUsage(tx, item.Board, -item.usage())
+}
+
+// trashItem moves an item to the trash.
+func trashItem(tx Tx, item Item, now time.Time) error {
+	// The item keeps its position so that it can be put back in the same place if it's restored.
+	item.DeletedAt = &now
+
+	return tx.PutItem(item)
+}
+
+// restoreItem moves an item out of the trash.
+//
+// The item kept its key whilst it was in the trash, which puts it back where it was on the board.
+func restoreItem(tx Tx, item Item) error {
+	item.DeletedAt = nil
+
+	return tx.PutItem(item)
+}
+
+// placeItem moves an item to the specified index on its board, ignoring the items in the trash.
+//
+// Indexes past the end of the board place the item at the end.
+func placeItem(tx Tx, boardID, id string, index int) error {
+	records, err := sortedRecords(tx, boardID)
+
+	if err != nil {
+		return err
+	}
+
+	var item Item
+
+	found := false
+	rest := make([]Item, 0, len(records))
+
+	// live contains the indexes (within rest) of the items which aren't in the trash.
+	var live []int
+
+	for _, record := range records {
+		if record.ID == id {
+			item = record
+			found = true
+
+			continue
+		}
+
+		if record.DeletedAt == nil {
+			live = append(live, len(rest))
+		}
+
+		rest = append(rest, record)
+	}
+
+	if !found {
+		return moodboard.ErrNoSuchItem
+	}
+
+	if index > len(live) {
+		index = len(live)
+	}
+
+	// Put the item straight after the item which will be before it, or before the first item if there isn't one.
+	at := len(rest)
+
+	if index > 0 {
+		at = live[index-1] + 1
+	} else if len(live) > 0 {
+		at = live[0]
+	}
+
+	return place(tx, rest, at, item)
+}
+
+// placeItemAt moves an item to a place on its board.
+//
+// If the item which should be before it has since been purged then the item goes before the one which should be after
+// it instead, and the item is left where it is if both of them have been purged.
+func placeItemAt(tx Tx, boardID, id string, p Place) error {
+	items, err := sortedItems(tx, boardID)
+
+	if err != nil {
+		return err
+	}
+
+	// Find the indexes of the neighbours once the item has been taken off the board.
+	after, before := -1, -1
+	i := 0
+
+	for _, item := range items {
+		if item.ID == id {
+			continue
+		}
+
+		if item.ID == p.After {
+			after = i
+		} else if item.ID == p.Before {
+			before = i
+		}
+
+		i++
+	}
+
+	switch {
+	case p.After == "":
+		return placeItem(tx, boardID, id, 0)
+	case after != -1:
+		return placeItem(tx, boardID, id, after+1)
+	case p.Before == "":
+		return placeItem(tx, boardID, id, len(items))
+	case before != -1:
+		return placeItem(tx, boardID, id, before)
+	default:
+		return nil
+	}
+}
+
+// arrange puts the items on a board into the specified order, ignoring the items in the trash.
+//
+// IDs which aren't on the board are skipped, and items which aren't in the order are kept in the order they were in,
+// after the rest. Items in the trash keep their place relative to the items around them.
+func arrange(tx Tx, boardID string, order []string) error {
+	records, err := sortedRecords(tx, boardID)
+
+	if err != nil {
+		return err
+	}
+
+	remaining := make(map[string]Item, len(records))
+
+	for _, record := range records {
+		if record.DeletedAt == nil {
+			remaining[record.ID] = record
+		}
+	}
+
+	items := make([]Item, 0, len(remaining))
+
+	for _, id := range order {
+		if item, ok := remaining[id]; ok {
+			items = append(items, item)
+			delete(remaining, id)
+		}
+	}
+
+	for _, record := range records {
+		if _, ok := remaining[record.ID]; ok {
+			items = append(items, record)
+		}
+	}
+
+	// Fill the places of the items which aren't in the trash with the items in their new order.
+	next := 0
+
+	for i := range records {
+		if records[i].DeletedAt == nil {
+			records[i] = items[next]
+			next++
+		}
+	}
+
+	return rebalance(tx, records)
+}
+
+// editItem replaces the metadata of an item.
+func editItem(tx Tx, item Item, m Metadata, now time.Time) error {
+	item.Title = m.Title
+	item.Caption = m.Caption
+	item.Source = m.Source
+	item.Tags = m.Tags
+	item.UpdatedAt = now
+
+	return tx.PutItem(item)
+}
+
+// apply performs an operation, or reverses it if undo is true.
+func apply(tx Tx, boardID string, op Operation, undo bool, now time.Time) error {
+	// Batches are undone in the opposite order to how they were done.
+	if op.Type == OperationBatch {
+		for i := range op.Operations {
+			inner := op.Operations[i]
+
+			if undo {
+				inner = op.Operations[len(op.Operations)-i-1]
+			}
+
+			if err := apply(tx, boardID, inner, undo, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Reorders involve the whole board rather than a single item.
+	if op.Type == OperationReorder {
+		if undo {
+			return arrange(tx, boardID, op.FromOrder)
+		}
+
+		return arrange(tx, boardID, op.ToOrder)
+	}
+
+	item, err := boardRecord(tx, boardID, op.Item)
+
+	if err != nil {
+		return err
+	}
+
+	switch op.Type {
+	case OperationCreate, OperationRestore:
+		// Creating an item can't be undone without losing the image, so the item is moved to the trash instead.
+		if undo {
+			return trashItem(tx, item, now)
+		}
+
+		return restoreItem(tx, item)
+	case OperationDelete:
+		if undo {
+			return restoreItem(tx, item)
+		}
+
+		return trashItem(tx, item, now)
+	case OperationMove:
+		p, index := op.ToPlace, op.To
+
+		if undo {
+			p, index = op.FromPlace, op.From
+		}
+
+		// Indexes go out of date as soon as other items are purged, so they're only used if there isn't a place.
+		if p != nil {
+			return placeItemAt(tx, boardID, item.ID, *p)
+		}
+
+		return placeItem(tx, boardID, item.ID, index)
+	case OperationEdit:
+		if undo {
+			return editItem(tx, item, *op.Before, now)
+		}
+
+		return editItem(tx, item, *op.After, now)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Type)
+	}
+}
@@ -0,0 +1,287 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// placed returns an order with an item moved to a place, making sure that the place is where it ends up.
+func placed(t *testing.T, order []string, id string, p Place) []string {
+	t.Helper()
+
+	rest := make([]string, 0, len(order))
+
+	for _, other := range order {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+
+	at := 0
+
+	for i, other := range rest {
+		if other == p.After {
+			at = i + 1
+		}
+	}
+
+	if (at > 0) != (p.After != "") || (at < len(rest) && rest[at] != p.Before) || (at == len(rest) && p.Before != "") {
+		t.Fatalf("expected %v to be a place in %q", p, rest)
+	}
+
+	return append(rest[:at], append([]string{id}, rest[at:]...)...)
+}
+
+// orderOf returns the IDs of the items on a board.
+func orderOf(t *testing.T, s *Store, boardID string) []string {
+	t.Helper()
+
+	all, err := s.All(context.Background(), boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	order := make([]string, len(all))
+
+	for i, item := range all {
+		order[i] = item.ID
+	}
+
+	return order
+}
+
+func TestPlaceItemAt(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	ids := make([]string, 4)
+
+	for i := range ids {
+		if ids[i], err = s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	tests := []struct {
+		place Place
+		order []string
+	}{
+		{place: Place{Before: ids[0]}, order: []string{ids[3], ids[0], ids[1], ids[2]}},
+		{place: Place{After: ids[1], Before: ids[2]}, order: []string{ids[0], ids[1], ids[3], ids[2]}},
+
+		// Purged neighbours are skipped over, using the neighbour on the other side instead.
+		{place: Place{After: "purged", Before: ids[0]}, order: []string{ids[3], ids[0], ids[1], ids[2]}},
+		{place: Place{After: "purged"}, order: []string{ids[0], ids[1], ids[2], ids[3]}},
+
+		// The item should stay where it is if there's nothing left to place it next to.
+		{place: Place{After: "purged", Before: "purged"}, order: []string{ids[0], ids[1], ids[2], ids[3]}},
+	}
+
+	for _, test := range tests {
+		err := b.Update(ctx, func(tx Tx) error {
+			if err := placeItem(tx, board.ID, ids[3], 3); err != nil {
+				return err
+			}
+
+			return placeItemAt(tx, board.ID, ids[3], test.place)
+		})
+
+		if err != nil {
+			t.Fatalf("failed to place item: %v", err)
+		}
+
+		all, err := s.All(ctx, board.ID)
+
+		if err != nil {
+			t.Fatalf("failed to get store contents: %v", err)
+		}
+
+		order := make([]string, len(all))
+
+		for i, item := range all {
+			order[i] = item.ID
+		}
+
+		if !reflect.DeepEqual(order, test.order) {
+			t.Fatalf("expected order for %v to be %q but got %q", test.place, test.order, order)
+		}
+	}
+}
+
+func TestStoreUndoLegacyMove(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	ids := make([]string, 3)
+
+	for i := range ids {
+		if ids[i], err = s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	// Moves recorded before places were only have indexes.
+	err = b.Update(ctx, func(tx Tx) error {
+		if err := placeItem(tx, board.ID, ids[0], 2); err != nil {
+			return err
+		}
+
+		return record(tx, board.ID, Operation{Type: OperationMove, Item: ids[0], From: 0, To: 2})
+	})
+
+	if err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if err := s.Undo(ctx, board.ID); err != nil {
+		t.Fatalf("failed to undo move: %v", err)
+	}
+
+	all, err := s.All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 3 || all[0].ID != ids[0] {
+		t.Fatalf("expected %q to be first but got %v", ids[0], all)
+	}
+}
+
+func TestMoveOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 20; n++ {
+		from := make([]string, n)
+
+		for i := range from {
+			from[i] = strconv.Itoa(i)
+		}
+
+		to := make([]string, n)
+
+		for i, j := range r.Perm(n) {
+			to[i] = from[j]
+		}
+
+		ops := moveOperations(from, to)
+
+		// Doing the moves should give the new order, and undoing them in reverse should give the old order again.
+		order := from
+
+		for _, op := range ops {
+			order = placed(t, order, op.Item, *op.ToPlace)
+		}
+
+		if !reflect.DeepEqual(order, to) {
+			t.Fatalf("expected moves from %q to give %q but got %q", from, to, order)
+		}
+
+		for i := len(ops) - 1; i >= 0; i-- {
+			order = placed(t, order, ops[i].Item, *ops[i].FromPlace)
+		}
+
+		if !reflect.DeepEqual(order, from) {
+			t.Fatalf("expected undoing moves to %q to give %q but got %q", to, from, order)
+		}
+	}
+
+	// Only the items which are out of place should be moved.
+	from := []string{"a", "b", "c", "d", "e", "f"}
+
+	if ops := moveOperations(from, from); len(ops) != 0 {
+		t.Fatalf("expected no moves but got %d", len(ops))
+	}
+
+	if ops := moveOperations(from, []string{"b", "c", "d", "e", "a", "f"}); len(ops) != 1 || ops[0].Item != "a" {
+		t.Fatalf("expected only %q to be moved but got %+v", "a", ops)
+	}
+}
+
+func TestStoreBatchHistory(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	ids := make([]string, 50)
+
+	for i := range ids {
+		if ids[i], err = s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	reordered := append(append([]string{ids[1], ids[0]}, ids[2:49]...), ids[49])
+	moved := append(append([]string{}, reordered[2:]...), ids[1], ids[0])
+
+	for _, fn := range []func() error{
+		func() error { return s.Reorder(ctx, board.ID, reordered) },
+		func() error { return s.MoveManyAfter(ctx, board.ID, []string{ids[1], ids[0]}, ids[49]) },
+	} {
+		if err := fn(); err != nil {
+			t.Fatalf("failed to arrange items: %v", err)
+		}
+
+		// The history should only contain the items which moved, rather than the order of the whole board.
+		record, err := b.idx.Board(board.ID)
+
+		if err != nil {
+			t.Fatalf("failed to get board: %v", err)
+		}
+
+		op := record.History[len(record.History)-1]
+
+		if op.Type != OperationBatch || len(op.Operations) > 2 || op.FromOrder != nil || op.ToOrder != nil {
+			t.Fatalf("expected a batch of at most 2 moves but got %+v", op)
+		}
+	}
+
+	if order := orderOf(t, s, board.ID); !reflect.DeepEqual(order, moved) {
+		t.Fatalf("expected order to be %q but got %q", moved, order)
+	}
+
+	for _, expected := range [][]string{reordered, ids} {
+		if err := s.Undo(ctx, board.ID); err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+
+		if order := orderOf(t, s, board.ID); !reflect.DeepEqual(order, expected) {
+			t.Fatalf("expected order to be %q but got %q", expected, order)
+		}
+	}
+
+	for _, expected := range [][]string{reordered, moved} {
+		if err := s.Redo(ctx, board.ID); err != nil {
+			t.Fatalf("failed to redo: %v", err)
+		}
+
+		if order := orderOf(t, s, board.ID); !reflect.DeepEqual(order, expected) {
+			t.Fatalf("expected order to be %q but got %q", expected, order)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// Index is an in-memory set of board and item records.
+//
+// Index implements all of the record methods of Tx, which allows backends that keep their records in a single
+// document to share the same logic. Records can be looked up, stored and removed without going through the rest of
+// them.
+type Index struct {
+	BoardRecords []Board `json:"boards"`
+	ItemRecords  []Item  `json:"items"`
+
+	// boardIndexes and itemIndexes contain the index of each record within BoardRecords and ItemRecords, keyed by ID,
+	// and boardItems contains the IDs of the items on each board. They're built the first time that they're needed, so
+	// the records mustn't be changed directly after that.
+	boardIndexes map[string]int
+	itemIndexes  map[string]int
+	boardItems   map[string]map[string]bool
+
+	// built makes sure that the maps are only built once, as several read-only transactions can share an index.
+	built sync.Once
+}
+
+// lookup builds the maps used to find records, if they haven't been built already.
+func (idx *Index) lookup() {
+	idx.built.Do(idx.build)
+}
+
+// build builds the maps used to find records.
+func (idx *Index) build() {
+	idx.boardIndexes = make(map[string]int, len(idx.BoardRecords))
+	idx.itemIndexes = make(map[string]int, len(idx.ItemRecords))
+	idx.boardItems = make(map[string]map[string]bool)
+
+	for i, board := range idx.BoardRecords {
+		idx.boardIndexes[board.ID] = i
+	}
+
+	for i, item := range idx.ItemRecords {
+		idx.itemIndexes[item.ID] = i
+		idx.addToBoard(item)
+	}
+}
+
+// addToBoard adds an item to the IDs of the items on its board.
+func (idx *Index) addToBoard(item Item) {
+	ids, ok := idx.boardItems[item.Board]
+
+	if !ok {
+		ids = make(map[string]bool)
+		idx.boardItems[item.Board] = ids
+	}
+
+	ids[item.ID] = true
+}
+
+// Boards returns all board records.
+func (idx *Index) Boards() ([]Board, error) {
+	boards := make([]Board, len(idx.BoardRecords))
+
+	copy(boards, idx.BoardRecords)
+
+	return boards, nil
+}
+
+// Board returns the board record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (idx *Index) Board(id string) (Board, error) {
+	idx.lookup()
+
+	i, ok := idx.boardIndexes[id]
+
+	if !ok {
+		return Board{}, moodboard.ErrNoSuchBoard
+	}
+
+	return idx.BoardRecords[i], nil
+}
+
+// PutBoard creates or replaces a board record.
+func (idx *Index) PutBoard(board Board) error {
+	idx.lookup()
+
+	// Replace the existing record if we have one.
+	if i, ok := idx.boardIndexes[board.ID]; ok {
+		idx.BoardRecords[i] = board
+
+		return nil
+	}
+
+	idx.boardIndexes[board.ID] = len(idx.BoardRecords)
+	idx.BoardRecords = append(idx.BoardRecords, board)
+
+	return nil
+}
+
+// DeleteBoard removes a board record.
+func (idx *Index) DeleteBoard(id string) error {
+	idx.lookup()
+
+	i, ok := idx.boardIndexes[id]
+
+	if !ok {
+		return nil
+	}
+
+	// Move the last record into the place of the one being removed, rather than shifting everything after it along.
+	last := len(idx.BoardRecords) - 1
+	idx.BoardRecords[i] = idx.BoardRecords[last]
+	idx.boardIndexes[idx.BoardRecords[i].ID] = i
+	idx.BoardRecords = idx.BoardRecords[:last]
+
+	delete(idx.boardIndexes, id)
+
+	return nil
+}
+
+// Items returns all item records on the specified board, in no particular order.
+func (idx *Index) Items(boardID string) ([]Item, error) {
+	idx.lookup()
+
+	var items []Item
+
+	for id := range idx.boardItems[boardID] {
+		items = append(items, idx.ItemRecords[idx.itemIndexes[id]])
+	}
+
+	return items, nil
+}
+
+// Item returns the item record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
+func (idx *Index) Item(id string) (Item, error) {
+	idx.lookup()
+
+	i, ok := idx.itemIndexes[id]
+
+	if !ok {
+		return Item{}, moodboard.ErrNoSuchItem
+	}
+
+	return idx.ItemRecords[i], nil
+}
+
+// PutItem creates or replaces an item record.
+func (idx *Index) PutItem(item Item) error {
+	idx.lookup()
+
+	// Replace the existing record if we have one, making sure that it isn't left behind on its old board.
+	if i, ok := idx.itemIndexes[item.ID]; ok {
+		delete(idx.boardItems[idx.ItemRecords[i].Board], item.ID)
+		idx.ItemRecords[i] = item
+		idx.addToBoard(item)
+
+		return nil
+	}
+
+	idx.itemIndexes[item.ID] = len(idx.ItemRecords)
+	idx.ItemRecords = append(idx.ItemRecords, item)
+	idx.addToBoard(item)
+
+	return nil
+}
+
+// DeleteItem removes an item record.
+func (idx *Index) DeleteItem(id string) error {
+	idx.lookup()
+
+	i, ok := idx.itemIndexes[id]
+
+	if !ok {
+		return nil
+	}
+
+	delete(idx.boardItems[idx.ItemRecords[i].Board], id)
+
+	// Move the last record into the place of the one being removed, rather than shifting everything after it along.
+	last := len(idx.ItemRecords) - 1
+	idx.ItemRecords[i] = idx.ItemRecords[last]
+	idx.itemIndexes[idx.ItemRecords[i].ID] = i
+	idx.ItemRecords = idx.ItemRecords[:last]
+
+	delete(idx.itemIndexes, id)
+
+	return nil
+}
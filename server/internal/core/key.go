@@ -0,0 +1,256 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// keyDigits contains the digits used in position keys, in ascending order.
+//
+// Keys are the digits of a fraction between 0 and 1 (so "V" is roughly 0.5), which means that keys can be compared as
+// strings and that there is always room for another key between two different keys. Keys never end in the smallest
+// digit, as otherwise there would be no key between "V" and "V0".
+const keyDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// legacyKeyLength is the length of the keys given to items which were stored with an integer position.
+const legacyKeyLength = 6
+
+// maxKeyLength is the length that keys can grow to before the items on a board are given new, evenly spaced keys.
+//
+// Repeatedly placing items between the same two items makes keys grow by roughly one digit every six moves.
+const maxKeyLength = 32
+
+// suffix returns s without its first n bytes, or an empty string if s is shorter than that.
+func suffix(s string, n int) string {
+	if n >= len(s) {
+		return ""
+	}
+
+	return s[n:]
+}
+
+// keyBetween returns a key which sorts between a and b.
+//
+// An empty a means the start of the board, and an empty b means the end of the board. a must sort before b.
+func keyBetween(a, b string) string {
+	if b != "" {
+		n := 0
+
+		// Keep any prefix that both keys share. Missing digits on the end of a are treated as the smallest digit.
+		for n < len(b) && (n < len(a) && a[n] == b[n] || n >= len(a) && b[n] == keyDigits[0]) {
+			n++
+		}
+
+		if n > 0 {
+			return b[:n] + keyBetween(suffix(a, n), b[n:])
+		}
+	}
+
+	digitA := 0
+
+	if a != "" {
+		digitA = strings.IndexByte(keyDigits, a[0])
+	}
+
+	digitB := len(keyDigits)
+
+	if b != "" {
+		digitB = strings.IndexByte(keyDigits, b[0])
+	}
+
+	// If there's a digit in between the first digits of the keys then we can just use that.
+	if digitB-digitA > 1 {
+		return string(keyDigits[(digitA+digitB+1)/2])
+	}
+
+	// The first digits are next to each other. If b has more digits then its first digit on its own sorts before it.
+	if len(b) > 1 {
+		return b[:1]
+	}
+
+	// Otherwise we need to keep the first digit of a and find a key after the rest of it.
+	return string(keyDigits[digitA]) + keyBetween(suffix(a, 1), "")
+}
+
+// encodeKey returns the key for the fraction n / 62^length.
+func encodeKey(n uint64, length int) string {
+	buf := make([]byte, length)
+
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = keyDigits[n%uint64(len(keyDigits))]
+		n /= uint64(len(keyDigits))
+	}
+
+	// Trailing zeros don't change the value of the fraction, and keys can't end with them.
+	return strings.TrimRight(string(buf), keyDigits[:1])
+}
+
+// spacedKeys returns n keys which are evenly spaced between the start and the end of a board.
+func spacedKeys(n int) []string {
+	// Use the shortest keys which leave a gap of at least one whole digit between each key.
+	length := 1
+	size := uint64(len(keyDigits))
+
+	for size/uint64(n+1) < uint64(len(keyDigits)) {
+		length++
+		size *= uint64(len(keyDigits))
+	}
+
+	keys := make([]string, n)
+
+	for i := range keys {
+		keys[i] = encodeKey(uint64(i+1)*size/uint64(n+1), length)
+	}
+
+	return keys
+}
+
+// key returns the position key of an item.
+//
+// Items which were stored with an integer position are given a key based on it, which sorts them in the same order.
+func (item Item) key() string {
+	if item.Key != "" {
+		return item.Key
+	}
+
+	return encodeKey(uint64(item.Position)+1, legacyKeyLength)
+}
+
+// sortItems sorts items by their position keys.
+func sortItems(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		ki, kj := items[i].key(), items[j].key()
+
+		// Keys should be unique, but the ID gives us a consistent order if they aren't.
+		if ki == kj {
+			return items[i].ID < items[j].ID
+		}
+
+		return ki < kj
+	})
+}
+
+// after returns whether an item sorts after the specified key and ID.
+func (item Item) after(key, id string) bool {
+	if k := item.key(); k != key {
+		return k > key
+	}
+
+	return item.ID > id
+}
+
+// encodeCursor returns a cursor which refers to the place on a board just after the specified item.
+//
+// The cursor contains the ID of the item rather than its index, so pages carry on from wherever the item is now if
+// items are added to the board or it's reordered. The key of the item is included as well, so that there's still a
+// place to carry on from if the item is purged.
+func encodeCursor(item Item) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(item.key() + "/" + item.ID))
+}
+
+// decodeCursor returns the key and ID contained in a cursor.
+//
+// This function will return moodboard.ErrInvalidCursor if the cursor was not created by encodeCursor.
+func decodeCursor(cursor string) (string, string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return "", "", moodboard.ErrInvalidCursor
+	}
+
+	// Keys never contain a slash, so the first one separates the key from the ID.
+	s := string(buf)
+	i := strings.IndexByte(s, '/')
+
+	if i < 1 || i == len(s)-1 {
+		return "", "", moodboard.ErrInvalidCursor
+	}
+
+	for _, c := range s[:i] {
+		if !strings.ContainsRune(keyDigits, c) {
+			return "", "", moodboard.ErrInvalidCursor
+		}
+	}
+
+	return s[:i], s[i+1:], nil
+}
+
+// keysBetween returns n keys in ascending order which all sort between a and b.
+//
+// The keys are spread out by repeatedly splitting the range in half, which keeps them as short as possible.
+func keysBetween(a, b string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+
+	mid := keyBetween(a, b)
+	half := n / 2
+
+	keys := append(keysBetween(a, mid, half), mid)
+
+	return append(keys, keysBetween(mid, b, n-half-1)...)
+}
+
+// keysAt returns n keys which place items at the specified index within the sorted records.
+func keysAt(records []Item, index, n int) []string {
+	var before, after string
+
+	if index > 0 {
+		before = records[index-1].key()
+	}
+
+	if index < len(records) {
+		after = records[index].key()
+	}
+
+	return keysBetween(before, after, n)
+}
+
+// rebalance gives all of the specified records new, evenly spaced keys.
+//
+// The records must be sorted, and are updated in place.
+func rebalance(tx Tx, records []Item) error {
+	for i, key := range spacedKeys(len(records)) {
+		records[i].Key = key
+
+		if err := tx.PutItem(records[i]); err != nil {
+			return fmt.Errorf("failed to store item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// place stores items at the specified index within the sorted records of their board, keeping them in order.
+//
+// The records must not include the items themselves. Only the items are stored, unless their keys would be too long -
+// in which case all of the records are given new keys first.
+func place(tx Tx, records []Item, index int, items ...Item) error {
+	keys := keysAt(records, index, len(items))
+
+	for _, key := range keys {
+		if len(key) > maxKeyLength {
+			if err := rebalance(tx, records); err != nil {
+				return err
+			}
+
+			keys = keysAt(records, index, len(items))
+
+			break
+		}
+	}
+
+	for i, item := range items {
+		item.Key = keys[i]
+
+		if err := tx.PutItem(item); err != nil {
+			return fmt.Errorf("failed to store item: %w", err)
+		}
+	}
+
+	return nil
+}
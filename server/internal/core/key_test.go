@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testTx is a transaction against a testBackend.
+type testTx struct {
+	*Index
+	backend *testBackend
+}
+
+func (t testTx) PutItem(item Item) error {
+	t.backend.puts++
+
+	return t.Index.PutItem(item)
+}
+
+func (t testTx) CreateImage(id string, img io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, img)
+
+	return err
+}
+
+func (t testTx) Image(id string) (io.Reader, error) {
+	return bytes.NewReader(nil), nil
+}
+
+func (t testTx) DeleteImage(id string) error {
+	return nil
+}
+
+// testBackend is an in-memory Backend which counts the number of items stored.
+//
+// Changes are made in place, so failed transactions aren't rolled back.
+type testBackend struct {
+	idx  Index
+	puts int
+}
+
+func (b *testBackend) View(_ context.Context, fn func(Tx) error) error {
+	return fn(testTx{Index: &b.idx, backend: b})
+}
+
+func (b *testBackend) Update(_ context.Context, fn func(Tx) error) error {
+	return fn(testTx{Index: &b.idx, backend: b})
+}
+
+// checkKey makes sure that a key is valid and sorts between a and b.
+func checkKey(t *testing.T, a, b, key string) {
+	t.Helper()
+
+	if key == "" || strings.HasSuffix(key, keyDigits[:1]) {
+		t.Fatalf("expected key between %q and %q to be valid but got %q", a, b, key)
+	}
+
+	for _, c := range key {
+		if !strings.ContainsRune(keyDigits, c) {
+			t.Fatalf("expected key between %q and %q to be valid but got %q", a, b, key)
+		}
+	}
+
+	if key <= a || (b != "" && key >= b) {
+		t.Fatalf("expected key between %q and %q but got %q", a, b, key)
+	}
+}
+
+func TestKeyBetween(t *testing.T) {
+	cs := []struct {
+		a   string
+		b   string
+		key string
+	}{
+		{a: "", b: "", key: "V"},
+		{a: "V", b: "", key: "l"},
+		{a: "", b: "V", key: "G"},
+		{a: "z", b: "", key: "zV"},
+		{a: "V", b: "W", key: "VV"},
+		{a: "V", b: "V1", key: "V0V"},
+		{a: "A", b: "B1", key: "B"},
+		{a: "0001", b: "0002", key: "0001V"},
+	}
+
+	for _, c := range cs {
+		if key := keyBetween(c.a, c.b); key != c.key {
+			t.Errorf("expected key between %q and %q to be %q but got %q", c.a, c.b, c.key, key)
+		}
+	}
+
+	// Repeatedly inserting keys in random places should always give a key in the right place.
+	r := rand.New(rand.NewSource(1))
+	keys := []string{keyBetween("", "")}
+
+	for i := 0; i < 1000; i++ {
+		index := r.Intn(len(keys) + 1)
+
+		var a, b string
+
+		if index > 0 {
+			a = keys[index-1]
+		}
+
+		if index < len(keys) {
+			b = keys[index]
+		}
+
+		key := keyBetween(a, b)
+		checkKey(t, a, b, key)
+
+		keys = append(keys, "")
+		copy(keys[index+1:], keys[index:])
+		keys[index] = key
+	}
+}
+
+func TestSpacedKeys(t *testing.T) {
+	for _, n := range []int{1, 2, 61, 62, 1000, 100000} {
+		keys := spacedKeys(n)
+
+		if len(keys) != n {
+			t.Fatalf("expected %d keys but got %d", n, len(keys))
+		}
+
+		for i, key := range keys {
+			var before string
+
+			if i > 0 {
+				before = keys[i-1]
+			}
+
+			checkKey(t, before, "", key)
+		}
+	}
+}
+
+func TestItemLegacyKey(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		a, b := Item{Position: i}.key(), Item{Position: i + 1}.key()
+
+		if a >= b {
+			t.Fatalf("expected key for position %d (%q) to sort before position %d (%q)", i, a, i+1, b)
+		}
+	}
+}
+
+func TestStoreMoveStoresOneItem(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	ids := make([]string, 100)
+
+	for i := range ids {
+		if ids[i], err = s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	b.puts = 0
+
+	if err := s.MoveBefore(ctx, board.ID, ids[99], ids[0]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if b.puts != 1 {
+		t.Fatalf("expected 1 item to be stored but got %d", b.puts)
+	}
+}
+
+func TestStoreRebalance(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	ids := make([]string, 10)
+
+	for i := range ids {
+		if ids[i], err = s.Create(ctx, board.ID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	// Moving the last item in between the first two items over and over again makes the keys grow, until they're
+	// rebalanced.
+	for i := 0; i < 1000; i++ {
+		if err := s.MoveAfter(ctx, board.ID, ids[len(ids)-1], ids[0]); err != nil {
+			t.Fatalf("failed to move item: %v", err)
+		}
+
+		last := ids[len(ids)-1]
+		copy(ids[2:], ids[1:len(ids)-1])
+		ids[1] = last
+
+		for _, item := range b.idx.ItemRecords {
+			if len(item.Key) > maxKeyLength {
+				t.Fatalf("expected key to be at most %d long but got %q", maxKeyLength, item.Key)
+			}
+		}
+	}
+
+	all, err := s.All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	for i := range all {
+		if all[i].ID != ids[i] {
+			t.Fatalf("expected all[%d] to be %q but got %q", i, ids[i], all[i].ID)
+		}
+	}
+}
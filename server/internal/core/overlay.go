@@ -0,0 +1,209 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// Changes contains the records which were changed by a transaction.
+type Changes struct {
+	Boards        []Board  `json:"boards,omitempty"`
+	Items         []Item   `json:"items,omitempty"`
+	DeletedBoards []string `json:"deletedBoards,omitempty"`
+	DeletedItems  []string `json:"deletedItems,omitempty"`
+}
+
+// Empty returns whether there are no changes.
+func (c Changes) Empty() bool {
+	return len(c.Boards) == 0 && len(c.Items) == 0 && len(c.DeletedBoards) == 0 && len(c.DeletedItems) == 0
+}
+
+// Apply makes the changes to an index.
+func (c Changes) Apply(idx *Index) {
+	// The index methods never fail, so there's no need to check the errors.
+	for _, id := range c.DeletedItems {
+		_ = idx.DeleteItem(id)
+	}
+
+	for _, id := range c.DeletedBoards {
+		_ = idx.DeleteBoard(id)
+	}
+
+	for _, board := range c.Boards {
+		_ = idx.PutBoard(board)
+	}
+
+	for _, item := range c.Items {
+		_ = idx.PutItem(item)
+	}
+}
+
+// Overlay keeps track of changes to an index without modifying it.
+//
+// Overlay implements all of the record methods of Tx, reading through to the index for anything which hasn't been
+// changed. This allows a transaction to be thrown away without having to copy the index first, and lets backends find
+// out which records need writing once it succeeds.
+type Overlay struct {
+	base *Index
+
+	// boards and items contain the records which have been changed, keyed by ID. Deleted records are nil.
+	boards map[string]*Board
+	items  map[string]*Item
+}
+
+// NewOverlay creates a new overlay on top of an index.
+func NewOverlay(base *Index) *Overlay {
+	return &Overlay{
+		base:   base,
+		boards: make(map[string]*Board),
+		items:  make(map[string]*Item),
+	}
+}
+
+// Changes returns the changes made through the overlay, in order of ID.
+func (o *Overlay) Changes() Changes {
+	var c Changes
+
+	for id, board := range o.boards {
+		if board == nil {
+			c.DeletedBoards = append(c.DeletedBoards, id)
+		} else {
+			c.Boards = append(c.Boards, *board)
+		}
+	}
+
+	for id, item := range o.items {
+		if item == nil {
+			c.DeletedItems = append(c.DeletedItems, id)
+		} else {
+			c.Items = append(c.Items, *item)
+		}
+	}
+
+	sort.Slice(c.Boards, func(i, j int) bool {
+		return c.Boards[i].ID < c.Boards[j].ID
+	})
+
+	sort.Slice(c.Items, func(i, j int) bool {
+		return c.Items[i].ID < c.Items[j].ID
+	})
+
+	sort.Strings(c.DeletedBoards)
+	sort.Strings(c.DeletedItems)
+
+	return c
+}
+
+// Boards returns all board records.
+func (o *Overlay) Boards() ([]Board, error) {
+	base, err := o.base.Boards()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var boards []Board
+
+	for _, board := range base {
+		if _, ok := o.boards[board.ID]; !ok {
+			boards = append(boards, board)
+		}
+	}
+
+	for _, board := range o.boards {
+		if board != nil {
+			boards = append(boards, *board)
+		}
+	}
+
+	return boards, nil
+}
+
+// Board returns the board record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (o *Overlay) Board(id string) (Board, error) {
+	board, ok := o.boards[id]
+
+	if !ok {
+		return o.base.Board(id)
+	}
+
+	if board == nil {
+		return Board{}, moodboard.ErrNoSuchBoard
+	}
+
+	return *board, nil
+}
+
+// PutBoard creates or replaces a board record.
+func (o *Overlay) PutBoard(board Board) error {
+	o.boards[board.ID] = &board
+
+	return nil
+}
+
+// DeleteBoard removes a board record.
+func (o *Overlay) DeleteBoard(id string) error {
+	o.boards[id] = nil
+
+	return nil
+}
+
+// Items returns all item records on the specified board, in no particular order.
+func (o *Overlay) Items(boardID string) ([]Item, error) {
+	base, err := o.base.Items(boardID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+
+	// Changed items may have moved to another board, so they're added separately.
+	for _, item := range base {
+		if _, ok := o.items[item.ID]; !ok {
+			items = append(items, item)
+		}
+	}
+
+	for _, item := range o.items {
+		if item != nil && item.Board == boardID {
+			items = append(items, *item)
+		}
+	}
+
+	return items, nil
+}
+
+// Item returns the item record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
+func (o *Overlay) Item(id string) (Item, error) {
+	item, ok := o.items[id]
+
+	if !ok {
+		return o.base.Item(id)
+	}
+
+	if item == nil {
+		return Item{}, moodboard.ErrNoSuchItem
+	}
+
+	return *item, nil
+}
+
+// PutItem creates or replaces an item record.
+func (o *Overlay) PutItem(item Item) error {
+	o.items[item.ID] = &item
+
+	return nil
+}
+
+// DeleteItem removes an item record.
+func (o *Overlay) DeleteItem(id string) error {
+	o.items[id] = nil
+
+	return nil
+}
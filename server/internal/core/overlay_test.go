@@ -0,0 +1,93 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// itemIDs returns the IDs of some items, sorted so that they can be compared.
+func itemIDs(items []Item) []string {
+	ids := make([]string, 0, len(items))
+
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestOverlay(t *testing.T) {
+	base := &Index{
+		BoardRecords: []Board{
+			{Board: moodboard.Board{ID: "first"}},
+			{Board: moodboard.Board{ID: "second"}},
+		},
+		ItemRecords: []Item{
+			{Item: moodboard.Item{ID: "a"}, Board: "first"},
+			{Item: moodboard.Item{ID: "b"}, Board: "first"},
+			{Item: moodboard.Item{ID: "c"}, Board: "second"},
+		},
+	}
+
+	o := NewOverlay(base)
+
+	_ = o.PutItem(Item{Item: moodboard.Item{ID: "a"}, Board: "second"})
+	_ = o.PutItem(Item{Item: moodboard.Item{ID: "d"}, Board: "first"})
+	_ = o.DeleteItem("b")
+	_ = o.DeleteBoard("second")
+
+	// Changes should be seen through the overlay without affecting the index underneath it.
+	if items, _ := o.Items("first"); !reflect.DeepEqual(itemIDs(items), []string{"d"}) {
+		t.Fatalf("expected items on first board to be [\"d\"] but got %v", itemIDs(items))
+	}
+
+	if items, _ := o.Items("second"); !reflect.DeepEqual(itemIDs(items), []string{"a", "c"}) {
+		t.Fatalf("expected items on second board to be [\"a\", \"c\"] but got %v", itemIDs(items))
+	}
+
+	if _, err := o.Item("b"); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := o.Board("second"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	if items, _ := base.Items("first"); !reflect.DeepEqual(itemIDs(items), []string{"a", "b"}) {
+		t.Fatalf("expected items on first board to be [\"a\", \"b\"] but got %v", itemIDs(items))
+	}
+
+	changes := o.Changes()
+
+	if ids := itemIDs(changes.Items); !reflect.DeepEqual(ids, []string{"a", "d"}) {
+		t.Fatalf("expected changed items to be [\"a\", \"d\"] but got %v", ids)
+	}
+
+	if !reflect.DeepEqual(changes.DeletedItems, []string{"b"}) {
+		t.Fatalf("expected deleted items to be [\"b\"] but got %v", changes.DeletedItems)
+	}
+
+	if !reflect.DeepEqual(changes.DeletedBoards, []string{"second"}) {
+		t.Fatalf("expected deleted boards to be [\"second\"] but got %v", changes.DeletedBoards)
+	}
+
+	// Applying the changes should leave the index looking the same as the overlay.
+	changes.Apply(base)
+
+	if items, _ := base.Items("first"); !reflect.DeepEqual(itemIDs(items), []string{"d"}) {
+		t.Fatalf("expected items on first board to be [\"d\"] but got %v", itemIDs(items))
+	}
+
+	if items, _ := base.Items("second"); !reflect.DeepEqual(itemIDs(items), []string{"a", "c"}) {
+		t.Fatalf("expected items on second board to be [\"a\", \"c\"] but got %v", itemIDs(items))
+	}
+
+	if boards, _ := base.Boards(); len(boards) != 1 || boards[0].ID != "first" {
+		t.Fatalf("expected boards to be [\"first\"] but got %v", boards)
+	}
+}
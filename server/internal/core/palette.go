@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// paletteSize is the maximum number of colours in the palette of an image.
+const paletteSize = 5
+
+// boardPaletteSize is the maximum number of colours in the palette of a board.
+const boardPaletteSize = 8
+
+// paletteSampleSize is the length of the long edge that images are shrunk to before their palette is worked out.
+//
+// The palette only needs a rough idea of the colours in an image, and clustering every pixel of a large image would be
+// very slow.
+const paletteSampleSize = 64
+
+// maxClusterIterations is the maximum number of times that the clusters are refined when working out a palette.
+const maxClusterIterations = 20
+
+// colourPoint represents a weighted colour in RGB space.
+type colourPoint struct {
+	r, g, b float64
+	weight  float64
+}
+
+// distance returns the squared distance between two colours.
+func (p colourPoint) distance(q colourPoint) float64 {
+	dr, dg, db := p.r-q.r, p.g-q.g, p.b-q.b
+
+	return dr*dr + dg*dg + db*db
+}
+
+// nearest returns the index of the centre which is closest to a colour.
+func (p colourPoint) nearest(centres []colourPoint) int {
+	best, bestDistance := 0, p.distance(centres[0])
+
+	for i := 1; i < len(centres); i++ {
+		if d := p.distance(centres[i]); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+
+	return best
+}
+
+// cluster groups colours into at most k clusters using k-means, returning the centre of each cluster weighted by the
+// total weight of the colours in it.
+//
+// The first centres are picked using k-means++ with a fixed seed, so the same colours always give the same clusters.
+func cluster(points []colourPoint, k int) []colourPoint {
+	if len(points) == 0 {
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(1))
+	centres := []colourPoint{points[r.Intn(len(points))]}
+	distances := make([]float64, len(points))
+
+	// Pick each of the other centres at random, favouring colours which are far away from the centres we already have.
+	for len(centres) < k {
+		total := 0.0
+
+		for i, p := range points {
+			distances[i] = p.distance(centres[p.nearest(centres)]) * p.weight
+			total += distances[i]
+		}
+
+		// Every colour is already one of the centres, so there's nothing left to pick.
+		if total == 0 {
+			break
+		}
+
+		target := r.Float64() * total
+		i := 0
+
+		for i < len(points)-1 && target >= distances[i] {
+			target -= distances[i]
+			i++
+		}
+
+		centres = append(centres, points[i])
+	}
+
+	assignments := make([]int, len(points))
+
+	for i := range assignments {
+		assignments[i] = -1
+	}
+
+	// Move each centre to the middle of the colours closest to it, until the colours stop moving between clusters.
+	for iteration := 0; iteration < maxClusterIterations; iteration++ {
+		changed := false
+
+		for i, p := range points {
+			if nearest := p.nearest(centres); nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+
+		sums := make([]colourPoint, len(centres))
+
+		for i, p := range points {
+			sum := &sums[assignments[i]]
+			sum.r += p.r * p.weight
+			sum.g += p.g * p.weight
+			sum.b += p.b * p.weight
+			sum.weight += p.weight
+		}
+
+		for i, sum := range sums {
+			// Centres without any colours are left where they are, and are dropped at the end.
+			if sum.weight == 0 {
+				centres[i].weight = 0
+
+				continue
+			}
+
+			centres[i] = colourPoint{r: sum.r / sum.weight, g: sum.g / sum.weight, b: sum.b / sum.weight, weight: sum.weight}
+		}
+	}
+
+	clusters := make([]colourPoint, 0, len(centres))
+
+	for _, centre := range centres {
+		if centre.weight > 0 {
+			clusters = append(clusters, centre)
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].weight > clusters[j].weight
+	})
+
+	return clusters
+}
+
+// colours converts clusters into a palette, with weights which add up to 1.
+func colours(clusters []colourPoint) []moodboard.Colour {
+	if len(clusters) == 0 {
+		return nil
+	}
+
+	total := 0.0
+
+	for _, c := range clusters {
+		total += c.weight
+	}
+
+	palette := make([]moodboard.Colour, len(clusters))
+
+	for i, c := range clusters {
+		palette[i] = moodboard.Colour{
+			Hex:    fmt.Sprintf("#%02x%02x%02x", int(math.Round(c.r)), int(math.Round(c.g)), int(math.Round(c.b))),
+			Weight: c.weight / total,
+		}
+	}
+
+	return palette
+}
+
+// parseColour converts a colour from a palette back into a point, returning false if its hex notation is invalid.
+func parseColour(colour moodboard.Colour) (colourPoint, bool) {
+	var r, g, b uint8
+
+	if len(colour.Hex) != 7 {
+		return colourPoint{}, false
+	}
+
+	if _, err := fmt.Sscanf(colour.Hex, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return colourPoint{}, false
+	}
+
+	return colourPoint{r: float64(r), g: float64(g), b: float64(b), weight: colour.Weight}, true
+}
+
+// palette returns the dominant colours of an image, most dominant first.
+//
+// Mostly transparent pixels are ignored, so images which are completely transparent don't have a palette.
+func palette(img *image.RGBA) []moodboard.Colour {
+	if img.Rect.Dx() > paletteSampleSize || img.Rect.Dy() > paletteSampleSize {
+		img = scale(img, paletteSampleSize)
+	}
+
+	points := make([]colourPoint, 0, img.Rect.Dx()*img.Rect.Dy())
+
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			a := img.Pix[i+3]
+
+			if a < 0x80 {
+				continue
+			}
+
+			// The colours of pixels in an RGBA image are multiplied by their alpha, which we need to undo.
+			f := 0xff / float64(a)
+			points = append(points, colourPoint{
+				r:      float64(img.Pix[i]) * f,
+				g:      float64(img.Pix[i+1]) * f,
+				b:      float64(img.Pix[i+2]) * f,
+				weight: 1,
+			})
+		}
+	}
+
+	return colours(cluster(points, paletteSize))
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestCluster(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var points []colourPoint
+
+	// Two groups of colours, scattered around red and blue.
+	for i := 0; i < 150; i++ {
+		p := colourPoint{r: 245, g: 10, b: 10, weight: 1}
+
+		if i >= 100 {
+			p = colourPoint{r: 10, g: 10, b: 245, weight: 1}
+		}
+
+		p.r += r.Float64()*10 - 5
+		p.g += r.Float64()*10 - 5
+		p.b += r.Float64()*10 - 5
+
+		points = append(points, p)
+	}
+
+	clusters := cluster(points, 2)
+
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 clusters but got %d", len(clusters))
+	}
+
+	centres := []colourPoint{{r: 245, g: 10, b: 10, weight: 100}, {r: 10, g: 10, b: 245, weight: 50}}
+
+	for i, c := range clusters {
+		if c.weight != centres[i].weight || c.distance(centres[i]) > 25 {
+			t.Fatalf("expected cluster %d to be near %v but got %v", i, centres[i], c)
+		}
+	}
+
+	// Asking for more clusters than there are colours should give one cluster for each colour.
+	if clusters := cluster(points[:1], 5); len(clusters) != 1 || clusters[0] != points[0] {
+		t.Fatalf("expected clusters to be %v but got %v", points[:1], clusters)
+	}
+}
+
+func TestPaletteTransparent(t *testing.T) {
+	// Completely transparent images don't have any colours.
+	if p := palette(image.NewRGBA(image.Rect(0, 0, 100, 100))); p != nil {
+		t.Fatalf("expected palette to be empty but got %v", p)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	// Colours are multiplied by their alpha, so this is a half transparent red.
+	copy(img.Pix, []uint8{128, 0, 0, 128})
+
+	if p := palette(img); len(p) != 1 || p[0].Hex != "#ff0000" {
+		t.Fatalf("expected palette to contain #ff0000 but got %v", p)
+	}
+}
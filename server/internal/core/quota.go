@@ -0,0 +1,207 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// measure returns the size of the image with the specified ID, or zero if there isn't one.
+func measure(tx Tx, id string) (int64, error) {
+	img, err := tx.Image(id)
+
+	// Items without an image don't take up any space.
+	if errors.Is(err, moodboard.ErrNoSuchItem) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	size, err := io.Copy(ioutil.Discard, img)
+
+	if closer, ok := img.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure image: %w", err)
+	}
+
+	return size, nil
+}
+
+// usage returns the number of bytes used by the image and thumbnails of an item.
+func (item Item) usage() int64 {
+	return item.Size + item.ThumbnailSize
+}
+
+// measureItem records the sizes of the image and thumbnails of an item, if they weren't recorded when it was stored.
+//
+// The boolean returned by this function indicates whether anything had to be measured.
+func measureItem(tx Tx, item *Item) (bool, error) {
+	measured := false
+
+	// Empty images get measured every time, but there's nothing to read so it doesn't cost anything.
+	if item.Size == 0 {
+		size, err := measure(tx, item.ID)
+
+		if err != nil {
+			return false, err
+		}
+
+		item.Size = size
+		measured = true
+	}
+
+	if item.ThumbnailSize == 0 && len(item.Thumbnails) > 0 {
+		for _, id := range item.ThumbnailIDs() {
+			size, err := measure(tx, id)
+
+			if err != nil {
+				return false, err
+			}
+
+			item.ThumbnailSize += size
+		}
+
+		measured = true
+	}
+
+	return measured, nil
+}
+
+// boardUsage returns the number of bytes used by the images on a board.
+//
+// The usage of each board is kept up to date as images are added and removed. Boards which were stored before that
+// have the sizes of their images added up instead, and if store is true then the result is kept (along with the sizes
+// of any images which had to be measured) so that it only needs to be done once.
+func boardUsage(tx Tx, board Board, store bool) (int64, error) {
+	if board.Usage != nil {
+		return *board.Usage, nil
+	}
+
+	items, err := tx.Items(board.ID)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to read items: %w", err)
+	}
+
+	var n int64
+
+	for _, item := range items {
+		measured, err := measureItem(tx, &item)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if measured && store {
+			if err := tx.PutItem(item); err != nil {
+				return 0, fmt.Errorf("failed to store item: %w", err)
+			}
+		}
+
+		n += item.usage()
+	}
+
+	if store {
+		board.Usage = &n
+
+		if err := tx.PutBoard(board); err != nil {
+			return 0, fmt.Errorf("failed to store board: %w", err)
+		}
+	}
+
+	return n, nil
+}
+
+// usage returns the number of bytes used by the images on the specified board and in the whole store.
+//
+// If store is true then the usage of any boards which had to be added up is stored, so that it doesn't need to be
+// added up again.
+func usage(tx Tx, boardID string, store bool) (int64, int64, error) {
+	boards, err := tx.Boards()
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read boards: %w", err)
+	}
+
+	var board, total int64
+
+	for _, b := range boards {
+		n, err := boardUsage(tx, b, store)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if b.ID == boardID {
+			board = n
+		}
+
+		total += n
+	}
+
+	return board, total, nil
+}
+
+// addUsage adds to the usage kept on a board.
+//
+// Nothing is changed if the usage of the board hasn't been added up yet, as the change will be included when it is.
+func addUsage(tx Tx, boardID string, n int64) error {
+	board, err := tx.Board(boardID)
+
+	if err != nil {
+		return err
+	}
+
+	if board.Usage == nil || n == 0 {
+		return nil
+	}
+
+	usage := *board.Usage + n
+	board.Usage = &usage
+
+	if err := tx.PutBoard(board); err != nil {
+		return fmt.Errorf("failed to store board: %w", err)
+	}
+
+	return nil
+}
+
+// remaining returns the number of bytes which can be added to the specified board without going over the quota.
+//
+// If store is true then the usage of any boards which had to be added up is stored.
+func remaining(tx Tx, boardID string, quota moodboard.Quota, store bool) (int64, error) {
+	// There's no need to work out the usage if there's nothing to compare it against.
+	if quota.Board <= 0 && quota.Total <= 0 {
+		return math.MaxInt64, nil
+	}
+
+	board, total, err := usage(tx, boardID, store)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n := int64(math.MaxInt64)
+
+	if quota.Board > 0 && quota.Board-board < n {
+		n = quota.Board - board
+	}
+
+	if quota.Total > 0 && quota.Total-total < n {
+		n = quota.Total - total
+	}
+
+	// Lowering a quota can leave a board over it.
+	if n < 0 {
+		n = 0
+	}
+
+	return n, nil
+}
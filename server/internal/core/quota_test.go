@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+func TestStoreQuota(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(&testBackend{})
+	s.SetQuota(moodboard.Quota{Board: 10, Total: 15})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	other, err := s.CreateBoard(ctx, "other")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	cs := []struct {
+		board string
+		size  int
+		err   error
+	}{
+		{board: board.ID, size: 6},
+		{board: board.ID, size: 5, err: moodboard.ErrQuotaExceeded},
+		{board: board.ID, size: 4},
+		{board: board.ID, size: 1, err: moodboard.ErrQuotaExceeded},
+		{board: other.ID, size: 6, err: moodboard.ErrQuotaExceeded},
+		{board: other.ID, size: 5},
+		{board: other.ID, size: 0},
+	}
+
+	for i, c := range cs {
+		if _, err := s.Create(ctx, c.board, bytes.NewReader(make([]byte, c.size))); err != c.err {
+			t.Fatalf("expected error for upload %d to be %v but got %v", i, c.err, err)
+		}
+	}
+
+	u, err := s.Usage(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	expected := moodboard.Usage{Board: 10, Total: 15, Quota: moodboard.Quota{Board: 10, Total: 15}}
+
+	if u != expected {
+		t.Fatalf("expected usage to be %+v but got %+v", expected, u)
+	}
+
+	// Uploads which failed shouldn't have left anything behind.
+	all, err := s.All(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 {
+		t.Fatalf("expected to get 2 items but got %d", len(all))
+	}
+}
+
+func TestStoreUsage(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+	s.SetQuota(moodboard.Quota{Total: 1 << 30})
+
+	// Boards from before usage was kept track of should have theirs added up once, and then kept up to date.
+	_ = b.idx.PutBoard(Board{Board: moodboard.Board{ID: "legacy"}})
+	_ = b.idx.PutItem(Item{Item: moodboard.Item{ID: "first", Size: 3}, Board: "legacy", Key: "a"})
+	_ = b.idx.PutItem(Item{Item: moodboard.Item{ID: "second", Size: 4}, Board: "legacy", Key: "b"})
+
+	if _, err := s.Create(ctx, "legacy", bytes.NewReader(make([]byte, 2))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if board, _ := b.idx.Board("legacy"); board.Usage == nil || *board.Usage != 9 {
+		t.Fatalf("expected usage of legacy board to be 9 but got %v", board.Usage)
+	}
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	// Thumbnails count towards the usage as well as the image itself.
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	id, err := s.Create(ctx, board.ID, bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	item, err := b.idx.Item(id)
+
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+
+	if item.ThumbnailSize == 0 {
+		t.Fatalf("expected thumbnails to have been recorded but got %v", item.Thumbnails)
+	}
+
+	u, err := s.Usage(ctx, board.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	if expected := int64(buf.Len()) + item.ThumbnailSize; u.Board != expected || u.Total != expected+9 {
+		t.Fatalf("expected usage to be %d (and %d in total) but got %+v", expected, expected+9, u)
+	}
+
+	// Purging the item should free up everything that it used.
+	if err := s.Delete(ctx, board.ID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if err := s.Purge(ctx, board.ID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	if u, err = s.Usage(ctx, board.ID); err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	if u.Board != 0 || u.Total != 9 {
+		t.Fatalf("expected usage to be 0 (and 9 in total) but got %+v", u)
+	}
+}
@@ -0,0 +1,1451 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/ctxio"
+	"github.com/jackwilsdon/moodboard/internal/limitio"
+)
+
+// Store implements moodboard.Store on top of a Backend.
+type Store struct {
+	backend    Backend
+	quota      moodboard.Quota
+	duplicates moodboard.DuplicatePolicy
+	decodes    moodboard.DecodeLimits
+
+	// decoding has a value in it for each image which is being decoded, and is nil if there's no limit.
+	decoding chan struct{}
+}
+
+// sortedRecords returns all item records on the specified board, including the ones in the trash, ordered by key.
+func sortedRecords(tx Tx, boardID string) ([]Item, error) {
+	records, err := tx.Items(boardID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read items: %w", err)
+	}
+
+	sortItems(records)
+
+	return records, nil
+}
+
+// sortedItems returns all item records on the specified board which aren't in the trash, ordered by key.
+func sortedItems(tx Tx, boardID string) ([]Item, error) {
+	records, err := sortedRecords(tx, boardID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(records))
+
+	for _, record := range records {
+		if record.DeletedAt == nil {
+			items = append(items, record)
+		}
+	}
+
+	return items, nil
+}
+
+// boardRecord returns the item record with the specified ID, making sure that it is on the specified board.
+//
+// The record may be in the trash.
+//
+// This function will return moodboard.ErrNoSuchBoard if the board does not exist, and moodboard.ErrNoSuchItem if the
+// item does not exist on the board.
+func boardRecord(tx Tx, boardID, id string) (Item, error) {
+	if _, err := tx.Board(boardID); err != nil {
+		return Item{}, err
+	}
+
+	item, err := tx.Item(id)
+
+	if err != nil {
+		return Item{}, err
+	}
+
+	// Items on other boards don't exist as far as this board is concerned.
+	if item.Board != boardID {
+		return Item{}, moodboard.ErrNoSuchItem
+	}
+
+	return item, nil
+}
+
+// boardItem returns the item record with the specified ID, making sure that it is on the specified board and isn't in
+// the trash.
+//
+// This function will return moodboard.ErrNoSuchBoard if the board does not exist, and moodboard.ErrNoSuchItem if the
+// item does not exist on the board.
+func boardItem(tx Tx, boardID, id string) (Item, error) {
+	item, err := boardRecord(tx, boardID, id)
+
+	if err != nil {
+		return Item{}, err
+	}
+
+	// Items in the trash can only be restored or purged.
+	if item.DeletedAt != nil {
+		return Item{}, moodboard.ErrNoSuchItem
+	}
+
+	return item, nil
+}
+
+// trashedItem returns the item record with the specified ID, making sure that it is in the trash for the specified
+// board.
+//
+// This function will return moodboard.ErrNoSuchBoard if the board does not exist, and moodboard.ErrNoSuchItem if the
+// item is not in the trash for the board.
+func trashedItem(tx Tx, boardID, id string) (Item, error) {
+	item, err := boardRecord(tx, boardID, id)
+
+	if err != nil {
+		return Item{}, err
+	}
+
+	if item.DeletedAt == nil {
+		return Item{}, moodboard.ErrNoSuchItem
+	}
+
+	return item, nil
+}
+
+// CreateBoard creates a new, empty board with the specified name.
+func (s *Store) CreateBoard(ctx context.Context, name string) (moodboard.Board, error) {
+	board := Board{
+		Board: moodboard.Board{
+			ID:        uuid.New().String(),
+			Name:      name,
+			CreatedAt: time.Now().UTC(),
+		},
+		Usage: new(int64),
+	}
+
+	err := s.backend.Update(ctx, func(tx Tx) error {
+		return tx.PutBoard(board)
+	})
+
+	if err != nil {
+		return moodboard.Board{}, fmt.Errorf("failed to store board: %w", err)
+	}
+
+	return board.Board, nil
+}
+
+// Boards returns all boards in the collection, in the order that they were created.
+func (s *Store) Boards(ctx context.Context) ([]moodboard.Board, error) {
+	var boards []moodboard.Board
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		records, err := tx.Boards()
+
+		if err != nil {
+			return err
+		}
+
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		})
+
+		for _, record := range records {
+			boards = append(boards, record.Board)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read boards: %w", err)
+	}
+
+	return boards, nil
+}
+
+// RenameBoard changes the name of a board in the collection.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) RenameBoard(ctx context.Context, id, name string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		board, err := tx.Board(id)
+
+		if err != nil {
+			return err
+		}
+
+		board.Name = name
+
+		return tx.PutBoard(board)
+	})
+}
+
+// DeleteBoard removes a board and all of its items from the collection.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) DeleteBoard(ctx context.Context, id string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		if _, err := tx.Board(id); err != nil {
+			return err
+		}
+
+		items, err := tx.Items(id)
+
+		if err != nil {
+			return fmt.Errorf("failed to read items: %w", err)
+		}
+
+		// Remove everything on the board before the board itself.
+		for _, item := range items {
+			if err := tx.DeleteItem(item.ID); err != nil {
+				return fmt.Errorf("failed to delete item: %w", err)
+			}
+
+			if err := deleteImages(tx, item); err != nil {
+				return err
+			}
+		}
+
+		return tx.DeleteBoard(id)
+	})
+}
+
+// Create creates a new moodboard item on a board.
+//
+// The image is read into a temporary file and analysed before anything is changed, so that other changes to the store
+// aren't held up by a slow upload or by decoding the image.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrQuotaExceeded if the image would take the board or the store over its quota.
+func (s *Store) Create(ctx context.Context, boardID string, img io.Reader) (string, error) {
+	id := uuid.New().String()
+	now := time.Now().UTC()
+
+	var limit int64
+
+	// Make sure that the board exists before reading anything, and find out how much of the image is worth reading.
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		var err error
+
+		limit, err = remaining(tx, boardID, s.quota, false)
+
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "moodboard-")
+
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	// Stop reading the image if the context is cancelled part of the way through, or if it's too big to fit.
+	r := limitio.NewReader(ctxio.NewReader(ctx, img), limit)
+
+	// Keep hold of the start of the image so that we can read its dimensions, and decode it as it's read so that we can
+	// analyse it.
+	header := &headerWriter{}
+	dec := s.newDecoder(ctx)
+
+	_, err = io.Copy(f, io.TeeReader(r, io.MultiWriter(header, dec)))
+
+	// Images which are cut short or can't be decoded just aren't analysed.
+	decoded, decodeErr := dec.Close()
+
+	if r.Exceeded {
+		return "", moodboard.ErrQuotaExceeded
+	} else if err != nil {
+		return "", fmt.Errorf("failed to save image: %w", err)
+	} else if decodeErr != nil {
+		return "", decodeErr
+	}
+
+	item := Item{
+		Item: moodboard.Item{
+			ID:        id,
+			Size:      r.N,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Board: boardID,
+	}
+
+	// Images which can't be decoded are still stored, they just don't have any dimensions.
+	setDimensions(&item, header.buf)
+
+	var thumbs map[int][]byte
+
+	if decoded == nil {
+		// Backfilling would fail to decode the image in the same way.
+		item.Unanalysable = true
+	} else {
+		item.Hash = hash(decoded)
+		item.Palette = palette(decoded)
+
+		// Thumbnails only save on bandwidth - the original image is served if they're missing, so there's no need to
+		// fail the upload if they can't be made.
+		thumbs, _ = thumbnails(decoded)
+	}
+
+	err = s.backend.Update(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		records, err := sortedRecords(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		// Something else may have been uploaded whilst we were reading the image.
+		limit, err := remaining(tx, boardID, s.quota, true)
+
+		if err != nil {
+			return err
+		}
+
+		if item.Size > limit {
+			return moodboard.ErrQuotaExceeded
+		}
+
+		if decoded != nil && s.duplicates.Reject {
+			if matches := similar(records, item, s.duplicates.Distance); len(matches) > 0 {
+				ids := make([]string, len(matches))
+
+				for i, match := range matches {
+					ids[i] = match.ID
+				}
+
+				return &moodboard.DuplicateError{IDs: ids}
+			}
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read temporary file: %w", err)
+		}
+
+		if err := tx.CreateImage(id, f); err != nil {
+			return fmt.Errorf("failed to save image: %w", err)
+		}
+
+		// Thumbnails don't count towards the quota when checking whether an image fits, as they're much smaller than
+		// the image itself.
+		if _, err := saveThumbnails(tx, &item, thumbs); err != nil {
+			return err
+		}
+
+		// New items go on the end of the board.
+		if err := place(tx, records, len(records), item); err != nil {
+			return err
+		}
+
+		if err := addUsage(tx, boardID, item.usage()); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, Operation{Type: OperationCreate, Item: id})
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// All returns all moodboard items on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) All(ctx context.Context, boardID string) ([]moodboard.Item, error) {
+	var items []moodboard.Item
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		records, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			items = append(items, record.Item)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Page returns a page of moodboard items on a board, in the order that they appear on the board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrInvalidCursor if the cursor is not valid.
+func (s *Store) Page(ctx context.Context, boardID string, opts moodboard.PageOptions) (moodboard.Page, error) {
+	var key, id string
+
+	if opts.Cursor != "" {
+		var err error
+
+		if key, id, err = decodeCursor(opts.Cursor); err != nil {
+			return moodboard.Page{}, err
+		}
+	}
+
+	var page moodboard.Page
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		// Items are given new keys when the board is reordered or rebalanced, so carry on from wherever the item in
+		// the cursor is now. The key in the cursor is only used if the item has since been purged.
+		if opts.Cursor != "" {
+			if record, err := boardRecord(tx, boardID, id); err == nil {
+				key = record.key()
+			} else if !errors.Is(err, moodboard.ErrNoSuchItem) {
+				return err
+			}
+		}
+
+		records, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		// Skip everything up to the place on the board that the cursor refers to.
+		start := 0
+
+		if opts.Cursor != "" {
+			start = sort.Search(len(records), func(i int) bool {
+				return records[i].after(key, id)
+			})
+		}
+
+		var last Item
+
+		for _, record := range records[start:] {
+			if opts.Filter != nil && !opts.Filter(record.Item) {
+				continue
+			}
+
+			// Only hand out a cursor if there's actually another item to return.
+			if opts.Limit > 0 && len(page.Items) == opts.Limit {
+				page.NextCursor = encodeCursor(last)
+
+				break
+			}
+
+			page.Items = append(page.Items, record.Item)
+			last = record
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return moodboard.Page{}, err
+	}
+
+	return page, nil
+}
+
+// Update changes the metadata of a moodboard item on a board, returning the updated item.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) Update(ctx context.Context, boardID, id string, update moodboard.ItemUpdate) (moodboard.Item, error) {
+	var item Item
+
+	err := s.backend.Update(ctx, func(tx Tx) error {
+		var err error
+
+		item, err = boardItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		before := metadataOf(item)
+
+		if update.Title != nil {
+			item.Title = *update.Title
+		}
+
+		if update.Caption != nil {
+			item.Caption = *update.Caption
+		}
+
+		if update.Source != nil {
+			item.Source = *update.Source
+		}
+
+		item.UpdatedAt = time.Now().UTC()
+
+		if err := tx.PutItem(item); err != nil {
+			return err
+		}
+
+		return recordEdit(tx, boardID, before, item)
+	})
+
+	if err != nil {
+		return moodboard.Item{}, err
+	}
+
+	return item.Item, nil
+}
+
+// AddTag attaches a tag to a moodboard item on a board, returning the updated item.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) AddTag(ctx context.Context, boardID, id, tag string) (moodboard.Item, error) {
+	var item Item
+
+	err := s.backend.Update(ctx, func(tx Tx) error {
+		var err error
+
+		item, err = boardItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		// There's nothing to do if the item already has the tag.
+		for _, t := range item.Tags {
+			if t == tag {
+				return nil
+			}
+		}
+
+		// Copy the tags rather than appending in place, as the record may share them with the backend.
+		tags := make([]string, len(item.Tags), len(item.Tags)+1)
+		copy(tags, item.Tags)
+
+		before := metadataOf(item)
+
+		item.Tags = append(tags, tag)
+		item.UpdatedAt = time.Now().UTC()
+
+		if err := tx.PutItem(item); err != nil {
+			return err
+		}
+
+		return recordEdit(tx, boardID, before, item)
+	})
+
+	if err != nil {
+		return moodboard.Item{}, err
+	}
+
+	return item.Item, nil
+}
+
+// RemoveTag removes a tag from a moodboard item on a board, returning the updated item.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) RemoveTag(ctx context.Context, boardID, id, tag string) (moodboard.Item, error) {
+	var item Item
+
+	err := s.backend.Update(ctx, func(tx Tx) error {
+		var err error
+
+		item, err = boardItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		remainingTags := make([]string, 0, len(item.Tags))
+
+		// Only keep tags which do not match the tag provided.
+		for _, t := range item.Tags {
+			if t != tag {
+				remainingTags = append(remainingTags, t)
+			}
+		}
+
+		// If the number of tags is the same then there's nothing to do.
+		if len(item.Tags) == len(remainingTags) {
+			return nil
+		}
+
+		before := metadataOf(item)
+
+		item.Tags = remainingTags
+		item.UpdatedAt = time.Now().UTC()
+
+		if err := tx.PutItem(item); err != nil {
+			return err
+		}
+
+		return recordEdit(tx, boardID, before, item)
+	})
+
+	if err != nil {
+		return moodboard.Item{}, err
+	}
+
+	return item.Item, nil
+}
+
+// GetImage returns the image for the specified moodboard item on a board, which may be in the trash.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) GetImage(ctx context.Context, boardID, id string) (io.Reader, error) {
+	var img io.Reader
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		// Images are still available for items in the trash.
+		if _, err := boardRecord(tx, boardID, id); err != nil {
+			return err
+		}
+
+		var err error
+
+		img, err = tx.Image(id)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// GetThumbnail returns the smallest thumbnail of the specified moodboard item on a board which is at least size pixels
+// along its long edge. The original image is returned if there isn't a thumbnail which is big enough.
+//
+// Items in the trash still have their thumbnails.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) GetThumbnail(ctx context.Context, boardID, id string, size int) (io.Reader, error) {
+	var img io.Reader
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		item, err := boardRecord(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		for _, thumbSize := range item.Thumbnails {
+			if thumbSize < size {
+				continue
+			}
+
+			img, err = tx.Image(thumbnailID(id, thumbSize))
+
+			// Fall back to the original if the thumbnail has gone missing.
+			if !errors.Is(err, moodboard.ErrNoSuchItem) {
+				return err
+			}
+
+			break
+		}
+
+		img, err = tx.Image(id)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// moveOperation returns the operation for moving an item from one index to another amongst the items on a board.
+func moveOperation(items []Item, id string, from, to int) Operation {
+	rest := make([]Item, 0, len(items)-1)
+	rest = append(rest, items[:from]...)
+	rest = append(rest, items[from+1:]...)
+
+	return Operation{Type: OperationMove, Item: id, FromPlace: placeAt(rest, from), ToPlace: placeAt(rest, to)}
+}
+
+// move moves a moodboard item before or after another one on a board.
+func (s *Store) move(ctx context.Context, boardID, id, targetID string, before bool) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		items, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		index := -1
+		target := -1
+
+		// Find the indexes of the items we're moving.
+		for i, item := range items {
+			if item.ID == id {
+				index = i
+			}
+
+			if item.ID == targetID {
+				target = i
+			}
+
+			// We can break early if we've found both indexes.
+			if index != -1 && target != -1 {
+				break
+			}
+		}
+
+		// If either of the indexes is missing, return an error.
+		if index == -1 || target == -1 {
+			return moodboard.ErrNoSuchItem
+		}
+
+		// Work out where the item ends up once it has been taken off the board. If we're moving the item before the
+		// target and it's already before the target then we need to take 1 off the target to ensure we don't insert
+		// it after the target, and vice versa.
+		if index < target && before {
+			target--
+		} else if index > target && !before {
+			target++
+		}
+
+		// Moving an item to where it already is doesn't need to do anything.
+		if index == target {
+			return nil
+		}
+
+		if err := placeItem(tx, boardID, id, target); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, moveOperation(items, id, index, target))
+	})
+}
+
+// MoveBefore moves a moodboard item before another one on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist on the board.
+func (s *Store) MoveBefore(ctx context.Context, boardID, id, beforeID string) error {
+	return s.move(ctx, boardID, id, beforeID, true)
+}
+
+// MoveAfter moves a moodboard item after another one on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist on the board.
+func (s *Store) MoveAfter(ctx context.Context, boardID, id, afterID string) error {
+	return s.move(ctx, boardID, id, afterID, false)
+}
+
+// moveTo moves a moodboard item to an index on a board, or to the end of the board if end is true.
+func (s *Store) moveTo(ctx context.Context, boardID, id string, index int, end bool) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		items, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		current := -1
+
+		for i, item := range items {
+			if item.ID == id {
+				current = i
+				break
+			}
+		}
+
+		if current == -1 {
+			return moodboard.ErrNoSuchItem
+		}
+
+		if end {
+			index = len(items) - 1
+		}
+
+		if index < 0 || index >= len(items) {
+			return fmt.Errorf("%w: %d is not between 0 and %d", moodboard.ErrIndexOutOfRange, index, len(items)-1)
+		}
+
+		// Moving an item to where it already is doesn't need to do anything.
+		if index == current {
+			return nil
+		}
+
+		if err := placeItem(tx, boardID, id, index); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, moveOperation(items, id, current, index))
+	})
+}
+
+// MoveToIndex moves a moodboard item to the specified index on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist,
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board, and an error wrapping
+// moodboard.ErrIndexOutOfRange if the index is not on the board.
+func (s *Store) MoveToIndex(ctx context.Context, boardID, id string, index int) error {
+	return s.moveTo(ctx, boardID, id, index, false)
+}
+
+// MoveToStart moves a moodboard item to the start of a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) MoveToStart(ctx context.Context, boardID, id string) error {
+	return s.moveTo(ctx, boardID, id, 0, false)
+}
+
+// MoveToEnd moves a moodboard item to the end of a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) MoveToEnd(ctx context.Context, boardID, id string) error {
+	return s.moveTo(ctx, boardID, id, 0, true)
+}
+
+// Reorder puts the moodboard items on a board into the specified order.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrOrderMismatch if the IDs are not exactly the items on the board.
+func (s *Store) Reorder(ctx context.Context, boardID string, ids []string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		items, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		// The order needs to contain every item exactly once, otherwise we might lose items which have been added
+		// since the order was put together.
+		if len(ids) != len(items) {
+			return moodboard.ErrOrderMismatch
+		}
+
+		current := make([]string, len(items))
+		remaining := make(map[string]bool, len(items))
+
+		for i, item := range items {
+			current[i] = item.ID
+			remaining[item.ID] = true
+		}
+
+		changed := false
+
+		for i, id := range ids {
+			if !remaining[id] {
+				return moodboard.ErrOrderMismatch
+			}
+
+			delete(remaining, id)
+
+			if id != current[i] {
+				changed = true
+			}
+		}
+
+		// There's nothing to do if the order is the same.
+		if !changed {
+			return nil
+		}
+
+		// Take a copy of the order, as we don't want the history to change if the caller changes the slice.
+		order := make([]string, len(ids))
+		copy(order, ids)
+
+		if err := arrange(tx, boardID, order); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, Operation{Type: OperationBatch, Operations: moveOperations(current, order)})
+	})
+}
+
+// Delete moves a moodboard item on a board to the trash.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) Delete(ctx context.Context, boardID, id string) error {
+	now := time.Now().UTC()
+
+	return s.backend.Update(ctx, func(tx Tx) error {
+		item, err := boardItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		if err := trashItem(tx, item, now); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, Operation{Type: OperationDelete, Item: id})
+	})
+}
+
+// batchItems returns the item records with the specified IDs, making sure that they're all on the specified board and
+// that none of them are in the trash.
+//
+// Any problems with the items are returned, keyed by ID. This function will return moodboard.ErrNoSuchBoard if the
+// board does not exist.
+func batchItems(tx Tx, boardID string, ids []string) ([]Item, map[string]error, error) {
+	if _, err := tx.Board(boardID); err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]Item, 0, len(ids))
+	problems := make(map[string]error)
+	seen := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		if seen[id] {
+			problems[id] = moodboard.ErrDuplicateItem
+
+			continue
+		}
+
+		seen[id] = true
+
+		item, err := boardItem(tx, boardID, id)
+
+		if errors.Is(err, moodboard.ErrNoSuchItem) {
+			problems[id] = moodboard.ErrNoSuchItem
+
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, problems, nil
+}
+
+// DeleteMany moves several moodboard items on a board to the trash.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and a
+// *moodboard.BatchError if any of the IDs do not exist on the board or appear more than once.
+func (s *Store) DeleteMany(ctx context.Context, boardID string, ids []string) error {
+	now := time.Now().UTC()
+
+	return s.backend.Update(ctx, func(tx Tx) error {
+		items, problems, err := batchItems(tx, boardID, ids)
+
+		if err != nil {
+			return err
+		}
+
+		if len(problems) > 0 {
+			return &moodboard.BatchError{Errors: problems}
+		}
+
+		ops := make([]Operation, len(items))
+
+		for i, item := range items {
+			if err := trashItem(tx, item, now); err != nil {
+				return err
+			}
+
+			ops[i] = Operation{Type: OperationDelete, Item: item.ID}
+		}
+
+		return record(tx, boardID, Operation{Type: OperationBatch, Operations: ops})
+	})
+}
+
+// moveMany moves several moodboard items before or after another one on a board.
+func (s *Store) moveMany(ctx context.Context, boardID string, ids []string, targetID string, before bool) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		_, problems, err := batchItems(tx, boardID, ids)
+
+		if err != nil {
+			return err
+		}
+
+		moving := make(map[string]bool, len(ids))
+
+		for _, id := range ids {
+			moving[id] = true
+		}
+
+		// The target needs to stay where it is.
+		if moving[targetID] {
+			problems[targetID] = moodboard.ErrTargetInBatch
+		} else if _, err := boardItem(tx, boardID, targetID); errors.Is(err, moodboard.ErrNoSuchItem) {
+			problems[targetID] = moodboard.ErrNoSuchItem
+		} else if err != nil {
+			return err
+		}
+
+		if len(problems) > 0 {
+			return &moodboard.BatchError{Errors: problems}
+		}
+
+		records, err := sortedRecords(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		var from []string
+
+		// Split the records into the items being moved and everything else, keeping them in board order.
+		items := make([]Item, 0, len(ids))
+		rest := make([]Item, 0, len(records)-len(ids))
+		target := -1
+
+		for _, record := range records {
+			if record.DeletedAt == nil {
+				from = append(from, record.ID)
+			}
+
+			if moving[record.ID] {
+				items = append(items, record)
+
+				continue
+			}
+
+			if record.ID == targetID {
+				target = len(rest)
+			}
+
+			rest = append(rest, record)
+		}
+
+		if !before {
+			target++
+		}
+
+		if err := place(tx, rest, target, items...); err != nil {
+			return err
+		}
+
+		to, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		order := make([]string, len(to))
+
+		for i, item := range to {
+			order[i] = item.ID
+		}
+
+		ops := moveOperations(from, order)
+
+		// Moving items to where they already are doesn't need undoing.
+		if len(ops) == 0 {
+			return nil
+		}
+
+		return record(tx, boardID, Operation{Type: OperationBatch, Operations: ops})
+	})
+}
+
+// MoveManyBefore moves several moodboard items before another one on a board, keeping them in the same order.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and a
+// *moodboard.BatchError if any of the IDs (including the target) do not exist on the board, appear more than once, or
+// if the target is one of the items being moved.
+func (s *Store) MoveManyBefore(ctx context.Context, boardID string, ids []string, beforeID string) error {
+	return s.moveMany(ctx, boardID, ids, beforeID, true)
+}
+
+// MoveManyAfter moves several moodboard items after another one on a board, keeping them in the same order.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and a
+// *moodboard.BatchError if any of the IDs (including the target) do not exist on the board, appear more than once, or
+// if the target is one of the items being moved.
+func (s *Store) MoveManyAfter(ctx context.Context, boardID string, ids []string, afterID string) error {
+	return s.moveMany(ctx, boardID, ids, afterID, false)
+}
+
+// Trash returns all moodboard items in the trash for a board, most recently deleted first.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) Trash(ctx context.Context, boardID string) ([]moodboard.TrashedItem, error) {
+	var items []moodboard.TrashedItem
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		records, err := tx.Items(boardID)
+
+		if err != nil {
+			return fmt.Errorf("failed to read items: %w", err)
+		}
+
+		for _, record := range records {
+			if record.DeletedAt != nil {
+				items = append(items, moodboard.TrashedItem{Item: record.Item, DeletedAt: *record.DeletedAt})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+
+	return items, nil
+}
+
+// Restore moves a moodboard item out of the trash, putting it back where it was on the board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID is not in the trash for the board.
+func (s *Store) Restore(ctx context.Context, boardID, id string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		item, err := trashedItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		if err := restoreItem(tx, item); err != nil {
+			return err
+		}
+
+		return record(tx, boardID, Operation{Type: OperationRestore, Item: id})
+	})
+}
+
+// Purge permanently removes a moodboard item from the trash.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID is not in the trash for the board.
+func (s *Store) Purge(ctx context.Context, boardID, id string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		item, err := trashedItem(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.DeleteItem(id); err != nil {
+			return fmt.Errorf("failed to delete item: %w", err)
+		}
+
+		if err := deleteImages(tx, item); err != nil {
+			return err
+		}
+
+		if err := addUsage(tx, boardID, -item.usage()); err != nil {
+			return err
+		}
+
+		// The item is gone for good, so there's no way to undo anything involving it.
+		return forget(tx, boardID, id)
+	})
+}
+
+// Undo reverses the most recent operation on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNothingToUndo if there are no operations to undo.
+func (s *Store) Undo(ctx context.Context, boardID string) error {
+	now := time.Now().UTC()
+
+	return s.backend.Update(ctx, func(tx Tx) error {
+		board, err := tx.Board(boardID)
+
+		if err != nil {
+			return err
+		}
+
+		if len(board.History) == 0 {
+			return moodboard.ErrNothingToUndo
+		}
+
+		op := board.History[len(board.History)-1]
+
+		if err := apply(tx, boardID, op, true, now); err != nil {
+			return fmt.Errorf("failed to undo %s: %w", op.Type, err)
+		}
+
+		// Applying the operation may have changed the board, so we need to fetch it again.
+		if board, err = tx.Board(boardID); err != nil {
+			return err
+		}
+
+		// Copy the stacks rather than appending in place, as the record may share them with the backend.
+		undone := make([]Operation, len(board.Undone), len(board.Undone)+1)
+		copy(undone, board.Undone)
+
+		board.History = board.History[:len(board.History)-1]
+		board.Undone = append(undone, op)
+
+		return tx.PutBoard(board)
+	})
+}
+
+// Redo repeats the most recently undone operation on a board.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNothingToRedo if there are no operations to redo.
+func (s *Store) Redo(ctx context.Context, boardID string) error {
+	now := time.Now().UTC()
+
+	return s.backend.Update(ctx, func(tx Tx) error {
+		board, err := tx.Board(boardID)
+
+		if err != nil {
+			return err
+		}
+
+		if len(board.Undone) == 0 {
+			return moodboard.ErrNothingToRedo
+		}
+
+		op := board.Undone[len(board.Undone)-1]
+
+		if err := apply(tx, boardID, op, false, now); err != nil {
+			return fmt.Errorf("failed to redo %s: %w", op.Type, err)
+		}
+
+		// Applying the operation may have changed the board, so we need to fetch it again.
+		if board, err = tx.Board(boardID); err != nil {
+			return err
+		}
+
+		// Copy the stacks rather than appending in place, as the record may share them with the backend.
+		history := make([]Operation, len(board.History), len(board.History)+1)
+		copy(history, board.History)
+
+		board.History = append(history, op)
+		board.Undone = board.Undone[:len(board.Undone)-1]
+
+		return tx.PutBoard(board)
+	})
+}
+
+// Usage returns the number of bytes of images stored on a board and in the whole store, along with the quota that they
+// count against.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) Usage(ctx context.Context, boardID string) (moodboard.Usage, error) {
+	u := moodboard.Usage{Quota: s.quota}
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		var err error
+
+		u.Board, u.Total, err = usage(tx, boardID, false)
+
+		return err
+	})
+
+	if err != nil {
+		return moodboard.Usage{}, err
+	}
+
+	return u, nil
+}
+
+// Palette returns the dominant colours across all of the moodboard items on a board, most dominant first.
+//
+// Each item counts equally, regardless of the size of its image. Items in the trash are ignored.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) Palette(ctx context.Context, boardID string) ([]moodboard.Colour, error) {
+	var points []colourPoint
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		// Keep the items in order, so that the same board always gives the same palette.
+		items, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			for _, colour := range item.Palette {
+				if p, ok := parseColour(colour); ok {
+					points = append(points, p)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return colours(cluster(points, boardPaletteSize)), nil
+}
+
+// Similar returns the moodboard items on a board whose images are near-duplicates of the image of the specified item,
+// most similar first. Items in the trash are ignored.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist, and
+// moodboard.ErrNoSuchItem if an item with the specified ID does not exist on the board.
+func (s *Store) Similar(ctx context.Context, boardID, id string) ([]moodboard.Item, error) {
+	var items []moodboard.Item
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		item, err := boardRecord(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		records, err := sortedRecords(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		for _, match := range similar(records, item, s.duplicates.Distance) {
+			items = append(items, match.Item)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Duplicates returns groups of moodboard items on a board whose images are near-duplicates of each other, in the order
+// that they appear on the board. Items which aren't near-duplicates of any other items are left out, as are items in
+// the trash.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (s *Store) Duplicates(ctx context.Context, boardID string) ([][]moodboard.Item, error) {
+	var groups [][]moodboard.Item
+
+	err := s.backend.View(ctx, func(tx Tx) error {
+		if _, err := tx.Board(boardID); err != nil {
+			return err
+		}
+
+		items, err := sortedItems(tx, boardID)
+
+		if err != nil {
+			return err
+		}
+
+		groups = duplicates(items, s.duplicates.Distance)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// SetQuota changes the number of bytes of images which can be stored.
+//
+// This method must not be called whilst the store is in use.
+func (s *Store) SetQuota(quota moodboard.Quota) {
+	s.quota = quota
+}
+
+// SetDuplicatePolicy changes how near-duplicate images are found, and whether they can be stored.
+//
+// This method must not be called whilst the store is in use.
+func (s *Store) SetDuplicatePolicy(policy moodboard.DuplicatePolicy) {
+	s.duplicates = policy
+}
+
+// SetDecodeLimits changes how big an image can be for it to be analysed, and how many images can be decoded at once.
+//
+// This method must not be called whilst the store is in use.
+func (s *Store) SetDecodeLimits(limits moodboard.DecodeLimits) {
+	s.decodes = limits
+	s.decoding = nil
+
+	if limits.Concurrent > 0 {
+		s.decoding = make(chan struct{}, limits.Concurrent)
+	}
+}
+
+// NewStore creates a new moodboard collection, backed by the specified backend.
+func NewStore(b Backend) *Store {
+	s := &Store{
+		backend:    b,
+		duplicates: moodboard.DuplicatePolicy{Distance: moodboard.DefaultDuplicateDistance},
+	}
+
+	s.SetDecodeLimits(moodboard.DecodeLimits{
+		MaxPixels:  moodboard.DefaultMaxDecodePixels,
+		Concurrent: moodboard.DefaultConcurrentDecodes,
+	})
+
+	return s
+}
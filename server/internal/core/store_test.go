@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+// wrappingTx is a transaction which wraps the errors returned when looking up items, as backends are allowed to.
+type wrappingTx struct {
+	testTx
+}
+
+func (t wrappingTx) Item(id string) (Item, error) {
+	item, err := t.testTx.Item(id)
+
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	return item, nil
+}
+
+// wrappingBackend is a testBackend which wraps the errors returned when looking up items.
+type wrappingBackend struct {
+	testBackend
+}
+
+func (b *wrappingBackend) View(_ context.Context, fn func(Tx) error) error {
+	return fn(wrappingTx{testTx{Index: &b.idx, backend: &b.testBackend}})
+}
+
+func (b *wrappingBackend) Update(_ context.Context, fn func(Tx) error) error {
+	return fn(wrappingTx{testTx{Index: &b.idx, backend: &b.testBackend}})
+}
+
+func TestStoreBatchWrappedErrors(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(&wrappingBackend{})
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	id, err := s.Create(ctx, board.ID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	// Missing items should still be reported as part of the batch, rather than failing the whole thing.
+	ops := []struct {
+		name string
+		fn   func() error
+	}{
+		{name: "DeleteMany", fn: func() error { return s.DeleteMany(ctx, board.ID, []string{id, "missing"}) }},
+		{name: "MoveManyBefore", fn: func() error { return s.MoveManyBefore(ctx, board.ID, []string{id}, "missing") }},
+	}
+
+	for _, op := range ops {
+		var batchErr *moodboard.BatchError
+
+		if err := op.fn(); !errors.As(err, &batchErr) {
+			t.Fatalf("expected %s error to be a batch error but got %q", op.name, err)
+		}
+
+		if len(batchErr.Errors) != 1 || batchErr.Errors["missing"] != moodboard.ErrNoSuchItem {
+			t.Fatalf("expected %s errors to be %q for %q but got %v", op.name, moodboard.ErrNoSuchItem, "missing",
+				batchErr.Errors)
+		}
+	}
+}
+
+// updateTrackingBackend is a testBackend which keeps track of whether an update is running.
+type updateTrackingBackend struct {
+	testBackend
+	updating bool
+}
+
+func (b *updateTrackingBackend) Update(ctx context.Context, fn func(Tx) error) error {
+	b.updating = true
+
+	defer func() {
+		b.updating = false
+	}()
+
+	return b.testBackend.Update(ctx, fn)
+}
+
+// outsideUpdateReader is an io.Reader which fails the test if it's read from whilst an update is running.
+type outsideUpdateReader struct {
+	t       *testing.T
+	r       io.Reader
+	backend *updateTrackingBackend
+}
+
+func (r outsideUpdateReader) Read(p []byte) (int, error) {
+	if r.backend.updating {
+		r.t.Errorf("expected image not to be read during an update")
+	}
+
+	return r.r.Read(p)
+}
+
+func TestStoreCreateOutsideUpdate(t *testing.T) {
+	ctx := context.Background()
+	b := &updateTrackingBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// The upload should be read and analysed before the transaction starts, so that it doesn't hold anything up.
+	id, err := s.Create(ctx, board.ID, outsideUpdateReader{t: t, r: &buf, backend: b})
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	item, err := b.idx.Item(id)
+
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+
+	// Everything should have been stored in the same transaction as the item itself.
+	if item.Width != 512 || item.Hash == "" || len(item.Thumbnails) == 0 {
+		t.Fatalf("expected item to have been analysed but got %+v", item)
+	}
+
+	if b.puts != 1 {
+		t.Fatalf("expected item to have been stored once but got %d", b.puts)
+	}
+}
+
+func TestStoreCreateDamaged(t *testing.T) {
+	ctx := context.Background()
+	b := &testBackend{}
+	s := NewStore(b)
+
+	board, err := s.CreateBoard(ctx, "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// The header is still intact, but the image itself is cut short.
+	id, err := s.Create(ctx, board.ID, bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	item, err := b.idx.Item(id)
+
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+
+	if item.Width != 512 || item.Hash != "" || !item.Unanalysable {
+		t.Fatalf("expected item to have been marked as unanalysable but got %+v", item)
+	}
+}
@@ -0,0 +1,386 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/jackwilsdon/moodboard/internal/metadata"
+
+	// Register the formats that we can make thumbnails of.
+	_ "image/gif"
+)
+
+// thumbnailSizes contains the sizes of the thumbnails generated for each image, in ascending order.
+//
+// Sizes are the length of the long edge of the thumbnail in pixels.
+var thumbnailSizes = []int{256, 512, 1024}
+
+// thumbnailQuality is the quality used when encoding thumbnails as JPEGs.
+const thumbnailQuality = 85
+
+// thumbnailID returns the image ID used for the thumbnail of an item.
+//
+// Item IDs are UUIDs, so the thumbnails of one item can't have the same ID as another item.
+func thumbnailID(id string, size int) string {
+	return id + "." + strconv.Itoa(size)
+}
+
+// ThumbnailIDs returns the image IDs of all of the thumbnails of an item.
+func (item Item) ThumbnailIDs() []string {
+	ids := make([]string, len(item.Thumbnails))
+
+	for i, size := range item.Thumbnails {
+		ids[i] = thumbnailID(item.ID, size)
+	}
+
+	return ids
+}
+
+// deleteImages removes the image for an item along with all of its thumbnails.
+func deleteImages(tx Tx, item Item) error {
+	for _, id := range append([]string{item.ID}, item.ThumbnailIDs()...) {
+		if err := tx.DeleteImage(id); err != nil {
+			return fmt.Errorf("failed to delete image: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// scale returns a copy of an image which has been shrunk so that its long edge is size pixels long.
+func scale(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := size, size
+
+	// Keep the aspect ratio, without letting the short edge disappear completely.
+	if sw >= sh {
+		dh = sh * size / sw
+	} else {
+		dw = sw * size / sh
+	}
+
+	if dw < 1 {
+		dw = 1
+	}
+
+	if dh < 1 {
+		dh = 1
+	}
+
+	return resize(src, dw, dh)
+}
+
+// resize returns a copy of an image which has been resized to the specified width and height.
+//
+// Each pixel in the copy is the average of the pixels that it covers in the original, which keeps fine detail from
+// turning into noise when shrinking. Pixels are repeated when growing.
+func resize(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+
+		// Every pixel in the copy needs to cover at least one pixel in the original.
+		if y1 == y0 {
+			y1++
+		}
+
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n int
+
+			for y := y0; y < y1; y++ {
+				i := src.PixOffset(src.Rect.Min.X+x0, src.Rect.Min.Y+y)
+
+				for x := x0; x < x1; x++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// opaque returns whether every pixel in an image is fully opaque.
+func opaque(img *image.RGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+
+	return true
+}
+
+// encodeThumbnail encodes a thumbnail, using JPEG unless it has transparent pixels.
+func encodeThumbnail(img *image.RGBA) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if opaque(img) {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, err
+		}
+	} else if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// errorReader keeps track of the last error returned by a reader, so that read errors can be told apart from errors in
+// the image itself.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// decode decodes an image into a copy in a known format, so that scaling and analysing it doesn't need to care about
+// colour models. The copy is turned the right way round if the image has an EXIF orientation.
+//
+// Images which can't be decoded (including images which are cut short), or which have more pixels than the store's
+// decode limits allow, are returned as nil. An error is only returned if the image couldn't be read, or if the context
+// was done whilst waiting for other images to finish decoding.
+func (s *Store) decode(ctx context.Context, r io.Reader) (*image.RGBA, error) {
+	er := &errorReader{r: r}
+
+	// Keep hold of the start of the image, which has its orientation in it as well as its size.
+	header, _ := ioutil.ReadAll(io.LimitReader(er, maxHeaderSize))
+
+	if er.err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", er.err)
+	}
+
+	// Check how big the image is before decoding all of it.
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+
+	if err != nil || s.decodes.MaxPixels > 0 && config.Width*config.Height > s.decodes.MaxPixels {
+		return nil, nil
+	}
+
+	// Most of the memory is used by the decoded image, so only that part is limited.
+	if s.decoding != nil {
+		select {
+		case s.decoding <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		defer func() {
+			<-s.decoding
+		}()
+	}
+
+	src, _, err := image.Decode(io.MultiReader(bytes.NewReader(header), er))
+
+	if er.err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", er.err)
+	} else if err != nil {
+		return nil, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Rect, src, src.Bounds().Min, draw.Src)
+
+	return metadata.Orient(img, metadata.Orientation(header)), nil
+}
+
+// thumbnails generates thumbnails of an image, keyed by size.
+//
+// Thumbnails are only generated for sizes which are smaller than the image itself.
+func thumbnails(img *image.RGBA) (map[int][]byte, error) {
+	long := img.Rect.Dx()
+
+	if img.Rect.Dy() > long {
+		long = img.Rect.Dy()
+	}
+
+	thumbs := make(map[int][]byte, len(thumbnailSizes))
+
+	// Scale down from the largest thumbnail to the smallest, making each one from the last to save some work.
+	for i := len(thumbnailSizes) - 1; i >= 0; i-- {
+		size := thumbnailSizes[i]
+
+		if size >= long {
+			continue
+		}
+
+		img = scale(img, size)
+		long = size
+
+		var err error
+
+		if thumbs[size], err = encodeThumbnail(img); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+	}
+
+	return thumbs, nil
+}
+
+// analyse generates and stores the thumbnails, palette and hash of an item.
+//
+// The image is decoded and analysed outside of any transaction, so that other changes to the store aren't held up. The
+// boolean returned by this function indicates whether the image could be decoded.
+func (s *Store) analyse(ctx context.Context, boardID, id string) (bool, error) {
+	r, err := s.GetImage(ctx, boardID, id)
+
+	if err != nil {
+		return false, err
+	}
+
+	img, err := s.decode(ctx, r)
+
+	if closer, ok := r.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if img == nil {
+		return false, s.markUnanalysable(ctx, boardID, id)
+	}
+
+	if err := s.saveAnalysis(ctx, boardID, id, img); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// markUnanalysable records that the image of an item can't be decoded.
+func (s *Store) markUnanalysable(ctx context.Context, boardID, id string) error {
+	return s.backend.Update(ctx, func(tx Tx) error {
+		item, err := boardRecord(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		item.Unanalysable = true
+
+		return tx.PutItem(item)
+	})
+}
+
+// hasThumbnail returns whether a thumbnail of the specified size has been stored for an item.
+func (item Item) hasThumbnail(size int) bool {
+	for _, s := range item.Thumbnails {
+		if s == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+// saveThumbnails stores the thumbnails of an item which haven't already been stored, returning the number of bytes
+// that they use.
+func saveThumbnails(tx Tx, item *Item, thumbs map[int][]byte) (int64, error) {
+	var added int64
+
+	for _, size := range thumbnailSizes {
+		thumb, ok := thumbs[size]
+
+		if !ok || item.hasThumbnail(size) {
+			continue
+		}
+
+		if err := tx.CreateImage(thumbnailID(item.ID, size), bytes.NewReader(thumb)); err != nil {
+			return 0, fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+
+		item.Thumbnails = append(item.Thumbnails, size)
+		added += int64(len(thumb))
+	}
+
+	sort.Ints(item.Thumbnails)
+	item.ThumbnailSize += added
+
+	return added, nil
+}
+
+// saveAnalysis generates and stores the thumbnails, palette and hash of an item from its decoded image.
+//
+// Only what's missing from the item is stored, as the item may have been partly analysed already (such as by an
+// earlier version which didn't record everything). Thumbnails which already exist are kept.
+func (s *Store) saveAnalysis(ctx context.Context, boardID, id string, img *image.RGBA) error {
+	thumbs, err := thumbnails(img)
+
+	if err != nil {
+		return err
+	}
+
+	colours := palette(img)
+	h := hash(img)
+
+	return s.backend.Update(ctx, func(tx Tx) error {
+		// The item may have been purged whilst we were busy.
+		item, err := boardRecord(tx, boardID, id)
+
+		if err != nil {
+			return err
+		}
+
+		added, err := saveThumbnails(tx, &item, thumbs)
+
+		if err != nil {
+			return err
+		}
+
+		if item.Palette == nil {
+			item.Palette = colours
+		}
+
+		if item.Hash == "" {
+			item.Hash = h
+		}
+
+		if err := tx.PutItem(item); err != nil {
+			return err
+		}
+
+		// Thumbnails count towards the quota, but they're made after the image has been checked against it. They're
+		// much smaller than the image, so they're allowed to go over it.
+		return addUsage(tx, boardID, added)
+	})
+}
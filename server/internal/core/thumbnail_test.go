@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/jackwilsdon/moodboard"
+)
+
+func TestScale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+
+	// The left half is black and the right half alternates between white and transparent.
+	copy(src.Pix, []uint8{
+		0, 0, 0, 255, 0, 0, 0, 255, 255, 255, 255, 255, 0, 0, 0, 0,
+		0, 0, 0, 255, 0, 0, 0, 255, 0, 0, 0, 0, 255, 255, 255, 255,
+	})
+
+	dst := scale(src, 2)
+
+	if dst.Rect != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("expected scaled image to be %v but got %v", image.Rect(0, 0, 2, 1), dst.Rect)
+	}
+
+	if expected := []uint8{0, 0, 0, 255, 127, 127, 127, 127}; !reflect.DeepEqual(dst.Pix, expected) {
+		t.Fatalf("expected pixels to be %v but got %v", expected, dst.Pix)
+	}
+
+	// The short edge should never disappear completely.
+	if dst := scale(image.NewRGBA(image.Rect(0, 0, 1000, 1)), 10); dst.Rect != image.Rect(0, 0, 10, 1) {
+		t.Fatalf("expected scaled image to be %v but got %v", image.Rect(0, 0, 10, 1), dst.Rect)
+	}
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 600, 300))
+
+	// The left half is red and the right half is blue.
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			if x < 300 {
+				src.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				src.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// Add an EXIF segment straight after the start of the image, which says that the image needs to be turned a
+	// quarter turn clockwise.
+	exif := []byte("Exif\x00\x00" +
+		"MM\x00\x2a\x00\x00\x00\x08" + // A big-endian TIFF header, with the first directory straight after it.
+		"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" + // A single entry with an orientation of 6.
+		"\x00\x00\x00\x00") // No more directories.
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	img := append(append(append([]byte{}, buf.Bytes()[:2]...), segment...), buf.Bytes()[2:]...)
+
+	decoded, err := NewStore(&testBackend{}).decode(context.Background(), bytes.NewReader(img))
+
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+
+	if decoded == nil || decoded.Rect != image.Rect(0, 0, 300, 600) {
+		t.Fatalf("expected decoded image to be %v but got %v", image.Rect(0, 0, 300, 600), decoded)
+	}
+
+	// The left of the image should now be at the top.
+	if top, bottom := decoded.RGBAAt(150, 100), decoded.RGBAAt(150, 500); top.R < 200 || bottom.B < 200 {
+		t.Fatalf("expected image to be red at the top and blue at the bottom but got %v and %v", top, bottom)
+	}
+
+	thumbs, err := thumbnails(decoded)
+
+	if err != nil {
+		t.Fatalf("failed to generate thumbnails: %v", err)
+	}
+
+	thumb, _, err := image.DecodeConfig(bytes.NewReader(thumbs[256]))
+
+	if err != nil {
+		t.Fatalf("failed to decode thumbnail: %v", err)
+	}
+
+	if thumb.Width != 128 || thumb.Height != 256 {
+		t.Fatalf("expected thumbnail to be 128x256 but got %dx%d", thumb.Width, thumb.Height)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	ctx := context.Background()
+	s := NewStore(&testBackend{})
+
+	// Images with too many pixels shouldn't be decoded at all.
+	s.SetDecodeLimits(moodboard.DecodeLimits{MaxPixels: 199})
+
+	if img, err := s.decode(ctx, bytes.NewReader(buf.Bytes())); img != nil || err != nil {
+		t.Fatalf("expected result to be [nil, nil] but got [%v, %q]", img, err)
+	}
+
+	s.SetDecodeLimits(moodboard.DecodeLimits{MaxPixels: 200, Concurrent: 1})
+
+	// Take the only slot, so that the image has to wait for it.
+	s.decoding <- struct{}{}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if img, err := s.decode(cancelled, bytes.NewReader(buf.Bytes())); img != nil || err != context.Canceled {
+		t.Fatalf("expected result to be [nil, %q] but got [%v, %q]", context.Canceled, img, err)
+	}
+
+	<-s.decoding
+
+	if img, err := s.decode(ctx, bytes.NewReader(buf.Bytes())); img == nil || err != nil {
+		t.Fatalf("expected image to be decoded but got [%v, %q]", img, err)
+	}
+
+	// The slot should have been given back once the image was decoded.
+	if len(s.decoding) != 0 {
+		t.Fatalf("expected no images to be decoding but got %d", len(s.decoding))
+	}
+}
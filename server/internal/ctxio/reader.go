@@ -0,0 +1,29 @@
+// Package ctxio provides context-aware I/O helpers.
+package ctxio
+
+import (
+	"context"
+	"io"
+)
+
+// reader is an io.Reader which stops reading once a context is done.
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the underlying reader, unless the context is done.
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// NewReader returns a reader which reads from r until ctx is done, at which point it returns the context's error.
+//
+// Note that a read which is already in progress when the context is done will not be interrupted.
+func NewReader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r}
+}
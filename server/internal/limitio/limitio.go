@@ -0,0 +1,58 @@
+// Package limitio provides I/O helpers which limit how much data can be read.
+package limitio
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrLimitExceeded is returned by Reader once more data is available than its limit allows.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Reader is an io.Reader which fails once more than a certain number of bytes are available from the underlying
+// reader.
+type Reader struct {
+	r         io.Reader
+	remaining int64
+
+	// N is the number of bytes which have been read.
+	N int64
+
+	// Exceeded is set once the underlying reader has been found to have more data than the limit allows.
+	Exceeded bool
+}
+
+// Read reads from the underlying reader, returning ErrLimitExceeded once it goes past the limit.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.Exceeded {
+		return 0, ErrLimitExceeded
+	}
+
+	// Read at most one byte past the limit, which is enough to tell whether there's too much data.
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.r.Read(p)
+
+	// Only hand back the data up to the limit.
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+
+		r.N += r.remaining
+		r.remaining = 0
+		r.Exceeded = true
+
+		return n, ErrLimitExceeded
+	}
+
+	r.N += int64(n)
+	r.remaining -= int64(n)
+
+	return n, err
+}
+
+// NewReader returns a reader which reads from r, failing with ErrLimitExceeded if r has more than limit bytes.
+func NewReader(r io.Reader, limit int64) *Reader {
+	return &Reader{r: r, remaining: limit}
+}
@@ -0,0 +1,815 @@
+// Package metadata reads and removes metadata from images, such as the location that a photo was taken at or the way
+// up that it should be displayed.
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"github.com/jackwilsdon/moodboard/internal/sniff"
+)
+
+// ErrMalformed is returned by Strip when an image is too badly formed for its metadata to be found.
+var ErrMalformed = errors.New("malformed image")
+
+// ErrUnsupported is returned by Strip when an image is in a format which can contain metadata that can't be removed.
+var ErrUnsupported = errors.New("unsupported image")
+
+// maxHeaderSize is the largest number of bytes which can come before the image data.
+//
+// Everything before the image data is kept in memory whilst looking for metadata.
+const maxHeaderSize = 16 << 20
+
+// maxPixels is the largest number of pixels an image can have for it to be decoded and turned the right way round.
+//
+// Decoding an image needs a few bytes per pixel, so this stops a small file with a huge size in its header from using
+// up all of our memory.
+const maxPixels = 40000000
+
+// jpegQuality is the quality used when re-encoding JPEGs.
+const jpegQuality = 95
+
+// JPEG markers which we need to know about.
+const (
+	markerAPP1  = 0xe1 // EXIF and XMP.
+	markerAPP2  = 0xe2 // ICC colour profiles.
+	markerAPP13 = 0xed // IPTC.
+	markerSOS   = 0xda // The start of the image data.
+	markerEOI   = 0xd9 // The end of the image.
+)
+
+var (
+	jpegStart    = []byte{0xff, 0xd8}
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+)
+
+// pngMetadata contains the types of the PNG chunks which are removed.
+//
+// XMP and IPTC metadata are kept in text chunks, so all text is removed along with the EXIF chunk.
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+}
+
+// webpMetadata contains the types of the WebP chunks which are removed.
+var webpMetadata = map[string]bool{
+	"EXIF": true,
+	"XMP ": true,
+}
+
+// webpMetadataFlags contains the flags in the extended format chunk of a WebP which say that it has EXIF and XMP
+// metadata.
+const webpMetadataFlags = 0x08 | 0x04
+
+// pngColour contains the types of the PNG chunks which describe the colours of an image, which are kept when a PNG is
+// re-encoded.
+var pngColour = map[string]bool{
+	"cHRM": true,
+	"gAMA": true,
+	"iCCP": true,
+	"sRGB": true,
+}
+
+// malformed converts running out of data into ErrMalformed, as it means that the image was cut short.
+func malformed(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrMalformed
+	}
+
+	return err
+}
+
+// exifOrientation reads the orientation from EXIF metadata, which is stored in TIFF format.
+//
+// The boolean returned by this function is false if the metadata doesn't contain a valid orientation.
+func exifOrientation(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0, false
+	}
+
+	// The orientation is kept in the first directory, which is made up of 12 byte entries.
+	offset := int64(order.Uint32(tiff[4:]))
+
+	if offset+2 > int64(len(tiff)) {
+		return 0, false
+	}
+
+	count := int64(order.Uint16(tiff[offset:]))
+
+	for i := int64(0); i < count; i++ {
+		entry := offset + 2 + i*12
+
+		if entry+12 > int64(len(tiff)) {
+			return 0, false
+		}
+
+		// The orientation has a tag of 0x0112 and is a short, which is kept at the start of the value.
+		if order.Uint16(tiff[entry:]) != 0x0112 || order.Uint16(tiff[entry+2:]) != 3 {
+			continue
+		}
+
+		if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+			return orientation, true
+		}
+
+		return 0, false
+	}
+
+	return 0, false
+}
+
+// orientationEXIF returns EXIF metadata (in TIFF format) which only contains the specified orientation.
+func orientationEXIF(orientation int) []byte {
+	// This is a big-endian header followed by a directory with a single entry, and no directory after it.
+	return []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, 0, 0, 0, 0}
+}
+
+// jpegOrientation reads the orientation from the marker segments of a JPEG, which start straight after the start of
+// the image.
+//
+// Segments are read up until the image data, or until they're cut short.
+func jpegOrientation(b []byte) int {
+	orientation := 1
+
+	for len(b) >= 2 && b[0] == 0xff {
+		marker := b[1]
+
+		// Markers can be padded with any number of 0xff bytes, and some markers don't have any data after them.
+		if marker == 0xff {
+			b = b[1:]
+
+			continue
+		} else if marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 {
+			b = b[2:]
+
+			continue
+		} else if marker == markerSOS || marker == markerEOI || len(b) < 4 {
+			break
+		}
+
+		// The length includes itself.
+		length := int(binary.BigEndian.Uint16(b[2:]))
+
+		if length < 2 || 2+length > len(b) {
+			break
+		}
+
+		if data := b[4 : 2+length]; marker == markerAPP1 && bytes.HasPrefix(data, exifHeader) {
+			if o, ok := exifOrientation(data[len(exifHeader):]); ok {
+				orientation = o
+			}
+		}
+
+		b = b[2+length:]
+	}
+
+	return orientation
+}
+
+// pngOrientation reads the orientation from the chunks of a PNG, which start straight after the signature.
+//
+// Chunks are read up until the image data, or until they're cut short.
+func pngOrientation(b []byte) int {
+	orientation := 1
+
+	for len(b) >= 8 {
+		if typ := string(b[4:8]); typ == "IDAT" || typ == "IEND" {
+			break
+		}
+
+		// Chunks end with a 4 byte checksum.
+		size := int64(binary.BigEndian.Uint32(b))
+
+		if 8+size+4 > int64(len(b)) {
+			break
+		}
+
+		// Some encoders put the same header in front of the EXIF data as JPEGs do.
+		if string(b[4:8]) == "eXIf" {
+			if o, ok := exifOrientation(bytes.TrimPrefix(b[8:8+size], exifHeader)); ok {
+				orientation = o
+			}
+		}
+
+		b = b[8+size+4:]
+	}
+
+	return orientation
+}
+
+// Orientation returns the EXIF orientation of a JPEG or PNG, given the start of it.
+//
+// The orientation is 1 (the right way round) if the image is in any other format, doesn't have an orientation or
+// doesn't have it before the image data.
+func Orientation(header []byte) int {
+	switch {
+	case bytes.HasPrefix(header, jpegStart):
+		return jpegOrientation(header[len(jpegStart):])
+	case bytes.HasPrefix(header, pngSignature):
+		return pngOrientation(header[len(pngSignature):])
+	default:
+		return 1
+	}
+}
+
+// Orient turns an image the right way round, based on its EXIF orientation.
+//
+// Images which are already the right way round (or which have an invalid orientation) are returned unchanged.
+func Orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+
+	// Orientations 5 to 8 are rotated by a quarter turn, which swaps the width and height.
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			i, j := dst.PixOffset(x, y), src.PixOffset(sx, sy)
+			copy(dst.Pix[i:i+4], src.Pix[j:j+4])
+		}
+	}
+
+	return dst
+}
+
+// reorient decodes an image, turns it the right way round and re-encodes it using the specified encoder.
+//
+// The header contains everything which has been read from the image so far, and r contains the rest of it. The reader
+// returned by this function is nil if the image is too big to decode safely, in which case nothing more has been read
+// and the orientation should be kept instead.
+func reorient(
+	header []byte,
+	r io.Reader,
+	orientation int,
+	encode func(io.Writer, image.Image) error,
+) (io.Reader, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if config.Width*config.Height > maxPixels {
+		return nil, nil
+	}
+
+	src, _, err := image.Decode(io.MultiReader(bytes.NewReader(header), r))
+
+	if err != nil {
+		return nil, malformed(err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Rect, src, src.Bounds().Min, draw.Src)
+
+	var buf bytes.Buffer
+
+	if err := encode(&buf, Orient(img, orientation)); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return &buf, nil
+}
+
+// stripJPEG removes the metadata from a JPEG.
+//
+// Metadata is kept in marker segments before the image data, so only those need to be looked at. The image data is
+// passed through untouched unless the image needs to be turned the right way round.
+func stripJPEG(r io.Reader) (io.Reader, error) {
+	// raw contains everything we've read, in case the image needs to be decoded.
+	var raw, out bytes.Buffer
+
+	hr := io.TeeReader(io.LimitReader(r, maxHeaderSize), &raw)
+
+	// icc contains the colour profile segments, which need to be added back if the image is re-encoded.
+	var icc [][]byte
+
+	orientation := 1
+	buf := make([]byte, 4)
+
+	if _, err := io.ReadFull(hr, buf[:2]); err != nil {
+		return nil, malformed(err)
+	}
+
+	out.Write(buf[:2])
+
+	for {
+		if _, err := io.ReadFull(hr, buf[:2]); err != nil {
+			return nil, malformed(err)
+		}
+
+		if buf[0] != 0xff {
+			return nil, ErrMalformed
+		}
+
+		// Markers can be padded with any number of 0xff bytes.
+		for buf[1] == 0xff {
+			if _, err := io.ReadFull(hr, buf[1:2]); err != nil {
+				return nil, malformed(err)
+			}
+		}
+
+		marker := buf[1]
+
+		// Some markers don't have any data after them.
+		if marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 {
+			out.Write(buf[:2])
+
+			continue
+		}
+
+		if marker == markerEOI {
+			out.Write(buf[:2])
+
+			break
+		}
+
+		if _, err := io.ReadFull(hr, buf[2:4]); err != nil {
+			return nil, malformed(err)
+		}
+
+		// The length includes itself.
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+
+		if length < 2 {
+			return nil, ErrMalformed
+		}
+
+		segment := make([]byte, 4+length-2)
+		copy(segment, buf)
+
+		if _, err := io.ReadFull(hr, segment[4:]); err != nil {
+			return nil, malformed(err)
+		}
+
+		data := segment[4:]
+
+		// Drop the metadata, keeping hold of the colour profile in case the image needs to be re-encoded.
+		switch {
+		case marker == markerAPP1:
+			if bytes.HasPrefix(data, exifHeader) {
+				if o, ok := exifOrientation(data[len(exifHeader):]); ok {
+					orientation = o
+				}
+			}
+
+			continue
+		case marker == markerAPP13:
+			continue
+		case marker == markerAPP2 && bytes.HasPrefix(data, iccHeader):
+			icc = append(icc, segment)
+		}
+
+		out.Write(segment)
+
+		if marker == markerSOS {
+			break
+		}
+	}
+
+	if orientation != 1 {
+		encode := func(w io.Writer, img image.Image) error {
+			var buf bytes.Buffer
+
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+				return err
+			}
+
+			// Put the colour profile back straight after the start of the image, so that the colours don't change.
+			encoded := buf.Bytes()
+			parts := append([][]byte{encoded[:2]}, icc...)
+
+			for _, part := range append(parts, encoded[2:]) {
+				if _, err := w.Write(part); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		if img, err := reorient(raw.Bytes(), r, orientation, encode); err != nil || img != nil {
+			return img, err
+		}
+
+		// Images which are too big to decode keep their orientation, in a segment straight after the start of the
+		// image.
+		data := append(append([]byte{}, exifHeader...), orientationEXIF(orientation)...)
+		segment := []byte{0xff, markerAPP1, 0, 0}
+		binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+
+		start := out.Next(len(jpegStart))
+
+		return io.MultiReader(bytes.NewReader(start), bytes.NewReader(append(segment, data...)), &out, r), nil
+	}
+
+	return io.MultiReader(&out, r), nil
+}
+
+// pngReader is an io.Reader which removes metadata chunks from a PNG as it's read.
+//
+// The underlying reader should start at the first chunk, after the signature.
+type pngReader struct {
+	r io.Reader
+
+	// chunk contains the rest of the chunk which is being passed through.
+	chunk io.Reader
+
+	// done is set once the last chunk has been reached.
+	done bool
+}
+
+func (p *pngReader) Read(b []byte) (int, error) {
+	for {
+		if p.chunk != nil {
+			n, err := p.chunk.Read(b)
+
+			if errors.Is(err, io.EOF) {
+				p.chunk = nil
+
+				if n == 0 {
+					continue
+				}
+
+				err = nil
+			}
+
+			return n, err
+		}
+
+		// Anything after the last chunk is dropped, as it isn't part of the image.
+		if p.done {
+			return 0, io.EOF
+		}
+
+		header := make([]byte, 8)
+
+		if _, err := io.ReadFull(p.r, header); err != nil {
+			return 0, malformed(err)
+		}
+
+		// The chunk data is followed by a 4 byte checksum.
+		size := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		typ := string(header[4:])
+
+		if pngMetadata[typ] {
+			if n, err := io.CopyN(ioutil.Discard, p.r, size); err != nil {
+				return 0, malformed(err)
+			} else if n != size {
+				return 0, ErrMalformed
+			}
+
+			continue
+		}
+
+		p.done = typ == "IEND"
+		p.chunk = io.MultiReader(bytes.NewReader(header), io.LimitReader(p.r, size))
+	}
+}
+
+// stripPNG removes the metadata from a PNG.
+//
+// Metadata is kept in chunks which can be anywhere in the image, so every chunk is looked at as the image is read. The
+// orientation is only applied if it comes before the image data.
+func stripPNG(r io.Reader) (io.Reader, error) {
+	// raw contains everything we've read, in case the image needs to be decoded.
+	var raw bytes.Buffer
+
+	hr := io.TeeReader(io.LimitReader(r, maxHeaderSize), &raw)
+
+	// colour contains the chunks describing the colours of the image, which need to be added back if the image is
+	// re-encoded.
+	var colour [][]byte
+
+	orientation := 1
+
+	if _, err := io.ReadFull(hr, make([]byte, len(pngSignature))); err != nil {
+		return nil, malformed(err)
+	}
+
+	for {
+		header := make([]byte, 8)
+
+		if _, err := io.ReadFull(hr, header); err != nil {
+			return nil, malformed(err)
+		}
+
+		typ := string(header[4:])
+
+		if typ == "IDAT" || typ == "IEND" {
+			break
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4])) + 4
+
+		if size > maxHeaderSize {
+			return nil, ErrMalformed
+		}
+
+		chunk := make([]byte, 8+size)
+		copy(chunk, header)
+
+		if _, err := io.ReadFull(hr, chunk[8:]); err != nil {
+			return nil, malformed(err)
+		}
+
+		// Some encoders put the same header in front of the EXIF data as JPEGs do.
+		if data := chunk[8 : len(chunk)-4]; typ == "eXIf" {
+			if o, ok := exifOrientation(bytes.TrimPrefix(data, exifHeader)); ok {
+				orientation = o
+			}
+		} else if pngColour[typ] {
+			colour = append(colour, chunk)
+		}
+	}
+
+	if orientation != 1 {
+		encode := func(w io.Writer, img image.Image) error {
+			var buf bytes.Buffer
+
+			if err := png.Encode(&buf, img); err != nil {
+				return err
+			}
+
+			// Put the colour chunks back straight after the header chunk, which is always 25 bytes long.
+			encoded := buf.Bytes()
+			at := len(pngSignature) + 25
+			parts := append([][]byte{encoded[:at]}, colour...)
+
+			for _, part := range append(parts, encoded[at:]) {
+				if _, err := w.Write(part); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		if img, err := reorient(raw.Bytes(), r, orientation, encode); err != nil || img != nil {
+			return img, err
+		}
+	}
+
+	// Pass the chunks we've already read back through the filter, leaving out the signature so that it can find the
+	// first chunk.
+	chunks := &pngReader{r: io.MultiReader(bytes.NewReader(raw.Bytes()[len(pngSignature):]), r)}
+
+	if orientation == 1 {
+		return io.MultiReader(bytes.NewReader(pngSignature), chunks), nil
+	}
+
+	// Images which are too big to decode keep their orientation, in a chunk straight after the header chunk, which is
+	// always 25 bytes long.
+	data := orientationEXIF(orientation)
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(append(chunk, "eXIf"...), data...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+
+	return io.MultiReader(
+		bytes.NewReader(pngSignature),
+		io.LimitReader(chunks, 25),
+		bytes.NewReader(chunk),
+		chunks,
+	), nil
+}
+
+// zeroReader is an io.Reader which only contains zeroes.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+
+	return len(b), nil
+}
+
+// webpReader is an io.Reader which removes the metadata from a WebP as it's read.
+//
+// The size of a WebP is at the very start of it, so rather than having to read all of it to work out its new size,
+// metadata chunks are replaced with padding of the same size. The underlying reader should start at the first chunk,
+// after the RIFF header.
+type webpReader struct {
+	r io.Reader
+
+	// remaining is the number of bytes left in the image, according to the RIFF header.
+	remaining int64
+
+	// chunk contains the rest of the chunk which is being passed through.
+	chunk io.Reader
+}
+
+func (w *webpReader) Read(b []byte) (int, error) {
+	for {
+		if w.chunk != nil {
+			n, err := w.chunk.Read(b)
+
+			if errors.Is(err, io.EOF) {
+				w.chunk = nil
+
+				if n == 0 {
+					continue
+				}
+
+				err = nil
+			}
+
+			return n, err
+		}
+
+		// Anything after the last chunk is dropped, as it isn't part of the image.
+		if w.remaining == 0 {
+			return 0, io.EOF
+		}
+
+		header := make([]byte, 8)
+
+		if _, err := io.ReadFull(w.r, header); err != nil {
+			return 0, malformed(err)
+		}
+
+		// Chunks with an odd size are followed by a padding byte.
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		size += size % 2
+		typ := string(header[:4])
+
+		if 8+size > w.remaining {
+			return 0, ErrMalformed
+		}
+
+		w.remaining -= 8 + size
+
+		switch {
+		case webpMetadata[typ]:
+			if n, err := io.CopyN(ioutil.Discard, w.r, size); err != nil {
+				return 0, malformed(err)
+			} else if n != size {
+				return 0, ErrMalformed
+			}
+
+			// Chunks which decoders don't know about are skipped over.
+			copy(header, "JUNK")
+			w.chunk = io.MultiReader(bytes.NewReader(header), io.LimitReader(zeroReader{}, size))
+		case typ == "VP8X":
+			// The extended format chunk only contains flags and the size of the canvas.
+			if size != 10 {
+				return 0, ErrMalformed
+			}
+
+			data := make([]byte, size)
+
+			if _, err := io.ReadFull(w.r, data); err != nil {
+				return 0, malformed(err)
+			}
+
+			data[0] &^= webpMetadataFlags
+			w.chunk = bytes.NewReader(append(header, data...))
+		default:
+			w.chunk = io.MultiReader(bytes.NewReader(header), &fullReader{r: w.r, remaining: size})
+		}
+	}
+}
+
+// fullReader is an io.Reader which reads a fixed number of bytes, returning ErrMalformed if there are fewer than that.
+type fullReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (f *fullReader) Read(b []byte) (int, error) {
+	if f.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(b)) > f.remaining {
+		b = b[:f.remaining]
+	}
+
+	n, err := f.r.Read(b)
+	f.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if f.remaining > 0 {
+			return n, ErrMalformed
+		}
+
+		err = nil
+	}
+
+	return n, err
+}
+
+// stripWebP removes the metadata from a WebP.
+//
+// Metadata is kept in its own chunks, which are replaced with padding as the image is read. The image data is passed
+// through untouched.
+func stripWebP(r io.Reader) (io.Reader, error) {
+	header := make([]byte, 12)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, malformed(err)
+	}
+
+	// The size in the header includes the form type, which comes before the first chunk.
+	size := int64(binary.LittleEndian.Uint32(header[4:]))
+
+	if size < 4 {
+		return nil, ErrMalformed
+	}
+
+	return io.MultiReader(bytes.NewReader(header), &webpReader{r: r, remaining: size - 4}), nil
+}
+
+// Strip returns a copy of an image with its EXIF, XMP and IPTC metadata removed.
+//
+// Once the metadata is gone, there's nothing to tell viewers that an image should be displayed rotated or flipped, so
+// images which need to be are decoded, turned the right way round and re-encoded instead. Images which are too big to
+// decode keep their orientation, and nothing else.
+//
+// WebPs don't have an orientation, so they just have their metadata removed. AVIFs keep their metadata in items which
+// the image data can be mixed in with, so they are rejected with ErrUnsupported. Everything other than JPEGs, PNGs,
+// WebPs and AVIFs is returned unchanged.
+//
+// The start of the image is read straight away, and the rest of it is read through the returned reader. This function
+// will return ErrMalformed if the image is too badly formed for its metadata to be removed.
+func Strip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	start, err := br.Peek(sniff.Size)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	contentType := sniff.ContentType(start)
+
+	switch {
+	case bytes.HasPrefix(start, jpegStart):
+		return stripJPEG(br)
+	case bytes.HasPrefix(start, pngSignature):
+		return stripPNG(br)
+	case contentType == "image/webp":
+		return stripWebP(br)
+	case contentType == "image/avif":
+		return nil, ErrUnsupported
+	default:
+		return br, nil
+	}
+}
@@ -0,0 +1,431 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// errRead is returned by errorReader.
+var errRead = errors.New("read failed")
+
+// errorReader is an io.Reader which always fails.
+type errorReader struct{}
+
+func (errorReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+// jpegSegment returns a JPEG marker segment containing the specified data.
+func jpegSegment(marker byte, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// pngChunk returns a PNG chunk containing the specified data.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(append(chunk, typ...), data...)
+
+	return append(chunk, make([]byte, 4)...)
+}
+
+// webpChunk returns a WebP chunk containing the specified data.
+func webpChunk(typ string, data []byte) []byte {
+	chunk := append([]byte(typ), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+
+	// Chunks with an odd size are followed by a padding byte.
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+// webp returns a WebP made up of the specified chunks.
+func webp(chunks ...[]byte) []byte {
+	data := bytes.Join(chunks, nil)
+	header := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)+4))
+
+	return append(header, data...)
+}
+
+// withChecksum fills in the checksum of a PNG chunk.
+func withChecksum(chunk []byte) []byte {
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+
+	return chunk
+}
+
+// halves returns an image which is red on the left and blue on the right.
+func halves(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+			} else {
+				img.Set(x, y, color.RGBA{B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	return img
+}
+
+// strip strips an image, returning the result.
+func strip(t *testing.T, img []byte) []byte {
+	t.Helper()
+
+	r, err := Strip(bytes.NewReader(img))
+
+	if err != nil {
+		t.Fatalf("failed to strip image: %v", err)
+	}
+
+	buf, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+
+	return buf
+}
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, halves(16, 8), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	encoded := buf.Bytes()
+	icc := jpegSegment(markerAPP2, iccHeader, []byte("profile"))
+
+	withMetadata := func(orientation int) []byte {
+		return bytes.Join([][]byte{
+			encoded[:2],
+			jpegSegment(markerAPP1, exifHeader, orientationEXIF(orientation)),
+			jpegSegment(markerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			jpegSegment(markerAPP13, []byte("Photoshop 3.0\x00")),
+			icc,
+			encoded[2:],
+		}, nil)
+	}
+
+	// Without an orientation, everything other than the metadata should be left exactly as it was.
+	expected := bytes.Join([][]byte{encoded[:2], icc, encoded[2:]}, nil)
+
+	if stripped := strip(t, withMetadata(1)); !bytes.Equal(stripped, expected) {
+		t.Fatalf("expected stripped image to be %d bytes but got %d", len(expected), len(stripped))
+	}
+
+	// Rotated images should be turned the right way round, keeping their colour profile.
+	stripped := strip(t, withMetadata(6))
+
+	if !bytes.HasPrefix(stripped[2:], icc) {
+		t.Fatal("expected colour profile to be kept")
+	}
+
+	if bytes.Contains(stripped, exifHeader) {
+		t.Fatal("expected EXIF metadata to be removed")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 8, 16) {
+		t.Fatalf("expected image bounds to be %v but got %v", image.Rect(0, 0, 8, 16), img.Bounds())
+	}
+
+	// A quarter turn clockwise puts the left of the image at the top.
+	if r, _, b, _ := img.At(4, 2).RGBA(); r < b {
+		t.Fatalf("expected top of image to be red but got %v", img.At(4, 2))
+	}
+
+	if r, _, b, _ := img.At(4, 13).RGBA(); b < r {
+		t.Fatalf("expected bottom of image to be blue but got %v", img.At(4, 13))
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	src := halves(4, 2)
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	encoded := buf.Bytes()
+
+	// The header chunk comes straight after the signature, and is 25 bytes long.
+	at := len(pngSignature) + 25
+	gamma := withChecksum(pngChunk("gAMA", []byte{0, 1, 0x86, 0xa0}))
+
+	withMetadata := func(orientation int) []byte {
+		return bytes.Join([][]byte{
+			encoded[:at],
+			withChecksum(pngChunk("eXIf", orientationEXIF(orientation))),
+			gamma,
+			withChecksum(pngChunk("tEXt", []byte("Author\x00Someone"))),
+			encoded[at : len(encoded)-12],
+			withChecksum(pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))),
+			encoded[len(encoded)-12:],
+			[]byte("trailing data"),
+		}, nil)
+	}
+
+	// Without an orientation, everything other than the metadata should be left exactly as it was.
+	expected := bytes.Join([][]byte{encoded[:at], gamma, encoded[at:]}, nil)
+
+	if stripped := strip(t, withMetadata(1)); !bytes.Equal(stripped, expected) {
+		t.Fatalf("expected stripped image to be %q but got %q", expected, stripped)
+	}
+
+	// Flipped images should be turned the right way round, keeping their colour chunks.
+	stripped := strip(t, withMetadata(3))
+
+	if !bytes.HasPrefix(stripped[at:], gamma) {
+		t.Fatal("expected colour chunks to be kept")
+	}
+
+	for _, typ := range []string{"eXIf", "tEXt", "iTXt"} {
+		if bytes.Contains(stripped, []byte(typ)) {
+			t.Fatalf("expected %s chunk to be removed", typ)
+		}
+	}
+
+	img, err := png.Decode(bytes.NewReader(stripped))
+
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			expected, got := color.RGBAModel.Convert(src.At(3-x, 1-y)), color.RGBAModel.Convert(img.At(x, y))
+
+			if got != expected {
+				t.Fatalf("expected pixel at (%d, %d) to be %v but got %v", x, y, expected, got)
+			}
+		}
+	}
+}
+
+func TestStripTooBig(t *testing.T) {
+	// Images which are too big to decode should keep their orientation rather than being turned the right way round.
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, halves(16, 8), nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	encoded := buf.Bytes()
+	sof := bytes.Index(encoded, []byte{0xff, 0xc0})
+
+	if sof == -1 {
+		t.Fatal("failed to find frame header")
+	}
+
+	// The height and width come after the length and precision of the frame header.
+	binary.BigEndian.PutUint16(encoded[sof+5:], 20000)
+	binary.BigEndian.PutUint16(encoded[sof+7:], 20000)
+
+	orientation := jpegSegment(markerAPP1, exifHeader, orientationEXIF(6))
+	img := bytes.Join([][]byte{
+		encoded[:2],
+		orientation,
+		jpegSegment(markerAPP13, []byte("Photoshop 3.0\x00")),
+		encoded[2:],
+	}, nil)
+
+	expected := bytes.Join([][]byte{encoded[:2], orientation, encoded[2:]}, nil)
+
+	if stripped := strip(t, img); !bytes.Equal(stripped, expected) {
+		t.Fatalf("expected stripped JPEG to be %d bytes but got %d", len(expected), len(stripped))
+	}
+
+	buf.Reset()
+
+	if err := png.Encode(&buf, halves(4, 2)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	encoded = buf.Bytes()
+	at := len(pngSignature) + 25
+
+	// The width and height are at the start of the header chunk.
+	binary.BigEndian.PutUint32(encoded[len(pngSignature)+8:], 20000)
+	binary.BigEndian.PutUint32(encoded[len(pngSignature)+12:], 20000)
+	withChecksum(encoded[len(pngSignature):at])
+
+	img = bytes.Join([][]byte{
+		encoded[:at],
+		withChecksum(pngChunk("tEXt", []byte("Author\x00Someone"))),
+		withChecksum(pngChunk("eXIf", append(append([]byte{}, exifHeader...), orientationEXIF(3)...))),
+		encoded[at:],
+	}, nil)
+
+	expected = bytes.Join([][]byte{encoded[:at], withChecksum(pngChunk("eXIf", orientationEXIF(3))), encoded[at:]}, nil)
+
+	if stripped := strip(t, img); !bytes.Equal(stripped, expected) {
+		t.Fatalf("expected stripped PNG to be %q but got %q", expected, stripped)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	// The flags say that the image has an alpha channel, EXIF metadata and XMP metadata.
+	canvas := []byte{0x10 | 0x08 | 0x04, 0, 0, 0, 3, 0, 0, 1, 0, 0}
+	data := webpChunk("VP8L", []byte("image"))
+	xmp := []byte("<x:xmpmeta/>\x00")
+
+	img := webp(
+		webpChunk("VP8X", canvas),
+		data,
+		webpChunk("EXIF", orientationEXIF(1)),
+		webpChunk("XMP ", xmp),
+	)
+
+	// The metadata should be replaced with padding, and the flags should no longer mention it.
+	expected := webp(
+		webpChunk("VP8X", append([]byte{0x10}, canvas[1:]...)),
+		data,
+		webpChunk("JUNK", make([]byte, len(orientationEXIF(1)))),
+		webpChunk("JUNK", make([]byte, len(xmp))),
+	)
+
+	if stripped := strip(t, append(img, "trailing data"...)); !bytes.Equal(stripped, expected) {
+		t.Fatalf("expected stripped image to be %q but got %q", expected, stripped)
+	}
+
+	// Images which are cut short or have chunks which go past the end of the image can't have their metadata removed.
+	imgs := [][]byte{
+		img[:len(img)-3],
+		webp(webpChunk("VP8X", canvas[:4])),
+		append(webp(webpChunk("VP8L", nil))[:16], 0xff, 0, 0, 0),
+	}
+
+	for _, img := range imgs {
+		r, err := Strip(bytes.NewReader(img))
+
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+
+		if err != ErrMalformed {
+			t.Fatalf("expected error for %q to be %q but got %q", img, ErrMalformed, err)
+		}
+	}
+}
+
+func TestStripAVIF(t *testing.T) {
+	// AVIFs can't have their metadata removed, so they should be rejected.
+	img := "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"
+
+	if _, err := Strip(strings.NewReader(img)); err != ErrUnsupported {
+		t.Fatalf("expected error to be %q but got %q", ErrUnsupported, err)
+	}
+}
+
+func TestStripOther(t *testing.T) {
+	// Anything which isn't a JPEG, PNG, WebP or AVIF should be left alone.
+	for _, img := range []string{"", "GIF89a", "image"} {
+		if stripped := strip(t, []byte(img)); string(stripped) != img {
+			t.Fatalf("expected stripped image to be %q but got %q", img, stripped)
+		}
+	}
+
+	// Images which are cut short can't have their metadata removed.
+	for _, img := range []string{"\xff\xd8", "\xff\xd8\xff\xe1\x00\x10Exif", string(pngSignature) + "\x00\x00"} {
+		if _, err := Strip(strings.NewReader(img)); err != ErrMalformed {
+			t.Fatalf("expected error for %q to be %q but got %q", img, ErrMalformed, err)
+		}
+	}
+
+	// Errors from the underlying reader should be passed through.
+	r := io.MultiReader(bytes.NewReader(jpegStart), errorReader{})
+
+	if _, err := Strip(r); err != errRead {
+		t.Fatalf("expected error to be %q but got %q", errRead, err)
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	exif := append(append([]byte{}, exifHeader...), orientationEXIF(6)...)
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected int
+	}{
+		{
+			name:     "jpeg",
+			header:   bytes.Join([][]byte{jpegStart, jpegSegment(markerAPP2, iccHeader), jpegSegment(markerAPP1, exif)}, nil),
+			expected: 6,
+		},
+		{
+			name:     "jpeg without exif",
+			header:   bytes.Join([][]byte{jpegStart, jpegSegment(markerAPP2, iccHeader)}, nil),
+			expected: 1,
+		},
+		{
+			name:     "jpeg after image data",
+			header:   bytes.Join([][]byte{jpegStart, jpegSegment(markerSOS), jpegSegment(markerAPP1, exif)}, nil),
+			expected: 1,
+		},
+		{
+			name:     "jpeg cut short",
+			header:   bytes.Join([][]byte{jpegStart, jpegSegment(markerAPP1, exif)}, nil)[:20],
+			expected: 1,
+		},
+		{
+			name:     "png",
+			header:   bytes.Join([][]byte{pngSignature, pngChunk("IHDR", make([]byte, 13)), pngChunk("eXIf", exif)}, nil),
+			expected: 6,
+		},
+		{
+			name:     "png without exif header",
+			header:   bytes.Join([][]byte{pngSignature, pngChunk("eXIf", orientationEXIF(8))}, nil),
+			expected: 8,
+		},
+		{
+			name:     "png after image data",
+			header:   bytes.Join([][]byte{pngSignature, pngChunk("IDAT", nil), pngChunk("eXIf", exif)}, nil),
+			expected: 1,
+		},
+		{
+			name:     "other",
+			header:   []byte("GIF89a"),
+			expected: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if orientation := Orientation(test.header); orientation != test.expected {
+				t.Fatalf("expected orientation to be %d but got %d", test.expected, orientation)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package sniff works out the content type of images from their first few bytes.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+)
+
+// Size is the number of bytes which ContentType needs to reliably detect a content type.
+const Size = 512
+
+// avifBrands contains the ISOBMFF brands used by AVIF images and image sequences.
+var avifBrands = map[string]bool{
+	"avif": true,
+	"avis": true,
+}
+
+// skipBeforeSVG contains the start and end of the things which can come before the root element of an SVG.
+var skipBeforeSVG = [][2]string{
+	{"<?", "?>"},    // The XML declaration and processing instructions.
+	{"<!--", "-->"}, // Comments.
+	{"<!", ">"},     // Document type declarations.
+}
+
+// isWebP returns whether buf is the start of a WebP image, which is a RIFF file with a form type of "WEBP".
+func isWebP(buf []byte) bool {
+	return len(buf) >= 12 && string(buf[:4]) == "RIFF" && string(buf[8:12]) == "WEBP"
+}
+
+// isAVIF returns whether buf is the start of an AVIF image.
+//
+// AVIF images start with a file type box which lists the brands that the file is compatible with, and one of the
+// brands must be an AVIF brand.
+func isAVIF(buf []byte) bool {
+	if len(buf) < 16 || string(buf[4:8]) != "ftyp" {
+		return false
+	}
+
+	size := binary.BigEndian.Uint32(buf)
+
+	// The box must at least contain the major brand and its version.
+	if size < 16 || size%4 != 0 {
+		return false
+	}
+
+	// Only look at the brands which we've got, rather than failing if the box is bigger than the buffer.
+	if int64(size) < int64(len(buf)) {
+		buf = buf[:size]
+	}
+
+	if avifBrands[string(buf[8:12])] {
+		return true
+	}
+
+	// The compatible brands come after the major brand and its version.
+	for i := 16; i+4 <= len(buf); i += 4 {
+		if avifBrands[string(buf[i:i+4])] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSVG returns whether buf is the start of an SVG image, which is an XML document with an svg root element.
+func isSVG(buf []byte) bool {
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+
+	for {
+		buf = bytes.TrimLeft(buf, " \t\r\n")
+
+		if bytes.HasPrefix(buf, []byte("<svg")) {
+			// Make sure that this isn't the start of an element with a longer name.
+			return len(buf) == 4 || bytes.IndexByte([]byte(" \t\r\n/>"), buf[4]) != -1
+		}
+
+		skipped := false
+
+		for _, skip := range skipBeforeSVG {
+			if !bytes.HasPrefix(buf, []byte(skip[0])) {
+				continue
+			}
+
+			end := bytes.Index(buf[len(skip[0]):], []byte(skip[1]))
+
+			if end == -1 {
+				return false
+			}
+
+			buf = buf[len(skip[0])+end+len(skip[1]):]
+			skipped = true
+
+			break
+		}
+
+		if !skipped {
+			return false
+		}
+	}
+}
+
+// ContentType returns the content type of the data which starts with buf.
+//
+// This recognises WebP, AVIF and SVG images as well as everything that http.DetectContentType does. At most Size bytes
+// are considered.
+func ContentType(buf []byte) string {
+	if len(buf) > Size {
+		buf = buf[:Size]
+	}
+
+	switch {
+	case isWebP(buf):
+		return "image/webp"
+	case isAVIF(buf):
+		return "image/avif"
+	case isSVG(buf):
+		return "image/svg+xml"
+	default:
+		return http.DetectContentType(buf)
+	}
+}
@@ -0,0 +1,56 @@
+package sniff
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		data        string
+		contentType string
+	}{
+		{data: "GIF89a", contentType: "image/gif"},
+		{data: "\xff\xd8\xff\xe0", contentType: "image/jpeg"},
+		{data: "\x89PNG\r\n\x1a\n", contentType: "image/png"},
+		{data: "RIFF\x24\x00\x00\x00WEBPVP8 ", contentType: "image/webp"},
+		{data: "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf", contentType: "image/avif"},
+		{data: "\x00\x00\x00\x1cftypavis\x00\x00\x00\x00avismsf1miaf", contentType: "image/avif"},
+		{data: "\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00mif1avifmiaf", contentType: "image/avif"},
+		{data: "<svg xmlns=\"http://www.w3.org/2000/svg\"/>", contentType: "image/svg+xml"},
+		{data: "\xef\xbb\xbf  <svg>", contentType: "image/svg+xml"},
+		{
+			data:        "<?xml version=\"1.0\"?>\n<!-- A comment. -->\n<!DOCTYPE svg>\n<svg\n>",
+			contentType: "image/svg+xml",
+		},
+	}
+
+	for _, test := range tests {
+		if contentType := ContentType([]byte(test.data)); contentType != test.contentType {
+			t.Fatalf("expected content type of %q to be %q but got %q", test.data, test.contentType, contentType)
+		}
+	}
+
+	// Anything else should be detected in the same way as http.DetectContentType.
+	others := []string{
+		"RIFF\x24\x00\x00\x00WAVEfmt ",
+		"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic",
+
+		// The brands after the end of the file type box aren't part of it.
+		"\x00\x00\x00\x10ftypmif1\x00\x00\x00\x00avif",
+		"<svgs>",
+		"<?xml version=\"1.0\"?><html>",
+		"<!-- <svg>",
+
+		// Anything after the first Size bytes should be ignored.
+		"<!--" + strings.Repeat(" ", Size) + "--><svg>",
+		"",
+	}
+
+	for _, data := range others {
+		if contentType, expected := ContentType([]byte(data)), http.DetectContentType([]byte(data)); contentType != expected {
+			t.Fatalf("expected content type of %q to be %q but got %q", data, expected, contentType)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package svg removes anything from SVG images which could run scripts or load resources from elsewhere.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformed is returned by Sanitise when an image isn't a well-formed SVG.
+var ErrMalformed = errors.New("malformed image")
+
+// forbiddenElements contains the (lowercase) names of the elements which are removed along with everything in them.
+var forbiddenElements = map[string]bool{
+	"script":        true,
+	"handler":       true, // Event handlers from SVG Tiny.
+	"listener":      true,
+	"foreignobject": true, // Anything can be embedded in a foreign object, including HTML.
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"audio":         true,
+	"video":         true,
+	"canvas":        true,
+
+	// CSS can load resources from elsewhere in more ways than we can reliably check for, such as through @import
+	// rules and escapes, so style sheets are removed rather than checked.
+	"style": true,
+}
+
+// animationElements contains the (lowercase) names of the elements which can change the attributes of other elements.
+var animationElements = map[string]bool{
+	"animate":          true,
+	"animatecolor":     true,
+	"animatemotion":    true,
+	"animatetransform": true,
+	"set":              true,
+}
+
+// safeDataTypes contains the prefixes of the data URIs which can be referred to, which are the raster image types that
+// can't contain scripts.
+var safeDataTypes = []string{
+	"data:image/gif",
+	"data:image/jpeg",
+	"data:image/png",
+	"data:image/webp",
+}
+
+// referenceAttrs contains the (lowercase) names of the attributes which refer to other resources, either inside or
+// outside of the image.
+var referenceAttrs = map[string]bool{
+	"href":       true, // This also covers xlink:href, as the prefix isn't part of the local name.
+	"src":        true,
+	"data":       true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"background": true,
+	"codebase":   true,
+	"lowsrc":     true,
+	"dynsrc":     true,
+}
+
+// urlPattern matches CSS url() references, capturing what they refer to.
+var urlPattern = regexp.MustCompile(`(?i)url\(\s*['"]?\s*([^'"\s)]*)`)
+
+// errorReader keeps track of the last error returned by a reader, so that read errors can be told apart from errors in
+// the image itself.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// safeReference returns whether a reference only points at something within the image itself.
+func safeReference(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+
+	for _, prefix := range safeDataTypes {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unescapeCSS replaces the escapes in CSS with the characters that they stand for.
+//
+// Escapes are either a backslash followed by up to 6 hex digits (and optionally a single whitespace character), or a
+// backslash followed by the character itself.
+func unescapeCSS(css string) string {
+	if !strings.Contains(css, "\\") {
+		return css
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(css); i++ {
+		if css[i] != '\\' || i+1 == len(css) {
+			b.WriteByte(css[i])
+
+			continue
+		}
+
+		j := i + 1
+
+		for j < len(css) && j < i+7 && strings.IndexByte("0123456789abcdefABCDEF", css[j]) != -1 {
+			j++
+		}
+
+		if j == i+1 {
+			// Anything other than a hex digit stands for itself.
+			b.WriteByte(css[j])
+			i = j
+
+			continue
+		}
+
+		r, _ := strconv.ParseUint(css[i+1:j], 16, 32)
+		b.WriteRune(rune(r))
+
+		if j < len(css) && strings.IndexByte(" \t\r\n\f", css[j]) != -1 {
+			j++
+		}
+
+		i = j - 1
+	}
+
+	return b.String()
+}
+
+// safeCSS returns whether all of the references in a CSS value are safe.
+//
+// This is used for presentation attributes such as fill, which can refer to other things in the same way as CSS.
+func safeCSS(css string) bool {
+	css = unescapeCSS(css)
+
+	for _, match := range urlPattern.FindAllStringSubmatch(css, -1) {
+		if !safeReference(match[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// safeAttr returns whether an attribute can be kept.
+func safeAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		// Event handlers run scripts.
+		return false
+	case name == "style":
+		// Style attributes are removed for the same reasons as style elements.
+		return false
+	case name == "base" && attr.Name.Space == "xml":
+		// This changes what relative references refer to.
+		return false
+	case referenceAttrs[name]:
+		return safeReference(attr.Value)
+	default:
+		return safeCSS(attr.Value)
+	}
+}
+
+// forbidden returns whether an element must be removed along with everything in it.
+func forbidden(el xml.StartElement) bool {
+	name := strings.ToLower(el.Name.Local)
+
+	if forbiddenElements[name] {
+		return true
+	}
+
+	// Animations which change links or event handlers could be used to run scripts.
+	if animationElements[name] {
+		for _, attr := range el.Attr {
+			if strings.ToLower(attr.Name.Local) != "attributename" {
+				continue
+			}
+
+			target := strings.ToLower(attr.Value)
+
+			if strings.HasSuffix(target, "href") || strings.HasPrefix(target, "on") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// qualifiedName returns the name of an element or attribute as it appeared in the image, including its prefix.
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// writeStart writes a start tag to buf, leaving out any unsafe attributes.
+func writeStart(buf *bytes.Buffer, el xml.StartElement) {
+	buf.WriteByte('<')
+	buf.WriteString(qualifiedName(el.Name))
+
+	for _, attr := range el.Attr {
+		if !safeAttr(attr) {
+			continue
+		}
+
+		buf.WriteByte(' ')
+		buf.WriteString(qualifiedName(attr.Name))
+		buf.WriteString(`="`)
+		_ = xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteByte('"')
+	}
+
+	buf.WriteByte('>')
+}
+
+// Sanitise removes scripts, event handlers and references to anything outside of the image from an SVG.
+//
+// Style sheets and style attributes are removed, as CSS has too many ways of loading resources to check for. Comments,
+// processing instructions and document type declarations are removed as well, as they aren't needed to display the
+// image. ErrMalformed is returned if the image isn't well-formed or its root element isn't an svg element.
+func Sanitise(r io.Reader) (io.Reader, error) {
+	er := &errorReader{r: r}
+	d := xml.NewDecoder(er)
+
+	var (
+		buf bytes.Buffer
+
+		// open contains the names of the elements which we're inside of.
+		open []string
+
+		// skip is the number of open elements which are being removed.
+		skip int
+
+		root bool
+	)
+
+	for {
+		token, err := d.RawToken()
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if er.err != nil {
+			return nil, er.err
+		} else if err != nil {
+			return nil, ErrMalformed
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(open) == 0 {
+				// There can only be one root element, and it must be an svg element.
+				if root || token.Name.Local != "svg" {
+					return nil, ErrMalformed
+				}
+
+				root = true
+			}
+
+			open = append(open, qualifiedName(token.Name))
+
+			if skip > 0 || forbidden(token) {
+				skip++
+			} else {
+				writeStart(&buf, token)
+			}
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != qualifiedName(token.Name) {
+				return nil, ErrMalformed
+			}
+
+			open = open[:len(open)-1]
+
+			if skip > 0 {
+				skip--
+			} else {
+				buf.WriteString("</" + qualifiedName(token.Name) + ">")
+			}
+		case xml.CharData:
+			if len(open) == 0 || skip > 0 {
+				continue
+			}
+
+			_ = xml.EscapeText(&buf, token)
+		}
+	}
+
+	if !root || len(open) > 0 {
+		return nil, ErrMalformed
+	}
+
+	return &buf, nil
+}
@@ -0,0 +1,152 @@
+package svg
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// errRead is returned by failingReader.
+var errRead = errors.New("read failed")
+
+// failingReader is an io.Reader which always fails.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+// sanitise sanitises an image, returning the result.
+func sanitise(t *testing.T, img string) string {
+	t.Helper()
+
+	r, err := Sanitise(strings.NewReader(img))
+
+	if err != nil {
+		t.Fatalf("failed to sanitise %q: %v", img, err)
+	}
+
+	buf, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+
+	return string(buf)
+}
+
+func TestSanitise(t *testing.T) {
+	tests := []struct {
+		img       string
+		sanitised string
+	}{
+		{
+			img:       `<?xml version="1.0"?><!-- A comment. --><!DOCTYPE svg><svg><rect width="1"/></svg>`,
+			sanitised: `<svg><rect width="1"></rect></svg>`,
+		},
+		{
+			img:       `<svg><script>alert(1)</script><g><SCRIPT><![CDATA[alert(2)]]></SCRIPT></g></svg>`,
+			sanitised: `<svg><g></g></svg>`,
+		},
+		{
+			img:       `<svg onload="alert(1)"><rect ONCLICK="alert(2)" fill="red"/></svg>`,
+			sanitised: `<svg><rect fill="red"></rect></svg>`,
+		},
+		{
+			img:       `<svg><foreignObject><div xmlns="http://www.w3.org/1999/xhtml">text</div></foreignObject></svg>`,
+			sanitised: `<svg></svg>`,
+		},
+		{
+			img: `<svg xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<use xlink:href="#a"/><use href="https://example.com/a.svg#a"/><a href="javascript:alert(1)">a</a>` +
+				`<image href="data:image/png;base64,AAAA"/><image href="data:image/svg+xml;base64,AAAA"/></svg>`,
+			sanitised: `<svg xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<use xlink:href="#a"></use><use></use><a>a</a>` +
+				`<image href="data:image/png;base64,AAAA"></image><image></image></svg>`,
+		},
+		{
+			img:       `<svg><rect fill="url(#a)" style="fill: red"/><rect filter="url('https://example.com/a.svg#b')"/></svg>`,
+			sanitised: `<svg><rect fill="url(#a)"></rect><rect></rect></svg>`,
+		},
+		{
+			// Escapes shouldn't hide references to anything outside of the image.
+			img: `<svg><rect fill="\75 rl(https://example.com/a.svg#b)"/><rect fill="\55\52\4c(#a)"/>` +
+				`<rect cursor="u\rl(https://example.com/a.cur)"/></svg>`,
+			sanitised: `<svg><rect></rect><rect fill="\55\52\4c(#a)"></rect><rect></rect></svg>`,
+		},
+		{
+			img: `<svg><style>rect { fill: url(#a) }</style><style>rect{fill:\75 rl(https://example.com/a.svg#b)}</style>` +
+				`<style>@\69mport "https://example.com/a.css";</style><g><style>rect { fill: red }</style></g></svg>`,
+			sanitised: `<svg><g></g></svg>`,
+		},
+		{
+			img: `<svg xml:base="https://example.com/"><a data="https://example.com/a"/>` +
+				`<image SRC="https://example.com/a.png"/><image href="#a"/></svg>`,
+			sanitised: `<svg><a></a><image></image><image href="#a"></image></svg>`,
+		},
+		{
+			img: `<svg><a><set attributeName="href" to="javascript:alert(1)"/>a</a>` +
+				`<rect><animate attributeName="width" to="10"/></rect></svg>`,
+			sanitised: `<svg><a>a</a><rect><animate attributeName="width" to="10"></animate></rect></svg>`,
+		},
+		{
+			img:       "<svg>\n\t<text x=\"1\">&lt;&amp;&#34;</text>\n</svg>\n",
+			sanitised: "<svg>&#xA;&#x9;<text x=\"1\">&lt;&amp;&#34;</text>&#xA;</svg>",
+		},
+	}
+
+	for _, test := range tests {
+		if sanitised := sanitise(t, test.img); sanitised != test.sanitised {
+			t.Fatalf("expected sanitised image to be %q but got %q", test.sanitised, sanitised)
+		}
+	}
+}
+
+func TestUnescapeCSS(t *testing.T) {
+	tests := []struct {
+		css       string
+		unescaped string
+	}{
+		{css: `fill: red`, unescaped: `fill: red`},
+		{css: `\75 rl(a)`, unescaped: `url(a)`},
+		{css: `\000075rl(a)`, unescaped: `url(a)`},
+		{css: `\0000750`, unescaped: `u0`},
+		{css: `@\69mport`, unescaped: `@import`},
+		{css: `u\rl(\"a\")`, unescaped: `url("a")`},
+		{css: `a\`, unescaped: `a\`},
+	}
+
+	for _, test := range tests {
+		if unescaped := unescapeCSS(test.css); unescaped != test.unescaped {
+			t.Fatalf("expected %q to be unescaped to %q but got %q", test.css, test.unescaped, unescaped)
+		}
+	}
+}
+
+func TestSanitiseMalformed(t *testing.T) {
+	// Anything which isn't a well-formed SVG should be rejected.
+	imgs := []string{
+		"",
+		"<html></html>",
+		"<svg>",
+		"<svg></g>",
+		"<svg></svg><svg></svg>",
+		"<svg>&nbsp;</svg>",
+		`<?xml version="1.0" encoding="ISO-8859-1"?><svg></svg>`,
+	}
+
+	for _, img := range imgs {
+		if _, err := Sanitise(strings.NewReader(img)); err != ErrMalformed {
+			t.Fatalf("expected error for %q to be %q but got %q", img, ErrMalformed, err)
+		}
+	}
+
+	// Errors from the underlying reader should be passed through.
+	r := io.MultiReader(strings.NewReader("<svg>"), failingReader{})
+
+	if _, err := Sanitise(r); err != errRead {
+		t.Fatalf("expected error to be %q but got %q", errRead, err)
+	}
+}
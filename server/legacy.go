@@ -0,0 +1,466 @@
+package moodboard
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/jackwilsdon/moodboard/internal/ctxio"
+)
+
+// LegacyStore represents a collection of moodboards which does not support contexts.
+//
+// Each method behaves the same as the method with the same name on Store. Implementations can be used anywhere that
+// a Store is needed by wrapping them with AdaptLegacyStore.
+//
+// This interface only contains the methods which stores had before contexts were added, and won't grow with Store.
+// Anything else which Store needs is built on top of these methods by AdaptLegacyStore.
+type LegacyStore interface {
+	CreateBoard(name string) (Board, error)
+	Boards() ([]Board, error)
+	RenameBoard(id, name string) error
+	DeleteBoard(id string) error
+	Create(boardID string, img io.Reader) (string, error)
+	All(boardID string) ([]Item, error)
+	Update(boardID, id string, update ItemUpdate) (Item, error)
+	AddTag(boardID, id, tag string) (Item, error)
+	RemoveTag(boardID, id, tag string) (Item, error)
+	GetImage(boardID, id string) (io.Reader, error)
+	MoveBefore(boardID, id, beforeID string) error
+	MoveAfter(boardID, id, afterID string) error
+	Delete(boardID, id string) error
+}
+
+// legacyStore adapts a LegacyStore to a Store.
+//
+// As the underlying store has no way to be cancelled, each method only checks the context before calling it.
+type legacyStore struct {
+	s LegacyStore
+}
+
+// indexOf returns the index of the item with the specified ID, or -1 if there isn't one.
+func indexOf(items []Item, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (l legacyStore) CreateBoard(ctx context.Context, name string) (Board, error) {
+	if err := ctx.Err(); err != nil {
+		return Board{}, err
+	}
+
+	return l.s.CreateBoard(name)
+}
+
+func (l legacyStore) Boards(ctx context.Context) ([]Board, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return l.s.Boards()
+}
+
+func (l legacyStore) RenameBoard(ctx context.Context, id, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return l.s.RenameBoard(id, name)
+}
+
+func (l legacyStore) DeleteBoard(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return l.s.DeleteBoard(id)
+}
+
+func (l legacyStore) Create(ctx context.Context, boardID string, img io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// We can still stop an upload part of the way through by cutting off the image.
+	return l.s.Create(boardID, ctxio.NewReader(ctx, img))
+}
+
+func (l legacyStore) All(ctx context.Context, boardID string) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return l.s.All(boardID)
+}
+
+func (l legacyStore) Page(ctx context.Context, boardID string, opts PageOptions) (Page, error) {
+	if err := ctx.Err(); err != nil {
+		return Page{}, err
+	}
+
+	var after string
+
+	// Cursors contain the ID of the last item on the previous page.
+	if opts.Cursor != "" {
+		buf, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+
+		if err != nil || len(buf) == 0 {
+			return Page{}, ErrInvalidCursor
+		}
+
+		after = string(buf)
+	}
+
+	items, err := l.s.All(boardID)
+
+	if err != nil {
+		return Page{}, err
+	}
+
+	start := 0
+
+	// Legacy stores can't say where an item was once it has been deleted, so cursors stop working when their item is
+	// deleted.
+	if after != "" {
+		if start = indexOf(items, after) + 1; start == 0 {
+			return Page{}, ErrInvalidCursor
+		}
+	}
+
+	var (
+		page Page
+		last Item
+	)
+
+	for _, item := range items[start:] {
+		if opts.Filter != nil && !opts.Filter(item) {
+			continue
+		}
+
+		// Only hand out a cursor if there's actually another item to return.
+		if opts.Limit > 0 && len(page.Items) == opts.Limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(last.ID))
+
+			break
+		}
+
+		page.Items = append(page.Items, item)
+		last = item
+	}
+
+	return page, nil
+}
+
+func (l legacyStore) Update(ctx context.Context, boardID, id string, update ItemUpdate) (Item, error) {
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+
+	return l.s.Update(boardID, id, update)
+}
+
+func (l legacyStore) AddTag(ctx context.Context, boardID, id, tag string) (Item, error) {
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+
+	return l.s.AddTag(boardID, id, tag)
+}
+
+func (l legacyStore) RemoveTag(ctx context.Context, boardID, id, tag string) (Item, error) {
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+
+	return l.s.RemoveTag(boardID, id, tag)
+}
+
+func (l legacyStore) GetImage(ctx context.Context, boardID, id string) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return l.s.GetImage(boardID, id)
+}
+
+func (l legacyStore) GetThumbnail(ctx context.Context, boardID, id string, _ int) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Legacy stores don't have thumbnails, so the original image is always used.
+	return l.s.GetImage(boardID, id)
+}
+
+func (l legacyStore) MoveBefore(ctx context.Context, boardID, id, beforeID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return l.s.MoveBefore(boardID, id, beforeID)
+}
+
+func (l legacyStore) MoveAfter(ctx context.Context, boardID, id, afterID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return l.s.MoveAfter(boardID, id, afterID)
+}
+
+// moveTo moves an item to an index on a board by moving it next to the item which is currently there.
+func (l legacyStore) moveTo(ctx context.Context, boardID, id string, index int, end bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	items, err := l.s.All(boardID)
+
+	if err != nil {
+		return err
+	}
+
+	current := indexOf(items, id)
+
+	if current == -1 {
+		return ErrNoSuchItem
+	}
+
+	if end {
+		index = len(items) - 1
+	}
+
+	if index < 0 || index >= len(items) {
+		return fmt.Errorf("%w: %d is not between 0 and %d", ErrIndexOutOfRange, index, len(items)-1)
+	}
+
+	switch {
+	case index > current:
+		// The items in between shift back by one, so the item needs to go after the one which is at the index now.
+		return l.s.MoveAfter(boardID, id, items[index].ID)
+	case index < current:
+		return l.s.MoveBefore(boardID, id, items[index].ID)
+	default:
+		return nil
+	}
+}
+
+func (l legacyStore) MoveToIndex(ctx context.Context, boardID, id string, index int) error {
+	return l.moveTo(ctx, boardID, id, index, false)
+}
+
+func (l legacyStore) MoveToStart(ctx context.Context, boardID, id string) error {
+	return l.moveTo(ctx, boardID, id, 0, false)
+}
+
+func (l legacyStore) MoveToEnd(ctx context.Context, boardID, id string) error {
+	return l.moveTo(ctx, boardID, id, 0, true)
+}
+
+// unsupported checks that a board exists, and then returns ErrUnsupported.
+//
+// Legacy stores can only delete items permanently and move one item at a time, so anything which needs to go to the
+// trash or change several items at once can't be done without breaking its contract.
+func (l legacyStore) unsupported(ctx context.Context, boardID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := l.s.All(boardID); err != nil {
+		return err
+	}
+
+	return ErrUnsupported
+}
+
+func (l legacyStore) Reorder(ctx context.Context, boardID string, _ []string) error {
+	return l.unsupported(ctx, boardID)
+}
+
+func (l legacyStore) Delete(ctx context.Context, boardID, _ string) error {
+	return l.unsupported(ctx, boardID)
+}
+
+func (l legacyStore) DeleteMany(ctx context.Context, boardID string, _ []string) error {
+	return l.unsupported(ctx, boardID)
+}
+
+func (l legacyStore) MoveManyBefore(ctx context.Context, boardID string, _ []string, _ string) error {
+	return l.unsupported(ctx, boardID)
+}
+
+func (l legacyStore) MoveManyAfter(ctx context.Context, boardID string, _ []string, _ string) error {
+	return l.unsupported(ctx, boardID)
+}
+
+func (l legacyStore) Trash(ctx context.Context, boardID string) ([]TrashedItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Legacy stores remove items straight away rather than moving them to the trash, so it's always empty.
+	if _, err := l.s.All(boardID); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (l legacyStore) Restore(ctx context.Context, boardID, _ string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Nothing is ever in the trash.
+	if _, err := l.s.All(boardID); err != nil {
+		return err
+	}
+
+	return ErrNoSuchItem
+}
+
+func (l legacyStore) Purge(ctx context.Context, boardID, _ string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Nothing is ever in the trash.
+	if _, err := l.s.All(boardID); err != nil {
+		return err
+	}
+
+	return ErrNoSuchItem
+}
+
+func (l legacyStore) Undo(ctx context.Context, boardID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Legacy stores don't keep a history of operations.
+	if _, err := l.s.All(boardID); err != nil {
+		return err
+	}
+
+	return ErrNothingToUndo
+}
+
+func (l legacyStore) Redo(ctx context.Context, boardID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Legacy stores don't keep a history of operations.
+	if _, err := l.s.All(boardID); err != nil {
+		return err
+	}
+
+	return ErrNothingToRedo
+}
+
+func (l legacyStore) Usage(ctx context.Context, boardID string) (Usage, error) {
+	if err := ctx.Err(); err != nil {
+		return Usage{}, err
+	}
+
+	boards, err := l.s.Boards()
+
+	if err != nil {
+		return Usage{}, err
+	}
+
+	var (
+		usage Usage
+		found bool
+	)
+
+	// Legacy stores don't have quotas, so only the sizes of the items are needed.
+	for _, board := range boards {
+		items, err := l.s.All(board.ID)
+
+		if err != nil {
+			return Usage{}, err
+		}
+
+		for _, item := range items {
+			usage.Total += item.Size
+
+			if board.ID == boardID {
+				usage.Board += item.Size
+			}
+		}
+
+		if board.ID == boardID {
+			found = true
+		}
+	}
+
+	if !found {
+		return Usage{}, ErrNoSuchBoard
+	}
+
+	return usage, nil
+}
+
+func (l legacyStore) Palette(ctx context.Context, boardID string) ([]Colour, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Legacy stores don't analyse images, so there aren't any colours.
+	if _, err := l.s.All(boardID); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (l legacyStore) Similar(ctx context.Context, boardID, id string) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	items, err := l.s.All(boardID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if indexOf(items, id) == -1 {
+		return nil, ErrNoSuchItem
+	}
+
+	// Legacy stores don't hash images, so nothing can be found to be similar.
+	return nil, nil
+}
+
+func (l legacyStore) Duplicates(ctx context.Context, boardID string) ([][]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Legacy stores don't hash images, so nothing can be found to be a duplicate.
+	if _, err := l.s.All(boardID); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// AdaptLegacyStore wraps a LegacyStore so that it can be used as a Store.
+//
+// Contexts are checked before each call to the underlying store, and images passed to Create stop being readable once
+// the context is done. Calls which have already started cannot be cancelled.
+//
+// Legacy stores have no trash, history, thumbnails, quotas or image analysis. Moving items to an index or the start or
+// end of a board is built on top of the single moves which they do have, but deleting items, reordering a board and
+// batch operations return ErrUnsupported, as they would otherwise delete permanently or stop part of the way through.
+func AdaptLegacyStore(s LegacyStore) Store {
+	return legacyStore{s: s}
+}
@@ -0,0 +1,208 @@
+package moodboard_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jackwilsdon/moodboard"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// legacyStore is a moodboard.LegacyStore which only implements the methods needed for testing.
+type legacyStore struct {
+	moodboard.LegacyStore
+	boards int
+}
+
+func (s *legacyStore) Boards() ([]moodboard.Board, error) {
+	s.boards++
+
+	return nil, nil
+}
+
+func (s *legacyStore) Create(_ string, img io.Reader) (string, error) {
+	if _, err := ioutil.ReadAll(img); err != nil {
+		return "", err
+	}
+
+	return "id", nil
+}
+
+// cancellingReader is an io.Reader which cancels a context after its first read.
+type cancellingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancellingReader) Read(p []byte) (int, error) {
+	r.cancel()
+
+	return copy(p, "image"), nil
+}
+
+func TestAdaptLegacyStore(t *testing.T) {
+	legacy := &legacyStore{}
+	s := moodboard.AdaptLegacyStore(legacy)
+
+	if _, err := s.Boards(context.Background()); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if id, err := s.Create(context.Background(), "board", bytes.NewReader(nil)); err != nil || id != "id" {
+		t.Fatalf("expected result to be [%q, nil] but got [%q, %q]", "id", id, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The underlying store shouldn't be called at all once the context is done.
+	if _, err := s.Boards(ctx); err != context.Canceled {
+		t.Fatalf("expected error to be %q but got %q", context.Canceled, err)
+	}
+
+	if legacy.boards != 1 {
+		t.Fatalf("expected Boards to be called 1 time but got %d", legacy.boards)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	// Uploads should be cut off part of the way through.
+	if _, err := s.Create(ctx, "board", cancellingReader{cancel: cancel}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error to be %q but got %q", context.Canceled, err)
+	}
+}
+
+// orderedStore is a moodboard.LegacyStore with a single board, which only implements the methods needed to arrange
+// items.
+type orderedStore struct {
+	moodboard.LegacyStore
+	ids []string
+}
+
+func (s *orderedStore) All(boardID string) ([]moodboard.Item, error) {
+	if boardID != "board" {
+		return nil, moodboard.ErrNoSuchBoard
+	}
+
+	items := make([]moodboard.Item, len(s.ids))
+
+	for i, id := range s.ids {
+		items[i] = moodboard.Item{ID: id}
+	}
+
+	return items, nil
+}
+
+// move moves an item before or after another one.
+func (s *orderedStore) move(id, targetID string, after bool) error {
+	var rest []string
+
+	for _, other := range s.ids {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+
+	for i, other := range rest {
+		if other != targetID {
+			continue
+		}
+
+		if after {
+			i++
+		}
+
+		s.ids = append(rest[:i], append([]string{id}, rest[i:]...)...)
+
+		return nil
+	}
+
+	return moodboard.ErrNoSuchItem
+}
+
+func (s *orderedStore) MoveBefore(_, id, beforeID string) error {
+	return s.move(id, beforeID, false)
+}
+
+func (s *orderedStore) MoveAfter(_, id, afterID string) error {
+	return s.move(id, afterID, true)
+}
+
+func TestAdaptLegacyStoreArrange(t *testing.T) {
+	ctx := context.Background()
+	legacy := &orderedStore{ids: []string{"a", "b", "c", "d", "e"}}
+	s := moodboard.AdaptLegacyStore(legacy)
+
+	if err := s.MoveToIndex(ctx, "board", "a", 2); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if !reflect.DeepEqual(legacy.ids, []string{"b", "c", "a", "d", "e"}) {
+		t.Fatalf("expected order to be [b c a d e] but got %v", legacy.ids)
+	}
+
+	if err := s.MoveToStart(ctx, "board", "d"); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if !reflect.DeepEqual(legacy.ids, []string{"d", "b", "c", "a", "e"}) {
+		t.Fatalf("expected order to be [d b c a e] but got %v", legacy.ids)
+	}
+
+	if err := s.MoveToIndex(ctx, "board", "d", 5); !errors.Is(err, moodboard.ErrIndexOutOfRange) {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrIndexOutOfRange, err)
+	}
+
+	// Anything which can't be done all at once shouldn't be done at all.
+	calls := []func() error{
+		func() error { return s.Reorder(ctx, "board", []string{"e", "d", "c", "b", "a"}) },
+		func() error { return s.Delete(ctx, "board", "a") },
+		func() error { return s.DeleteMany(ctx, "board", []string{"a", "b"}) },
+		func() error { return s.MoveManyBefore(ctx, "board", []string{"a", "b"}, "e") },
+		func() error { return s.MoveManyAfter(ctx, "board", []string{"a", "b"}, "e") },
+	}
+
+	for _, call := range calls {
+		if err := call(); err != moodboard.ErrUnsupported {
+			t.Fatalf("expected error to be %q but got %q", moodboard.ErrUnsupported, err)
+		}
+	}
+
+	if !reflect.DeepEqual(legacy.ids, []string{"d", "b", "c", "a", "e"}) {
+		t.Fatalf("expected order to be [d b c a e] but got %v", legacy.ids)
+	}
+
+	if err := s.Reorder(ctx, "nonexistent", nil); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func TestAdaptLegacyStorePage(t *testing.T) {
+	ctx := context.Background()
+	legacy := &orderedStore{ids: []string{"a", "b", "c", "d"}}
+	s := moodboard.AdaptLegacyStore(legacy)
+
+	first, err := s.Page(ctx, "board", moodboard.PageOptions{Limit: 2})
+
+	if err != nil || len(first.Items) != 2 || first.Items[1].ID != "b" || first.NextCursor == "" {
+		t.Fatalf("expected first page to end with %q but got [%v, %q]", "b", first, err)
+	}
+
+	// Moving items around shouldn't affect what comes after the cursor.
+	if err := s.MoveToStart(ctx, "board", "d"); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	second, err := s.Page(ctx, "board", moodboard.PageOptions{Cursor: first.NextCursor, Limit: 2})
+
+	if err != nil || len(second.Items) != 1 || second.Items[0].ID != "c" || second.NextCursor != "" {
+		t.Fatalf("expected second page to be [c] but got [%v, %q]", second, err)
+	}
+
+	if _, err := s.Page(ctx, "board", moodboard.PageOptions{Cursor: "!"}); err != moodboard.ErrInvalidCursor {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrInvalidCursor, err)
+	}
+}
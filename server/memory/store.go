@@ -2,212 +2,146 @@ package memory
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/jackwilsdon/moodboard"
 	"io"
 	"io/ioutil"
 	"sync"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/core"
 )
 
-type item struct {
-	id    string
-	image []byte
+// tx represents a transaction against an in-memory backend.
+type tx struct {
+	*core.Overlay
+	images map[string][]byte
+
+	// changedImages contains the images which have been created or deleted within the transaction, keyed by item ID.
+	// Deleted images are nil.
+	changedImages map[string][]byte
 }
 
-// Store represents an in-memory collection of moodboard items.
-type Store struct {
-	items []item
-	mutex sync.RWMutex
+// newTx creates a new transaction on top of the records and images of a backend.
+func newTx(b *backend) *tx {
+	return &tx{
+		Overlay:       core.NewOverlay(&b.index),
+		images:        b.images,
+		changedImages: make(map[string][]byte),
+	}
 }
 
-// Create creates a new moodboard item in the collection.
-func (s *Store) Create(img io.Reader) (string, error) {
+// CreateImage stores the image for the item with the specified ID.
+func (t *tx) CreateImage(id string, img io.Reader) error {
 	// Read the whole image into memory.
 	buf, err := ioutil.ReadAll(img)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to read image: %w", err)
+		return fmt.Errorf("failed to read image: %w", err)
 	}
 
-	// We're going to be modifying our items slice - lock for writing.
-	s.mutex.Lock()
-
-	// Unlock once we're done.
-	defer s.mutex.Unlock()
-
-	id := uuid.New().String()
+	// Empty images still need to be told apart from deleted ones.
+	if buf == nil {
+		buf = []byte{}
+	}
 
-	s.items = append(s.items, item{
-		id:    id,
-		image: buf,
-	})
+	t.changedImages[id] = buf
 
-	return id, nil
+	return nil
 }
 
-// All returns all moodboard items in the collection.
-func (s *Store) All() ([]string, error) {
-	// We're going to be reading from our items slice - lock for reading.
-	s.mutex.RLock()
+// Image returns the image for the item with the specified ID.
+func (t *tx) Image(id string) (io.Reader, error) {
+	img, ok := t.changedImages[id]
 
-	// Unlock once we're done.
-	defer s.mutex.RUnlock()
+	if !ok {
+		img, ok = t.images[id]
+	}
 
-	if s.items == nil {
-		return nil, nil
+	if !ok || img == nil {
+		return nil, moodboard.ErrNoSuchItem
 	}
 
-	items := make([]string, len(s.items))
+	return bytes.NewReader(img), nil
+}
 
-	// Extract the ID from each item.
-	for i, item := range s.items {
-		items[i] = item.id
-	}
+// DeleteImage removes the image for the item with the specified ID.
+func (t *tx) DeleteImage(id string) error {
+	t.changedImages[id] = nil
+
+	return nil
+}
 
-	return items, nil
+// backend is an in-memory core.Backend.
+type backend struct {
+	index  core.Index
+	images map[string][]byte
+	mutex  sync.RWMutex
 }
 
-// GetImage returns the image for the specified moodboard item in the collection.
-//
-// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
-func (s *Store) GetImage(id string) (io.Reader, error) {
-	// We're going to be reading from our items slice - lock for reading.
-	s.mutex.RLock()
+// View runs fn within a read-only transaction.
+func (b *backend) View(ctx context.Context, fn func(core.Tx) error) error {
+	// We're going to be reading from our records - lock for reading.
+	b.mutex.RLock()
 
 	// Unlock once we're done.
-	defer s.mutex.RUnlock()
+	defer b.mutex.RUnlock()
 
-	// Return the image for the first item we find with a matching ID.
-	for i := range s.items {
-		if s.items[i].id == id {
-			return bytes.NewReader(s.items[i].image), nil
-		}
+	// We may have been waiting on the lock for a while - make sure we still need to run.
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	return nil, moodboard.ErrNoSuchItem
+	return fn(newTx(b))
 }
 
-// move moves a moodboard item before or after another one in the collection.
-func (s *Store) move(id, targetID string, before bool) error {
-	// We're going to be modifying our items slice - lock for writing.
-	s.mutex.Lock()
+// Update runs fn within a read-write transaction.
+//
+// If fn returns an error then none of the changes made within the transaction are kept.
+func (b *backend) Update(ctx context.Context, fn func(core.Tx) error) error {
+	// We're going to be modifying our records - lock for writing.
+	b.mutex.Lock()
 
 	// Unlock once we're done.
-	defer s.mutex.Unlock()
-
-	index := -1
-	target := -1
+	defer b.mutex.Unlock()
 
-	// Find the indexes of the items we're moving.
-	for i, item := range s.items {
-		if item.id == id {
-			index = i
-		}
+	// We may have been waiting on the lock for a while - make sure we still need to run.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-		if item.id == targetID {
-			target = i
-		}
+	// Changes are kept to one side until we know the transaction succeeded, so nothing needs copying up front.
+	t := newTx(b)
 
-		// We can break early if we've found both indexes.
-		if index != -1 && target != -1 {
-			break
-		}
+	if err := fn(t); err != nil {
+		return err
 	}
 
-	// If either of the indexes is missing, return an error.
-	if index == -1 || target == -1 {
-		return moodboard.ErrNoSuchItem
+	// Don't keep the changes if the context was cancelled whilst we were running.
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	item := s.items[index]
-
-	if index < target {
-		// If we're moving the item before the target and it's already before the target then we need to take
-		// 1 off the target to ensure we don't insert it after the target.
-		if before {
-			target--
-		}
+	// Only keep the changes once we know the transaction succeeded.
+	t.Changes().Apply(&b.index)
 
-		// index = 1
-		// target = 4
-		//
-		// 0 1 2 3 4 5
-		// -----------
-		// A B C D E F
-		// |  / / /  |
-		// A C D E X F
-		// | | | | | |
-		// A C D E B F
-		copy(s.items[index:], s.items[index+1:target+1])
-	} else if index > target {
-		// If we're moving the item after the target and it's already after the target then we need to add 1
-		// to the target to ensure we don't insert it before the target.
-		if !before {
-			target++
+	for id, img := range t.changedImages {
+		if img == nil {
+			delete(b.images, id)
+		} else {
+			b.images[id] = img
 		}
-
-		// move([]string{"A", "B", "C", "D", "E", "F"}, 4, 1)
-		//
-		// 0 1 2 3 4 5
-		// -----------
-		// A B C D E F
-		// |  \ \ \  |
-		// A X B C D F
-		// | | | | | |
-		// A E B C D F
-		copy(s.items[target+1:], s.items[target:index])
 	}
 
-	s.items[target] = item
-
 	return nil
 }
 
-// MoveBefore moves a moodboard item before another one in the collection.
-//
-// This method will return moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist.
-func (s *Store) MoveBefore(id string, beforeID string) error {
-	return s.move(id, beforeID, true)
-}
-
-// MoveAfter moves a moodboard item after another one in the collection.
-//
-// This method will return moodboard.ErrNoSuchItem if items with either of the specified IDs do not exist.
-func (s *Store) MoveAfter(id string, afterID string) error {
-	return s.move(id, afterID, false)
-}
-
-// Delete removes a moodboard item from the collection.
-//
-// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
-func (s *Store) Delete(id string) error {
-	// We're going to be modifying our items slice - lock for writing.
-	s.mutex.Lock()
-
-	// Unlock once we're done.
-	defer s.mutex.Unlock()
-
-	remainingItems := make([]item, 0, len(s.items))
-
-	// Only keep items which do not match the ID provided.
-	for _, item := range s.items {
-		if item.id != id {
-			remainingItems = append(remainingItems, item)
-		}
-	}
-
-	// If the number of items is the same then we haven't found anything to delete.
-	if len(s.items) == len(remainingItems) {
-		return moodboard.ErrNoSuchItem
-	}
-
-	s.items = remainingItems
-
-	return nil
+// Store represents an in-memory collection of moodboards.
+type Store struct {
+	*core.Store
 }
 
 // NewStore creates a new in-memory moodboard collection.
 func NewStore() *Store {
-	return &Store{}
+	return &Store{Store: core.NewStore(&backend{images: make(map[string][]byte)})}
 }
@@ -0,0 +1,225 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errNotFound indicates that an object does not exist.
+var errNotFound = errors.New("object not found")
+
+// emptyHash is the SHA-256 hash of an empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// client is a minimal client for the S3 API.
+//
+// Requests are signed using AWS Signature Version 4, and objects are addressed using path-style URLs so that the
+// client works with S3-compatible services which don't support virtual-hosted buckets.
+type client struct {
+	http            *http.Client
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+}
+
+// uriEncode encodes a string in the form expected by AWS Signature Version 4.
+//
+// Every byte other than the unreserved characters is percent-encoded. Slashes are only left alone if keepSlashes is
+// true.
+func uriEncode(s string, keepSlashes bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlashes:
+			b.WriteByte(c)
+		default:
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using the specified key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// sign adds AWS Signature Version 4 headers to a request.
+func (c *client) sign(r *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	scope := date + "/" + c.region + "/s3/aws4_request"
+
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	// The headers must be in alphabetical order.
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + r.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, true),
+		r.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	// The signing key is derived from the secret key and the scope of the request.
+	key := hmacSHA256([]byte("AWS4"+c.secretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+// responseError converts an unsuccessful response into an error.
+func responseError(res *http.Response) error {
+	// Missing objects are expected, so they get their own error.
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	// S3 errors contain an XML body describing what went wrong, but other services may not.
+	if err := xml.NewDecoder(res.Body).Decode(&body); err != nil || body.Code == "" {
+		return fmt.Errorf("unexpected response: %s", res.Status)
+	}
+
+	return fmt.Errorf("unexpected response: %s: %s: %s", res.Status, body.Code, body.Message)
+}
+
+// do sends a signed request for an object.
+//
+// The body of the response must be closed by the caller if no error is returned.
+func (c *client) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + key
+	u.RawPath = uriEncode(u.Path, true)
+
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	payloadHash := emptyHash
+
+	if body != nil {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	} else {
+		// Don't send an empty body with requests which aren't expecting one.
+		r.Body = nil
+	}
+
+	c.sign(r, payloadHash, time.Now())
+
+	res, err := c.http.Do(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err := responseError(res)
+		_ = res.Body.Close()
+
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// get returns the contents of an object.
+//
+// The contents are streamed from the response, so the reader must be closed once it is no longer needed. This
+// method will return errNotFound if the object does not exist.
+func (c *client) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, http.MethodGet, key, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// put creates or replaces an object.
+func (c *client) put(ctx context.Context, key string, body []byte) error {
+	// Make sure we always send a body, even if it's empty.
+	if body == nil {
+		body = []byte{}
+	}
+
+	res, err := c.do(ctx, http.MethodPut, key, body)
+
+	if err != nil {
+		return err
+	}
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	return res.Body.Close()
+}
+
+// delete removes an object.
+//
+// Deleting an object which does not exist is not an error.
+func (c *client) delete(ctx context.Context, key string) error {
+	res, err := c.do(ctx, http.MethodDelete, key, nil)
+
+	if errors.Is(err, errNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	return res.Body.Close()
+}
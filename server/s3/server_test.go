@@ -0,0 +1,108 @@
+package s3_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// server is a fake S3-compatible service which keeps objects in memory.
+//
+// Only the parts of the API which are used by the store are implemented.
+type server struct {
+	bucket      string
+	accessKeyID string
+	objects     map[string][]byte
+	mutex       sync.Mutex
+}
+
+// writeError writes an S3-style error response.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte("<Error><Code>" + code + "</Code><Message>" + code + "</Message></Error>"))
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Make sure the request has at least been signed by the right key.
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/") {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+
+		return
+	}
+
+	// Objects are addressed as "/bucket/key".
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+
+	if len(parts) != 2 || parts[0] != s.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	key := parts[1]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		obj, ok := s.objects[key]
+
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		_, _ = w.Write(obj)
+	case http.MethodPut:
+		obj, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+
+			return
+		}
+
+		s.objects[key] = obj
+	case http.MethodDelete:
+		delete(s.objects, key)
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// keys returns the keys of all objects stored in the server.
+func (s *server) keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// newServer starts a new fake S3 server for testing, which is stopped once the test and all its subtests complete.
+func newServer(t *testing.T) (*server, *httptest.Server) {
+	s := &server{
+		bucket:      "moodboard",
+		accessKeyID: "access-key",
+		objects:     make(map[string][]byte),
+	}
+
+	ts := httptest.NewServer(s)
+
+	t.Cleanup(ts.Close)
+
+	return s, ts
+}
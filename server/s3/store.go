@@ -0,0 +1,268 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/core"
+)
+
+// tx represents a transaction against an S3 backend.
+type tx struct {
+	*core.Index
+	ctx     context.Context
+	backend *backend
+
+	// created contains the image keys which have been written during the transaction.
+	created []string
+
+	// deleted contains the image keys which should be removed once the transaction has been committed.
+	deleted []string
+}
+
+// CreateImage stores the image for the item with the specified ID.
+func (t *tx) CreateImage(id string, img io.Reader) error {
+	// S3 needs to know the size of an object before it is uploaded, so we need to read the whole image first.
+	buf, err := ioutil.ReadAll(img)
+
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	key := t.backend.imageKey(id)
+
+	// Keep track of the object so that we can remove it if the transaction fails. This happens before the upload, as
+	// a failed upload may still have created the object.
+	t.created = append(t.created, key)
+
+	if err := t.backend.client.put(t.ctx, key, buf); err != nil {
+		return fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	return nil
+}
+
+// Image returns the image for the item with the specified ID.
+//
+// The image is streamed from S3 as it is read, so the returned reader is an io.ReadCloser which must be closed.
+func (t *tx) Image(id string) (io.Reader, error) {
+	img, err := t.backend.client.get(t.ctx, t.backend.imageKey(id))
+
+	if errors.Is(err, errNotFound) {
+		return nil, moodboard.ErrNoSuchItem
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+
+	return img, nil
+}
+
+// DeleteImage removes the image for the item with the specified ID.
+func (t *tx) DeleteImage(id string) error {
+	// We can't remove the image until the transaction has been committed, as the transaction may still fail.
+	t.deleted = append(t.deleted, t.backend.imageKey(id))
+
+	return nil
+}
+
+// backend is an S3 core.Backend.
+//
+// Records are kept in an index object, with each image stored in its own object alongside it.
+type backend struct {
+	client *client
+	prefix string
+
+	// S3 has no way to lock an object, so we can only protect the index against concurrent changes from this process.
+	mutex sync.RWMutex
+}
+
+// indexKey returns the key of the index object.
+func (b *backend) indexKey() string {
+	return b.prefix + "index.json"
+}
+
+// imageKey returns the key of the object containing the image for the item with the specified ID.
+func (b *backend) imageKey(id string) string {
+	return b.prefix + "images/" + id
+}
+
+// load downloads the index.
+func (b *backend) load(ctx context.Context) (*core.Index, error) {
+	r, err := b.client.get(ctx, b.indexKey())
+
+	// If the index doesn't exist then we don't have anything stored yet.
+	if errors.Is(err, errNotFound) {
+		return &core.Index{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to download store: %w", err)
+	}
+
+	var idx core.Index
+
+	if err := json.NewDecoder(r).Decode(&idx); err != nil && err != io.EOF {
+		_ = r.Close()
+
+		return nil, fmt.Errorf("failed to read store: %w", err)
+	}
+
+	// We can ignore close errors here as we've already read everything we need.
+	_ = r.Close()
+
+	return &idx, nil
+}
+
+// save uploads the index.
+func (b *backend) save(ctx context.Context, idx *core.Index) error {
+	buf, err := json.Marshal(idx)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+
+	if err := b.client.put(ctx, b.indexKey(), buf); err != nil {
+		return fmt.Errorf("failed to upload store: %w", err)
+	}
+
+	return nil
+}
+
+// View runs fn within a read-only transaction.
+func (b *backend) View(ctx context.Context, fn func(core.Tx) error) error {
+	// We're only going to be reading from S3 - lock for reading.
+	b.mutex.RLock()
+
+	// Unlock once we're done.
+	defer b.mutex.RUnlock()
+
+	idx, err := b.load(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return fn(&tx{Index: idx, ctx: ctx, backend: b})
+}
+
+// Update runs fn within a read-write transaction.
+//
+// If fn returns an error then none of the changes made within the transaction are kept.
+func (b *backend) Update(ctx context.Context, fn func(core.Tx) error) error {
+	// We're going to be writing to S3 - lock for writing.
+	b.mutex.Lock()
+
+	// Unlock once we're done.
+	defer b.mutex.Unlock()
+
+	idx, err := b.load(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	t := &tx{Index: idx, ctx: ctx, backend: b}
+
+	err = fn(t)
+
+	// Don't upload the index if the context was cancelled whilst we were running.
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	// Only upload the index if the transaction succeeded.
+	if err == nil {
+		err = b.save(ctx, t.Index)
+	}
+
+	// Remove any images we uploaded if anything went wrong, as nothing refers to them.
+	//
+	// The context may have been cancelled, so we can't use it for the clean up.
+	if err != nil {
+		for _, key := range t.created {
+			_ = b.client.delete(context.Background(), key)
+		}
+
+		return err
+	}
+
+	// Now that the index no longer refers to them we can remove any deleted images.
+	//
+	// If the deletion fails then the image is just left behind, as the index has already been uploaded.
+	for _, key := range t.deleted {
+		_ = b.client.delete(context.Background(), key)
+	}
+
+	return nil
+}
+
+// Options contains the details needed to connect to an S3-compatible service.
+type Options struct {
+	// Endpoint is the URL of the service, such as "https://s3.amazonaws.com".
+	Endpoint string
+
+	// Region is the region that the bucket is in. Defaults to "us-east-1".
+	Region string
+
+	// Bucket is the name of the bucket to store everything in. The bucket must already exist.
+	Bucket string
+
+	// Prefix is prepended to the key of every object, which allows a bucket to be shared.
+	Prefix string
+
+	// AccessKeyID is the ID of the access key used to sign requests.
+	AccessKeyID string
+
+	// SecretAccessKey is the secret of the access key used to sign requests.
+	SecretAccessKey string
+
+	// Client is the HTTP client to send requests with. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Store represents a collection of moodboards stored in an S3-compatible bucket.
+type Store struct {
+	*core.Store
+}
+
+// NewStore creates a new moodboard collection, backed by the S3 bucket described by the specified options.
+func NewStore(opts Options) (*Store, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint: %w", err)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("endpoint must be an HTTP(S) URL: %q", opts.Endpoint)
+	}
+
+	if opts.Bucket == "" {
+		return nil, errors.New("bucket must not be empty")
+	}
+
+	c := &client{
+		http:            opts.Client,
+		endpoint:        endpoint,
+		region:          opts.Region,
+		bucket:          opts.Bucket,
+		accessKeyID:     opts.AccessKeyID,
+		secretAccessKey: opts.SecretAccessKey,
+	}
+
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+
+	return &Store{Store: core.NewStore(&backend{client: c, prefix: opts.Prefix})}, nil
+}
@@ -0,0 +1,103 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/s3"
+	"github.com/jackwilsdon/moodboard/storetest"
+	"io"
+	"testing"
+)
+
+// newStore creates a new moodboard store for testing, which signs its requests using the specified access key.
+//
+// The store is backed by a fake S3 server, which is stopped once the test and all its subtests complete.
+func newStore(t *testing.T, accessKeyID string) (*s3.Store, *server) {
+	srv, ts := newServer(t)
+
+	s, err := s3.NewStore(s3.Options{
+		Endpoint:        ts.URL,
+		Bucket:          "moodboard",
+		Prefix:          "test/",
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: "secret-key",
+		Client:          ts.Client(),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	return s, srv
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) moodboard.Store {
+		s, _ := newStore(t, "access-key")
+
+		return s
+	})
+}
+
+func TestStoreObjects(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newStore(t, "access-key")
+	boardID := storetest.NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader([]byte("image")))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	keys := make(map[string]bool)
+
+	for _, key := range srv.keys() {
+		keys[key] = true
+	}
+
+	if len(keys) != 2 || !keys["test/index.json"] || !keys["test/images/"+id] {
+		t.Fatalf("expected objects to be [%q, %q] but got %q", "test/index.json", "test/images/"+id, srv.keys())
+	}
+
+	img, err := s.GetImage(ctx, boardID, id)
+
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+
+	// Images should be streamed from the response rather than read into memory.
+	closer, ok := img.(io.ReadCloser)
+
+	if !ok {
+		t.Fatalf("expected image to be an io.ReadCloser")
+	}
+
+	_ = closer.Close()
+
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// The image is kept whilst the item is in the trash.
+	if keys := srv.keys(); len(keys) != 2 {
+		t.Fatalf("expected objects to be [%q, %q] but got %q", "test/index.json", "test/images/"+id, keys)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	if keys := srv.keys(); len(keys) != 1 || keys[0] != "test/index.json" {
+		t.Fatalf("expected objects to be [%q] but got %q", "test/index.json", keys)
+	}
+}
+
+func TestStoreAccessDenied(t *testing.T) {
+	s, _ := newStore(t, "wrong-key")
+
+	if _, err := s.Boards(context.Background()); err == nil {
+		t.Fatalf("expected error to be non-nil")
+	}
+}
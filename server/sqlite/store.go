@@ -0,0 +1,382 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/internal/core"
+
+	// Register the SQLite driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrations contains the statements needed to bring the database schema up to date.
+//
+// The database keeps track of how many of these have been run, so existing migrations must never be changed - only
+// new ones added to the end.
+var migrations = []string{
+	`CREATE TABLE boards (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);
+
+	CREATE TABLE items (
+		id TEXT PRIMARY KEY,
+		board TEXT NOT NULL REFERENCES boards (id),
+		position INTEGER NOT NULL,
+		data TEXT NOT NULL
+	);
+
+	CREATE INDEX items_board_position ON items (board, position);
+
+	CREATE TABLE images (
+		id TEXT PRIMARY KEY,
+		data BLOB NOT NULL
+	);`,
+	`ALTER TABLE items ADD COLUMN key TEXT NOT NULL DEFAULT '';
+
+	CREATE INDEX items_board_key ON items (board, key);`,
+}
+
+// tx represents a transaction against a SQLite backend.
+//
+// Records are stored as JSON, with the fields that are needed for lookups copied into their own columns.
+type tx struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+// Boards returns all board records.
+func (t *tx) Boards() ([]core.Board, error) {
+	rows, err := t.tx.QueryContext(t.ctx, "SELECT data FROM boards ORDER BY rowid")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query boards: %w", err)
+	}
+
+	defer rows.Close()
+
+	var boards []core.Board
+
+	for rows.Next() {
+		var data []byte
+		var board core.Board
+
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read board: %w", err)
+		}
+
+		if err := json.Unmarshal(data, &board); err != nil {
+			return nil, fmt.Errorf("failed to decode board: %w", err)
+		}
+
+		boards = append(boards, board)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read boards: %w", err)
+	}
+
+	return boards, nil
+}
+
+// Board returns the board record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchBoard if a board with the specified ID does not exist.
+func (t *tx) Board(id string) (core.Board, error) {
+	var data []byte
+	var board core.Board
+
+	err := t.tx.QueryRowContext(t.ctx, "SELECT data FROM boards WHERE id = ?", id).Scan(&data)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return core.Board{}, moodboard.ErrNoSuchBoard
+	} else if err != nil {
+		return core.Board{}, fmt.Errorf("failed to query board: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &board); err != nil {
+		return core.Board{}, fmt.Errorf("failed to decode board: %w", err)
+	}
+
+	return board, nil
+}
+
+// PutBoard creates or replaces a board record.
+func (t *tx) PutBoard(board core.Board) error {
+	data, err := json.Marshal(board)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode board: %w", err)
+	}
+
+	// Update the existing row rather than replacing it so that we keep its place in the insertion order.
+	_, err = t.tx.ExecContext(
+		t.ctx,
+		"INSERT INTO boards (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data",
+		board.ID,
+		data,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to store board: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBoard removes a board record.
+func (t *tx) DeleteBoard(id string) error {
+	if _, err := t.tx.ExecContext(t.ctx, "DELETE FROM boards WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete board: %w", err)
+	}
+
+	return nil
+}
+
+// Items returns all item records on the specified board.
+func (t *tx) Items(boardID string) ([]core.Item, error) {
+	rows, err := t.tx.QueryContext(t.ctx, "SELECT data FROM items WHERE board = ? ORDER BY key, position", boardID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query items: %w", err)
+	}
+
+	defer rows.Close()
+
+	var items []core.Item
+
+	for rows.Next() {
+		var data []byte
+		var item core.Item
+
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read item: %w", err)
+		}
+
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("failed to decode item: %w", err)
+		}
+
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read items: %w", err)
+	}
+
+	return items, nil
+}
+
+// Item returns the item record with the specified ID.
+//
+// This method will return moodboard.ErrNoSuchItem if an item with the specified ID does not exist.
+func (t *tx) Item(id string) (core.Item, error) {
+	var data []byte
+	var item core.Item
+
+	err := t.tx.QueryRowContext(t.ctx, "SELECT data FROM items WHERE id = ?", id).Scan(&data)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return core.Item{}, moodboard.ErrNoSuchItem
+	} else if err != nil {
+		return core.Item{}, fmt.Errorf("failed to query item: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &item); err != nil {
+		return core.Item{}, fmt.Errorf("failed to decode item: %w", err)
+	}
+
+	return item, nil
+}
+
+// PutItem creates or replaces an item record.
+func (t *tx) PutItem(item core.Item) error {
+	data, err := json.Marshal(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %w", err)
+	}
+
+	_, err = t.tx.ExecContext(
+		t.ctx,
+		"INSERT OR REPLACE INTO items (id, board, key, position, data) VALUES (?, ?, ?, ?, ?)",
+		item.ID,
+		item.Board,
+		item.Key,
+		item.Position,
+		data,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to store item: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteItem removes an item record.
+func (t *tx) DeleteItem(id string) error {
+	if _, err := t.tx.ExecContext(t.ctx, "DELETE FROM items WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	return nil
+}
+
+// CreateImage stores the image for the item with the specified ID.
+func (t *tx) CreateImage(id string, img io.Reader) error {
+	// Read the whole image into memory, as it needs to be passed to SQLite in one go.
+	buf, err := ioutil.ReadAll(img)
+
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	if _, err := t.tx.ExecContext(t.ctx, "INSERT INTO images (id, data) VALUES (?, ?)", id, buf); err != nil {
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+
+	return nil
+}
+
+// Image returns the image for the item with the specified ID.
+func (t *tx) Image(id string) (io.Reader, error) {
+	var buf []byte
+
+	err := t.tx.QueryRowContext(t.ctx, "SELECT data FROM images WHERE id = ?", id).Scan(&buf)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, moodboard.ErrNoSuchItem
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query image: %w", err)
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+// DeleteImage removes the image for the item with the specified ID.
+func (t *tx) DeleteImage(id string) error {
+	if _, err := t.tx.ExecContext(t.ctx, "DELETE FROM images WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+
+	return nil
+}
+
+// backend is a SQLite core.Backend.
+type backend struct {
+	db *sql.DB
+}
+
+// run runs fn within a transaction, only committing it if fn succeeds.
+func (b *backend) run(ctx context.Context, opts *sql.TxOptions, fn func(core.Tx) error) error {
+	t, err := b.db.BeginTx(ctx, opts)
+
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&tx{ctx: ctx, tx: t}); err != nil {
+		_ = t.Rollback()
+
+		return err
+	}
+
+	if err := t.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// View runs fn within a read-only transaction.
+func (b *backend) View(ctx context.Context, fn func(core.Tx) error) error {
+	return b.run(ctx, &sql.TxOptions{ReadOnly: true}, fn)
+}
+
+// Update runs fn within a read-write transaction.
+//
+// If fn returns an error then none of the changes made within the transaction are kept.
+func (b *backend) Update(ctx context.Context, fn func(core.Tx) error) error {
+	return b.run(ctx, nil, fn)
+}
+
+// migrate brings the database schema up to date.
+func migrate(db *sql.DB) error {
+	var version int
+
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	for ; version < len(migrations); version++ {
+		t, err := db.Begin()
+
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+
+		if _, err := t.Exec(migrations[version]); err != nil {
+			_ = t.Rollback()
+
+			return fmt.Errorf("failed to run migration %d: %w", version+1, err)
+		}
+
+		// PRAGMA statements can't use placeholders, but this is just a number.
+		if _, err := t.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			_ = t.Rollback()
+
+			return fmt.Errorf("failed to set schema version: %w", err)
+		}
+
+		if err := t.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Store represents a collection of moodboards stored in a SQLite database.
+type Store struct {
+	*core.Store
+	db *sql.DB
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// NewStore creates a new moodboard collection, backed by the SQLite database at the specified path.
+//
+// The database is created if it does not already exist. Once the store is no longer needed it should be closed.
+func NewStore(path string) (*Store, error) {
+	// The path is part of a URI, so anything which would end it early (or be decoded) needs escaping.
+	escaped := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)
+	db, err := sql.Open("sqlite3", "file:"+escaped+"?_foreign_keys=1&_busy_timeout=5000")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite only supports a single writer, so we avoid lock contention by only ever using a single connection.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return &Store{Store: core.NewStore(&backend{db: db}), db: db}, nil
+}
@@ -0,0 +1,61 @@
+package sqlite_test
+
+import (
+	"context"
+	"github.com/jackwilsdon/moodboard"
+	"github.com/jackwilsdon/moodboard/sqlite"
+	"github.com/jackwilsdon/moodboard/storetest"
+	"os"
+	"path"
+	"testing"
+)
+
+// newStore creates a new moodboard store for testing.
+//
+// A temporary directory is used to hold the database, which is cleaned up once the test and all its subtests complete.
+func newStore(t *testing.T) *sqlite.Store {
+	dir := storetest.TempDir(t)
+
+	s, err := sqlite.NewStore(path.Join(dir, "moodboard.db"))
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// Close the database before the directory is deleted.
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) moodboard.Store {
+		return newStore(t)
+	})
+}
+
+func TestStorePath(t *testing.T) {
+	dir := storetest.TempDir(t)
+
+	// Anything which means something in a URI should be treated as part of the path.
+	p := path.Join(dir, "mood?board#1%20.db")
+	s, err := sqlite.NewStore(p)
+
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	if _, err := s.CreateBoard(context.Background(), "test"); err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("expected database to exist but got %v", err)
+	}
+}
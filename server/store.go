@@ -1,40 +1,431 @@
 package moodboard
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 )
 
+// ErrNoSuchBoard indicates that a board does not exist.
+var ErrNoSuchBoard = errors.New("no such board")
+
 // ErrNoSuchItem indicates that an item does not exist.
 var ErrNoSuchItem = errors.New("no such item")
 
-// Store represents a collection of moodboard items.
+// ErrIndexOutOfRange indicates that an index is not within a board.
+var ErrIndexOutOfRange = errors.New("index out of range")
+
+// ErrOrderMismatch indicates that an order does not contain exactly the items on a board.
+var ErrOrderMismatch = errors.New("order does not match the items on the board")
+
+// ErrDuplicateItem indicates that an item appears more than once in a batch.
+var ErrDuplicateItem = errors.New("item appears more than once")
+
+// ErrTargetInBatch indicates that the target of a batch move is one of the items being moved.
+var ErrTargetInBatch = errors.New("target is being moved")
+
+// ErrNothingToUndo indicates that there are no operations on a board which can be undone.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrNothingToRedo indicates that there are no undone operations on a board which can be redone.
+var ErrNothingToRedo = errors.New("nothing to redo")
+
+// ErrQuotaExceeded indicates that storing an image would take a board or the whole collection over its quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrUnsupported indicates that a store can't carry out an operation.
+var ErrUnsupported = errors.New("operation not supported")
+
+// ErrInvalidCursor indicates that a cursor was not returned by an earlier call to Store.Page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// BatchError indicates that a batch operation could not be applied, as some of the items in the batch have problems.
+//
+// Batch operations are all-or-nothing, so nothing is changed when a BatchError is returned.
+type BatchError struct {
+	// Errors contains the problem with each item, keyed by ID.
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+
+	// Keep the message consistent.
+	sort.Strings(ids)
+
+	problems := make([]string, len(ids))
+
+	for i, id := range ids {
+		problems[i] = fmt.Sprintf("%s: %v", id, e.Errors[id])
+	}
+
+	return fmt.Sprintf("failed to apply batch: %s", strings.Join(problems, ", "))
+}
+
+// DuplicateError indicates that an image was not stored, as it is a near-duplicate of images which are already on the
+// board.
+type DuplicateError struct {
+	// IDs contains the IDs of the items which the image is a near-duplicate of, most similar first.
+	IDs []string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("image is a near-duplicate of %s", strings.Join(e.IDs, ", "))
+}
+
+// Board represents a named collection of moodboard items.
+type Board struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Item represents a single moodboard item.
+//
+// Width and Height contain the dimensions of the image in pixels, and AspectRatio contains the width divided by the
+// height. They are all zero if the dimensions of the image are unknown, which is always the case for WebPs, AVIFs and
+// SVGs as they can't be decoded.
+//
+// Palette contains the dominant colours of the image, most dominant first, and Hash contains a perceptual hash of the
+// image as 16 hex digits. Images which look alike have hashes which only differ by a few bits. They are both empty if
+// the image couldn't be analysed, which includes WebPs, AVIFs and SVGs.
+type Item struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Caption     string    `json:"caption"`
+	Source      string    `json:"source"`
+	Tags        []string  `json:"tags,omitempty"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	AspectRatio float64   `json:"aspectRatio"`
+	Palette     []Colour  `json:"palette,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Colour represents one of the dominant colours of an image or board.
+type Colour struct {
+	// Hex is the colour in CSS hex notation (e.g. "#ff8000").
+	Hex string `json:"hex"`
+
+	// Weight is the share of the image or board covered by the colour, between 0 and 1.
+	Weight float64 `json:"weight"`
+}
+
+// TrashedItem represents a moodboard item which has been moved to the trash.
+type TrashedItem struct {
+	Item
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// PageOptions describes which moodboard items should be returned by Store.Page.
+type PageOptions struct {
+	// Cursor is the NextCursor of the previous page, or an empty string to start from the beginning of the board.
+	Cursor string
+
+	// Limit is the maximum number of items in the page. Limits of zero or less return all of the remaining items.
+	Limit int
+
+	// Filter decides whether an item should be included in the page. A nil filter includes every item.
+	Filter func(Item) bool
+}
+
+// Page represents a page of moodboard items.
+type Page struct {
+	Items []Item `json:"items"`
+
+	// NextCursor can be passed back to Store.Page to get the next page. It is empty when there are no more items.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Quota limits the number of bytes of images which can be stored. Limits of zero mean that there is no limit.
+type Quota struct {
+	// Board is the limit for each board.
+	Board int64 `json:"board"`
+
+	// Total is the limit for the whole collection.
+	Total int64 `json:"total"`
+}
+
+// Usage represents the number of bytes of images stored on a board and in the whole collection.
+//
+// Images (and their thumbnails) count towards usage until they are purged, even if their item is in the trash.
+type Usage struct {
+	Board int64 `json:"board"`
+	Total int64 `json:"total"`
+	Quota Quota `json:"quota"`
+}
+
+// DefaultDuplicateDistance is the distance used to find near-duplicates by stores which haven't been given a
+// DuplicatePolicy.
+const DefaultDuplicateDistance = 10
+
+// DuplicatePolicy describes how near-duplicate images are found, and what happens when one is uploaded.
+type DuplicatePolicy struct {
+	// Distance is the largest number of bits that the hashes of two images can differ by for them to be near-duplicates.
+	Distance int
+
+	// Reject stops images from being stored if they are near-duplicates of images which are already on the board.
+	Reject bool
+}
+
+// DefaultMaxDecodePixels is the largest number of pixels an image can have for it to be analysed by stores which
+// haven't been given DecodeLimits.
+//
+// Decoding an image needs a few bytes per pixel, so this stops a small file with a huge size in its header from using
+// up all of our memory.
+const DefaultMaxDecodePixels = 40000000
+
+// DefaultConcurrentDecodes is the number of images which can be decoded at once by stores which haven't been given
+// DecodeLimits.
+const DefaultConcurrentDecodes = 2
+
+// DecodeLimits limits how much memory can be used decoding images to analyse them. Limits of zero mean that there is no
+// limit.
+type DecodeLimits struct {
+	// MaxPixels is the largest number of pixels an image can have for it to be decoded. Larger images are still stored,
+	// they just aren't analysed.
+	MaxPixels int
+
+	// Concurrent is the largest number of images which can be decoded at once. Other images wait for one of them to
+	// finish.
+	Concurrent int
+}
+
+// ItemUpdate represents a change to the metadata of a moodboard item.
+//
+// Fields which are nil are left unchanged.
+type ItemUpdate struct {
+	Title   *string `json:"title"`
+	Caption *string `json:"caption"`
+	Source  *string `json:"source"`
+}
+
+// Store represents a collection of moodboards.
+//
+// Every method takes a context, which can be used to cancel the operation or to pass a deadline through to the
+// underlying storage. Once a context is done the method should stop as soon as possible, returning the context's
+// error.
 type Store interface {
-	// Create creates a new moodboard item in the collection.
-	Create(io.Reader) (string, error)
+	// CreateBoard creates a new, empty board with the specified name.
+	CreateBoard(ctx context.Context, name string) (Board, error)
+
+	// Boards returns all boards in the collection, in the order that they were created.
+	Boards(ctx context.Context) ([]Board, error)
+
+	// RenameBoard changes the name of a board in the collection.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	RenameBoard(ctx context.Context, id, name string) error
 
-	// All returns all moodboard items in the collection.
-	All() ([]string, error)
+	// DeleteBoard removes a board and all of its items from the collection.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	DeleteBoard(ctx context.Context, id string) error
+
+	// Create creates a new moodboard item on a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrQuotaExceeded if
+	// the image would take the board or the collection over its quota. Stores which reject near-duplicate images return
+	// a *DuplicateError for them.
+	Create(ctx context.Context, boardID string, img io.Reader) (string, error)
+
+	// All returns all moodboard items on a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	All(ctx context.Context, boardID string) ([]Item, error)
+
+	// Page returns a page of moodboard items on a board, in the order that they appear on the board.
+	//
+	// Cursors refer to the last item of the previous page rather than an index, so the next page carries on from
+	// wherever that item is now. Adding items or reordering the board whilst paging does not cause the rest of the
+	// board to be skipped or repeated.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrInvalidCursor if
+	// the cursor is not valid.
+	Page(ctx context.Context, boardID string, opts PageOptions) (Page, error)
+
+	// Update changes the metadata of a moodboard item on a board, returning the updated item.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	Update(ctx context.Context, boardID, id string, update ItemUpdate) (Item, error)
+
+	// AddTag attaches a tag to a moodboard item on a board, returning the updated item.
+	//
+	// Adding a tag which is already attached to the item does nothing.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	AddTag(ctx context.Context, boardID, id, tag string) (Item, error)
+
+	// RemoveTag removes a tag from a moodboard item on a board, returning the updated item.
+	//
+	// Removing a tag which is not attached to the item does nothing.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	RemoveTag(ctx context.Context, boardID, id, tag string) (Item, error)
+
+	// GetImage returns the image for the specified moodboard item on a board.
+	//
+	// Note that the reader returned by this method may be an io.ReadCloser. Images for items in the trash can still be
+	// fetched, so that the trash can be shown.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	GetImage(ctx context.Context, boardID, id string) (io.Reader, error)
+
+	// GetThumbnail returns the smallest thumbnail of a moodboard item on a board which is at least size pixels along
+	// its long edge, or the original image if there isn't a thumbnail which is big enough.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	GetThumbnail(ctx context.Context, boardID, id string, size int) (io.Reader, error)
+
+	// MoveBefore moves a moodboard item before another one on a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// items with either of the specified IDs do not exist on the board.
+	MoveBefore(ctx context.Context, boardID, id, beforeID string) error
+
+	// MoveAfter moves a moodboard item after another one on a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// items with either of the specified IDs do not exist on the board.
+	MoveAfter(ctx context.Context, boardID, id, afterID string) error
+
+	// MoveToIndex moves a moodboard item to the specified index on a board, counting from 0.
+	//
+	// Items in the trash are not included when counting.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, ErrNoSuchItem if an
+	// item with the specified ID does not exist on the board, and an error wrapping ErrIndexOutOfRange if the index is
+	// not on the board.
+	MoveToIndex(ctx context.Context, boardID, id string, index int) error
+
+	// MoveToStart moves a moodboard item to the start of a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	MoveToStart(ctx context.Context, boardID, id string) error
+
+	// MoveToEnd moves a moodboard item to the end of a board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	MoveToEnd(ctx context.Context, boardID, id string) error
+
+	// Reorder puts the moodboard items on a board into the specified order.
+	//
+	// The IDs must contain every item on the board (ignoring the items in the trash) exactly once. The whole order is
+	// applied at once, so either all of the items are moved or none of them are.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrOrderMismatch if
+	// the IDs are not exactly the items on the board.
+	Reorder(ctx context.Context, boardID string, ids []string) error
 
-	// GetImage returns the image for the specified moodboard item in the collection.
+	// Delete moves a moodboard item on a board to the trash.
 	//
-	// Note that the reader returned by this method may be an io.ReadCloser.
+	// Items in the trash are not returned by All, and can only be restored or purged.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID does not exist on the board.
+	Delete(ctx context.Context, boardID, id string) error
+
+	// DeleteMany moves several moodboard items on a board to the trash.
 	//
-	// This method will return ErrNoSuchItem if an item with the specified ID does not exist.
-	GetImage(id string) (io.Reader, error)
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and a *BatchError if any
+	// of the IDs do not exist on the board or appear more than once. Either all of the items are moved to the trash, or
+	// none of them are.
+	DeleteMany(ctx context.Context, boardID string, ids []string) error
 
-	// MoveBefore moves a moodboard item before another one in the collection.
+	// MoveManyBefore moves several moodboard items before another one on a board.
+	//
+	// The items end up next to each other, in the same order as they were on the board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and a *BatchError if any
+	// of the IDs (including the target) do not exist on the board, appear more than once, or if the target is one of
+	// the items being moved. Either all of the items are moved, or none of them are.
+	MoveManyBefore(ctx context.Context, boardID string, ids []string, beforeID string) error
+
+	// MoveManyAfter moves several moodboard items after another one on a board.
+	//
+	// The items end up next to each other, in the same order as they were on the board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and a *BatchError if any
+	// of the IDs (including the target) do not exist on the board, appear more than once, or if the target is one of
+	// the items being moved. Either all of the items are moved, or none of them are.
+	MoveManyAfter(ctx context.Context, boardID string, ids []string, afterID string) error
+
+	// Trash returns all moodboard items in the trash for a board, most recently deleted first.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	Trash(ctx context.Context, boardID string) ([]TrashedItem, error)
+
+	// Restore moves a moodboard item out of the trash, putting it back where it was on the board.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID is not in the trash for the board.
+	Restore(ctx context.Context, boardID, id string) error
+
+	// Purge permanently removes a moodboard item from the trash.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if
+	// an item with the specified ID is not in the trash for the board.
+	Purge(ctx context.Context, boardID, id string) error
+
+	// Undo reverses the most recent operation on a board.
+	//
+	// Creating, moving, deleting, restoring and editing items can all be undone. Undoing the creation of an item moves
+	// it to the trash.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNothingToUndo if
+	// there are no operations to undo.
+	Undo(ctx context.Context, boardID string) error
+
+	// Redo repeats the most recently undone operation on a board.
+	//
+	// Any new operation on the board stops undone operations from being redone.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNothingToRedo if
+	// there are no operations to redo.
+	Redo(ctx context.Context, boardID string) error
+
+	// Usage returns the number of bytes of images stored on a board and in the whole collection, along with the quota
+	// that they count against.
+	//
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	Usage(ctx context.Context, boardID string) (Usage, error)
+
+	// Palette returns the dominant colours across all of the moodboard items on a board, most dominant first.
+	//
+	// Each item counts equally, regardless of the size of its image. Items in the trash are ignored.
 	//
-	// This method will return ErrNoSuchItem if items with either of the specified IDs do not exist.
-	MoveBefore(id, beforeID string) error
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	Palette(ctx context.Context, boardID string) ([]Colour, error)
 
-	// MoveAfter moves a moodboard item after another one in the collection.
+	// Similar returns the moodboard items on a board whose images are near-duplicates of the image of the specified
+	// item, most similar first. Items in the trash are ignored.
 	//
-	// This method will return ErrNoSuchItem if items with either of the specified IDs do not exist.
-	MoveAfter(id, afterID string) error
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist, and ErrNoSuchItem if an
+	// item with the specified ID does not exist on the board.
+	Similar(ctx context.Context, boardID, id string) ([]Item, error)
 
-	// Delete removes a moodboard item from the collection.
+	// Duplicates returns groups of moodboard items on a board whose images are near-duplicates of each other, in the
+	// order that they appear on the board. Items which aren't near-duplicates of any other items are left out, as are
+	// items in the trash.
 	//
-	// This method will return ErrNoSuchItem if an item with the specified ID does not exist.
-	Delete(id string) error
+	// This method will return ErrNoSuchBoard if a board with the specified ID does not exist.
+	Duplicates(ctx context.Context, boardID string) ([][]Item, error)
 }
@@ -0,0 +1,2745 @@
+// Package storetest provides a suite of tests which checks that a moodboard.Store behaves correctly.
+//
+// The suite is run against every store in this module, and can be used to check that other implementations are
+// compatible with them:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) moodboard.Store {
+//			return mystore.NewStore()
+//		})
+//	}
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackwilsdon/moodboard"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// NewStoreFunc creates a new, empty store for a test.
+//
+// Any resources used by the store should be cleaned up using t.Cleanup.
+type NewStoreFunc func(t *testing.T) moodboard.Store
+
+// Run runs the whole suite against the stores created by newStore, with each test in a subtest of its own.
+//
+// Every test creates a new store, so tests don't affect each other.
+func Run(t *testing.T, newStore NewStoreFunc) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, NewStoreFunc)
+	}{
+		{name: "Create", fn: testCreate},
+		{name: "Update", fn: testUpdate},
+		{name: "Tags", fn: testTags},
+		{name: "GetImage", fn: testGetImage},
+		{name: "MoveBefore", fn: testMoveBefore},
+		{name: "MoveAfter", fn: testMoveAfter},
+		{name: "MoveToIndex", fn: testMoveToIndex},
+		{name: "MoveToStartAndEnd", fn: testMoveToStartAndEnd},
+		{name: "Reorder", fn: testReorder},
+		{name: "ReorderTrash", fn: testReorderTrash},
+		{name: "Delete", fn: testDelete},
+		{name: "Trash", fn: testTrash},
+		{name: "RestoreAfterMove", fn: testRestoreAfterMove},
+		{name: "Purge", fn: testPurge},
+		{name: "Undo", fn: testUndo},
+		{name: "UndoPurged", fn: testUndoPurged},
+		{name: "DeleteMany", fn: testDeleteMany},
+		{name: "MoveMany", fn: testMoveMany},
+		{name: "Page", fn: testPage},
+		{name: "PageReorder", fn: testPageReorder},
+		{name: "Cancel", fn: testCancel},
+		{name: "Boards", fn: testBoards},
+		{name: "RenameBoard", fn: testRenameBoard},
+		{name: "DeleteBoard", fn: testDeleteBoard},
+		{name: "BoardIsolation", fn: testBoardIsolation},
+		{name: "NoSuchItem", fn: testNoSuchItem},
+		{name: "Concurrency", fn: testConcurrency},
+		{name: "LargeImage", fn: testLargeImage},
+		{name: "Usage", fn: testUsage},
+		{name: "Thumbnails", fn: testThumbnails},
+		{name: "Dimensions", fn: testDimensions},
+		{name: "Palette", fn: testPalette},
+		{name: "Duplicates", fn: testDuplicates},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStore)
+		})
+	}
+}
+
+// TempDir creates a temporary directory for a test, which is removed once the test and all its subtests complete.
+func TempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "")
+
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+
+	// Delete the directory at the end of the test.
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+// NewBoard creates a new board in a store for a test, returning its ID.
+func NewBoard(t *testing.T, s moodboard.Store) string {
+	t.Helper()
+
+	board, err := s.CreateBoard(context.Background(), "test")
+
+	if err != nil {
+		t.Fatalf("failed to create board: %v", err)
+	}
+
+	return board.ID
+}
+
+func testCreate(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	firstID, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	secondID, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 {
+		t.Fatalf("expected to get 2 items but got %d", len(all))
+	}
+
+	if all[0].ID != firstID || all[1].ID != secondID {
+		t.Fatalf("expected all to be [%q, %q] but got [%q, %q]", firstID, secondID, all[0].ID, all[1].ID)
+	}
+}
+
+func testUpdate(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	created := all[0]
+
+	if created.CreatedAt.IsZero() || !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("expected timestamps to be set on creation but got %v and %v", created.CreatedAt, created.UpdatedAt)
+	}
+
+	title := "title"
+	caption := "caption"
+
+	if _, err := s.Update(ctx, boardID, id, moodboard.ItemUpdate{Title: &title, Caption: &caption}); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	// Only update the title, leaving the caption as it was.
+	title = "new title"
+
+	item, err := s.Update(ctx, boardID, id, moodboard.ItemUpdate{Title: &title})
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if item.Title != title || item.Caption != caption || item.Source != "" {
+		t.Fatalf("expected item to be [%q, %q, %q] but got [%q, %q, %q]", title, caption, "", item.Title, item.Caption, item.Source)
+	}
+
+	if !item.CreatedAt.Equal(created.CreatedAt) || item.UpdatedAt.Before(created.UpdatedAt) {
+		t.Fatalf("expected only the update time to change but got %v and %v", item.CreatedAt, item.UpdatedAt)
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(all[0], item) {
+		t.Fatalf("expected all to be [%v] but got %v", item, all)
+	}
+
+	if _, err := s.Update(ctx, boardID, "nonexistent", moodboard.ItemUpdate{Title: &title}); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+}
+
+func testTags(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	cs := []struct {
+		name   string
+		add    bool
+		tag    string
+		expect []string
+	}{
+		{
+			name:   "add",
+			add:    true,
+			tag:    "palette",
+			expect: []string{"palette"},
+		},
+		{
+			name:   "add existing",
+			add:    true,
+			tag:    "palette",
+			expect: []string{"palette"},
+		},
+		{
+			name:   "add second",
+			add:    true,
+			tag:    "lighting",
+			expect: []string{"palette", "lighting"},
+		},
+		{
+			name:   "remove",
+			tag:    "palette",
+			expect: []string{"lighting"},
+		},
+		{
+			name:   "remove nonexistent",
+			tag:    "palette",
+			expect: []string{"lighting"},
+		},
+	}
+
+	// Each case builds on the previous one, so they can't be run in isolation.
+	for _, c := range cs {
+		var item moodboard.Item
+		var err error
+
+		if c.add {
+			item, err = s.AddTag(ctx, boardID, id, c.tag)
+		} else {
+			item, err = s.RemoveTag(ctx, boardID, id, c.tag)
+		}
+
+		if err != nil {
+			t.Fatalf("%s: expected error to be nil but got %q", c.name, err)
+		}
+
+		all, err := s.All(ctx, boardID)
+
+		if err != nil {
+			t.Fatalf("%s: failed to get store contents: %v", c.name, err)
+		}
+
+		for _, tags := range [][]string{item.Tags, all[0].Tags} {
+			if len(tags) != len(c.expect) {
+				t.Fatalf("%s: expected tags to be %q but got %q", c.name, c.expect, tags)
+			}
+
+			for i := range tags {
+				if tags[i] != c.expect[i] {
+					t.Fatalf("%s: expected tags to be %q but got %q", c.name, c.expect, tags)
+				}
+			}
+		}
+	}
+
+	if _, err := s.AddTag(ctx, boardID, "nonexistent", "palette"); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := s.RemoveTag(ctx, "nonexistent", id, "palette"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testGetImage(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		create int
+		get    int
+		err    error
+	}{
+		{
+			name:   "get",
+			create: 3,
+			get:    0,
+			err:    nil,
+		},
+		{
+			name:   "get nonexistent",
+			create: 0,
+			get:    -1,
+			err:    moodboard.ErrNoSuchItem,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+
+			var targetID string
+			var expectedImg []byte
+
+			for i := 0; i < c.create; i++ {
+				img := []byte(fmt.Sprintf("image %d", i))
+				id, err := s.Create(ctx, boardID, bytes.NewReader(img))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				if i == c.get {
+					targetID = id
+					expectedImg = img
+				}
+			}
+
+			img, err := s.GetImage(ctx, boardID, targetID)
+
+			switch {
+			case err != nil && c.err == nil:
+				t.Fatalf("expected error to be nil but got %q", err)
+			case err == nil && c.err != nil:
+				t.Fatalf("expected error to be %q but got nil", c.err)
+			case err != c.err:
+				t.Fatalf("expected error to be %q but got %q", c.err, err)
+			}
+
+			if err == nil {
+				imgBytes, err := ioutil.ReadAll(img)
+
+				if err != nil {
+					t.Fatalf("failed to read returned image: %v", err)
+				}
+
+				if !bytes.Equal(imgBytes, expectedImg) {
+					t.Fatalf("expected returned reader to read %q but got %q", expectedImg, imgBytes)
+				}
+			}
+		})
+	}
+}
+
+func testMoveBefore(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		index  int
+		before int
+		order  []int
+		err    error
+	}{
+		{
+			name:   "move first before first",
+			index:  0,
+			before: 0,
+			order:  []int{0, 1, 2},
+		},
+		{
+			name:   "move first before second",
+			index:  0,
+			before: 1,
+			order:  []int{0, 1, 2},
+		},
+		{
+			name:   "move first before third",
+			index:  0,
+			before: 2,
+			order:  []int{1, 0, 2},
+		},
+		{
+			name:   "move second before first",
+			index:  1,
+			before: 0,
+			order:  []int{1, 0, 2},
+		},
+		{
+			name:   "move second before second",
+			index:  1,
+			before: 1,
+			order:  []int{0, 1, 2},
+		},
+		{
+			name:   "move second before third",
+			index:  1,
+			before: 2,
+			order:  []int{0, 1, 2},
+		},
+		{
+			name:   "move third before first",
+			index:  2,
+			before: 0,
+			order:  []int{2, 0, 1},
+		},
+		{
+			name:   "move third before second",
+			index:  2,
+			before: 1,
+			order:  []int{0, 2, 1},
+		},
+		{
+			name:   "move third before third",
+			index:  2,
+			before: 2,
+			order:  []int{0, 1, 2},
+		},
+		{
+			name:   "move non-existent before first",
+			index:  -1,
+			before: 0,
+			order:  []int{0, 1, 2},
+			err:    moodboard.ErrNoSuchItem,
+		},
+		{
+			name:   "move first before non-existent",
+			index:  0,
+			before: -1,
+			order:  []int{0, 1, 2},
+			err:    moodboard.ErrNoSuchItem,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+
+			var targetID string
+			var beforeID string
+
+			indexes := make(map[string]int)
+
+			for i := 0; i < len(c.order); i++ {
+				id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				if c.index == i {
+					targetID = id
+				}
+
+				if c.before == i {
+					beforeID = id
+				}
+
+				indexes[id] = i
+			}
+
+			switch err := s.MoveBefore(ctx, boardID, targetID, beforeID); {
+			case err != nil && c.err == nil:
+				t.Fatalf("expected error to be nil but got %q", err)
+			case err == nil && c.err != nil:
+				t.Fatalf("expected error to be %q but got nil", c.err)
+			case err != c.err:
+				t.Fatalf("expected error to be %q but got %q", c.err, err)
+			}
+
+			all, err := s.All(ctx, boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			if len(all) != len(c.order) {
+				verb := "items"
+
+				if len(c.order) == 1 {
+					verb = "item"
+				}
+
+				t.Fatalf("expected to get %d %s but got %d", len(c.order), verb, len(all))
+			}
+
+			for i, order := range c.order {
+				id := all[i].ID
+				index, ok := indexes[id]
+
+				if !ok {
+					t.Fatalf("no initial index for %q", id)
+				}
+
+				if index != order {
+					t.Errorf("expected %q to be at index %d but got %d", id, order, i)
+				}
+			}
+		})
+	}
+}
+
+func testMoveAfter(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name  string
+		index int
+		after int
+		order []int
+		err   error
+	}{
+		{
+			name:  "move first after first",
+			index: 0,
+			after: 0,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move first after second",
+			index: 0,
+			after: 1,
+			order: []int{1, 0, 2},
+		},
+		{
+			name:  "move first after third",
+			index: 0,
+			after: 2,
+			order: []int{1, 2, 0},
+		},
+		{
+			name:  "move second after first",
+			index: 1,
+			after: 0,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move second after second",
+			index: 1,
+			after: 1,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move second after third",
+			index: 1,
+			after: 2,
+			order: []int{0, 2, 1},
+		},
+		{
+			name:  "move third after first",
+			index: 2,
+			after: 0,
+			order: []int{0, 2, 1},
+		},
+		{
+			name:  "move third after second",
+			index: 2,
+			after: 1,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move third after third",
+			index: 2,
+			after: 2,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move non-existent after first",
+			index: -1,
+			after: 0,
+			order: []int{0, 1, 2},
+			err:   moodboard.ErrNoSuchItem,
+		},
+		{
+			name:  "move first after non-existent",
+			index: 0,
+			after: -1,
+			order: []int{0, 1, 2},
+			err:   moodboard.ErrNoSuchItem,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+
+			var targetID string
+			var afterID string
+
+			indexes := make(map[string]int)
+
+			for i := 0; i < len(c.order); i++ {
+				id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				if c.index == i {
+					targetID = id
+				}
+
+				if c.after == i {
+					afterID = id
+				}
+
+				indexes[id] = i
+			}
+
+			switch err := s.MoveAfter(ctx, boardID, targetID, afterID); {
+			case err != nil && c.err == nil:
+				t.Fatalf("expected error to be nil but got %q", err)
+			case err == nil && c.err != nil:
+				t.Fatalf("expected error to be %q but got nil", c.err)
+			case err != c.err:
+				t.Fatalf("expected error to be %q but got %q", c.err, err)
+			}
+
+			all, err := s.All(ctx, boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			if len(all) != len(c.order) {
+				verb := "items"
+
+				if len(c.order) == 1 {
+					verb = "item"
+				}
+
+				t.Fatalf("expected to get %d %s but got %d", len(c.order), verb, len(all))
+			}
+
+			for i, order := range c.order {
+				id := all[i].ID
+				index, ok := indexes[id]
+
+				if !ok {
+					t.Fatalf("no initial index for %q", id)
+				}
+
+				if index != order {
+					t.Errorf("expected %q to be at index %d but got %d", id, order, i)
+				}
+			}
+		})
+	}
+}
+
+func testMoveToIndex(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name  string
+		index int
+		to    int
+		order []int
+		err   error
+	}{
+		{
+			name:  "move first to 0",
+			index: 0,
+			to:    0,
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "move first to 2",
+			index: 0,
+			to:    2,
+			order: []int{1, 2, 0},
+		},
+		{
+			name:  "move third to 1",
+			index: 2,
+			to:    1,
+			order: []int{0, 2, 1},
+		},
+		{
+			name:  "move second to 0",
+			index: 1,
+			to:    0,
+			order: []int{1, 0, 2},
+		},
+		{
+			name:  "move first to -1",
+			index: 0,
+			to:    -1,
+			order: []int{0, 1, 2},
+			err:   moodboard.ErrIndexOutOfRange,
+		},
+		{
+			name:  "move first to 3",
+			index: 0,
+			to:    3,
+			order: []int{0, 1, 2},
+			err:   moodboard.ErrIndexOutOfRange,
+		},
+		{
+			name:  "move non-existent to 0",
+			index: -1,
+			to:    0,
+			order: []int{0, 1, 2},
+			err:   moodboard.ErrNoSuchItem,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+			items := make([]string, 3)
+
+			for i := range items {
+				id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				items[i] = id
+			}
+
+			id := "nonexistent"
+
+			if c.index != -1 {
+				id = items[c.index]
+			}
+
+			if err := s.MoveToIndex(ctx, boardID, id, c.to); !errors.Is(err, c.err) {
+				t.Fatalf("expected error to be %v but got %v", c.err, err)
+			}
+
+			all, err := s.All(ctx, boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			expected := make([]string, len(c.order))
+
+			for i, index := range c.order {
+				expected[i] = items[index]
+			}
+
+			if !reflect.DeepEqual(ids(all), expected) {
+				t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+			}
+		})
+	}
+}
+
+func testMoveToStartAndEnd(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 3)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	if err := s.MoveToStart(ctx, boardID, items[2]); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if err := s.MoveToEnd(ctx, boardID, items[0]); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[2], items[1], items[0]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+
+	if err := s.MoveToEnd(ctx, boardID, "nonexistent"); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.MoveToStart(ctx, "nonexistent", items[0]); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testReorder(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 4)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	order := []string{items[3], items[1], items[0], items[2]}
+
+	if err := s.Reorder(ctx, boardID, order); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), order) {
+		t.Fatalf("expected all to be %q but got %q", order, ids(all))
+	}
+
+	// Orders which aren't exactly the items on the board should be rejected without changing anything.
+	mismatches := map[string][]string{
+		"missing":   {items[3], items[1], items[0]},
+		"extra":     {items[3], items[1], items[0], items[2], "nonexistent"},
+		"duplicate": {items[3], items[1], items[0], items[0]},
+		"unknown":   {items[3], items[1], items[0], "nonexistent"},
+		"empty":     {},
+	}
+
+	for name, mismatch := range mismatches {
+		if err := s.Reorder(ctx, boardID, mismatch); err != moodboard.ErrOrderMismatch {
+			t.Fatalf("expected error for %s order to be %q but got %q", name, moodboard.ErrOrderMismatch, err)
+		}
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), order) {
+		t.Fatalf("expected all to be %q but got %q", order, ids(all))
+	}
+
+	// Reordering can be undone in one go.
+	if err := s.Undo(ctx, boardID); err != nil {
+		t.Fatalf("failed to undo reorder: %v", err)
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), items) {
+		t.Fatalf("expected all to be %q but got %q", items, ids(all))
+	}
+
+	if err := s.Reorder(ctx, "nonexistent", nil); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testReorderTrash(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 3)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	if err := s.Delete(ctx, boardID, items[1]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// Items in the trash aren't part of the order.
+	if err := s.Reorder(ctx, boardID, []string{items[2], items[0]}); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if err := s.Restore(ctx, boardID, items[1]); err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[2], items[1], items[0]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+}
+
+func testDelete(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		create int
+		delete int
+		err    error
+	}{
+		{
+			name:   "delete",
+			create: 3,
+			delete: 0,
+		},
+		{
+			name:   "delete nonexistent",
+			create: 1,
+			delete: -1,
+			err:    moodboard.ErrNoSuchItem,
+		}, {
+			name:   "delete empty",
+			delete: -1,
+			err:    moodboard.ErrNoSuchItem,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+			items := make([]string, c.create)
+
+			for i := 0; i < c.create; i++ {
+				id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				items[i] = id
+			}
+
+			var id string
+
+			if c.delete != -1 {
+				id = items[c.delete]
+
+				// Move all items after the deleted one left.
+				copy(items[c.delete:], items[c.delete+1:])
+
+				// Remove the last (now duplicated) element.
+				items = items[:len(items)-1]
+			}
+
+			switch err := s.Delete(ctx, boardID, id); {
+			case err != nil && c.err == nil:
+				t.Fatalf("expected error to be nil but got %q", err)
+			case err == nil && c.err != nil:
+				t.Fatalf("expected error to be %q but got nil", c.err)
+			case err != c.err:
+				t.Fatalf("expected error to be %q but got %q", c.err, err)
+			}
+
+			all, err := s.All(ctx, boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			if len(all) != len(items) {
+				verb := "items"
+
+				if len(items) == 1 {
+					verb = "item"
+				}
+
+				t.Fatalf("expected to get %d %s but got %d", len(items), verb, len(all))
+			}
+
+			for i := range all {
+				if all[i].ID != items[i] {
+					t.Errorf("expected all[%d] to be %v but got %v", i, items[i], all[i].ID)
+				}
+			}
+		})
+	}
+}
+
+// ids returns the IDs of the specified items.
+func ids(items []moodboard.Item) []string {
+	ids := make([]string, len(items))
+
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	return ids
+}
+
+func testTrash(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 4)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader([]byte(fmt.Sprintf("image %d", i))))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	for _, id := range []string{items[1], items[3]} {
+		if err := s.Delete(ctx, boardID, id); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+	}
+
+	trash, err := s.Trash(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	// The most recently deleted item comes first.
+	if len(trash) != 2 || trash[0].ID != items[3] || trash[1].ID != items[1] {
+		t.Fatalf("expected trash to be [%q %q] but got %v", items[3], items[1], trash)
+	}
+
+	if trash[0].DeletedAt.IsZero() {
+		t.Fatalf("expected deletion time to be set but got %v", trash[0].DeletedAt)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[0], items[2]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+
+	// Items in the trash can't be changed.
+	if _, err := s.Update(ctx, boardID, items[1], moodboard.ItemUpdate{}); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.Delete(ctx, boardID, items[1]); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	// Their images are still available though.
+	img, err := s.GetImage(ctx, boardID, items[1])
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	buf, err := ioutil.ReadAll(img)
+
+	if closer, ok := img.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+
+	if string(buf) != "image 1" {
+		t.Fatalf("expected image to be %q but got %q", "image 1", buf)
+	}
+
+	// Restored items go back where they were.
+	for _, id := range []string{items[1], items[3]} {
+		if err := s.Restore(ctx, boardID, id); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err)
+		}
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), items) {
+		t.Fatalf("expected all to be %q but got %q", items, ids(all))
+	}
+
+	if trash, err := s.Trash(ctx, boardID); err != nil || len(trash) != 0 {
+		t.Fatalf("expected result to be [[], nil] but got [%v, %q]", trash, err)
+	}
+
+	// Items which aren't in the trash can't be restored.
+	if err := s.Restore(ctx, boardID, items[0]); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := s.Trash(ctx, "nonexistent"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testRestoreAfterMove(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 4)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	if err := s.Delete(ctx, boardID, items[1]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// Moving the other items around shouldn't stop the deleted item from going back in between the items it was
+	// between before.
+	if err := s.MoveBefore(ctx, boardID, items[3], items[0]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if err := s.Restore(ctx, boardID, items[1]); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[3], items[0], items[1], items[2]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+}
+
+func testPurge(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	// Items need to be in the trash before they can be purged.
+	if err := s.Purge(ctx, boardID, id); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if trash, err := s.Trash(ctx, boardID); err != nil || len(trash) != 0 {
+		t.Fatalf("expected result to be [[], nil] but got [%v, %q]", trash, err)
+	}
+
+	if _, err := s.GetImage(ctx, boardID, id); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.Restore(ctx, boardID, id); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+}
+
+func testUndo(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	if err := s.Undo(ctx, boardID); err != moodboard.ErrNothingToUndo {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNothingToUndo, err)
+	}
+
+	items := make([]string, 3)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	title := "title"
+
+	if _, err := s.Update(ctx, boardID, items[1], moodboard.ItemUpdate{Title: &title}); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+
+	if err := s.MoveBefore(ctx, boardID, items[2], items[0]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if err := s.Delete(ctx, boardID, items[0]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// check makes sure that the board contains the specified items, and that the second item has the specified title.
+	check := func(expected []string, title string) {
+		t.Helper()
+
+		all, err := s.All(ctx, boardID)
+
+		if err != nil {
+			t.Fatalf("failed to get store contents: %v", err)
+		}
+
+		if !reflect.DeepEqual(ids(all), expected) {
+			t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+		}
+
+		for _, item := range all {
+			if item.ID == items[1] && item.Title != title {
+				t.Fatalf("expected title to be %q but got %q", title, item.Title)
+			}
+		}
+	}
+
+	check([]string{items[2], items[1]}, "title")
+
+	// Each undo reverses one of the operations above, most recent first.
+	states := []struct {
+		items []string
+		title string
+	}{
+		{items: []string{items[2], items[0], items[1]}, title: "title"},
+		{items: []string{items[0], items[1], items[2]}, title: "title"},
+		{items: []string{items[0], items[1], items[2]}, title: ""},
+		{items: []string{items[0], items[1]}, title: ""},
+	}
+
+	for _, state := range states {
+		if err := s.Undo(ctx, boardID); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err)
+		}
+
+		check(state.items, state.title)
+	}
+
+	// Undoing the creation of an item moves it to the trash.
+	trash, err := s.Trash(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get trash: %v", err)
+	}
+
+	if len(trash) != 1 || trash[0].ID != items[2] {
+		t.Fatalf("expected trash to be [%q] but got %v", items[2], trash)
+	}
+
+	// Redoing puts everything back again.
+	for i := len(states) - 2; i >= 0; i-- {
+		if err := s.Redo(ctx, boardID); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err)
+		}
+
+		check(states[i].items, states[i].title)
+	}
+
+	// Doing something new means that the remaining operation can't be redone.
+	if err := s.MoveAfter(ctx, boardID, items[0], items[1]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if err := s.Redo(ctx, boardID); err != moodboard.ErrNothingToRedo {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNothingToRedo, err)
+	}
+
+	if err := s.Undo(ctx, "nonexistent"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testUndoPurged(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	// Purged items are gone for good, so nothing involving them can be undone.
+	if err := s.Undo(ctx, boardID); err != moodboard.ErrNothingToUndo {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNothingToUndo, err)
+	}
+
+	items := make([]string, 4)
+
+	for i := range items {
+		if items[i], err = s.Create(ctx, boardID, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	if err := s.MoveAfter(ctx, boardID, items[2], items[3]); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	if err := s.Delete(ctx, boardID, items[0]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if err := s.Purge(ctx, boardID, items[0]); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	// Purging an item from before a moved item shouldn't change where undoing the move puts it.
+	if err := s.Undo(ctx, boardID); err != nil {
+		t.Fatalf("failed to undo move: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := items[1:]; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+
+	if err := s.Redo(ctx, boardID); err != nil {
+		t.Fatalf("failed to redo move: %v", err)
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[1], items[3], items[2]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+}
+
+func testDeleteMany(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 4)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	// Nothing should be deleted if any of the items have problems.
+	err := s.DeleteMany(ctx, boardID, []string{items[0], "nonexistent", items[2], items[2]})
+
+	var batchErr *moodboard.BatchError
+
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected error to be a batch error but got %v", err)
+	}
+
+	expected := map[string]error{"nonexistent": moodboard.ErrNoSuchItem, items[2]: moodboard.ErrDuplicateItem}
+
+	if !reflect.DeepEqual(batchErr.Errors, expected) {
+		t.Fatalf("expected errors to be %v but got %v", expected, batchErr.Errors)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), items) {
+		t.Fatalf("expected all to be %q but got %q", items, ids(all))
+	}
+
+	if err := s.DeleteMany(ctx, boardID, []string{items[2], items[0]}); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{items[1], items[3]}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+
+	// The whole batch should be undone at once.
+	if err := s.Undo(ctx, boardID); err != nil {
+		t.Fatalf("failed to undo delete: %v", err)
+	}
+
+	all, err = s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids(all), items) {
+		t.Fatalf("expected all to be %q but got %q", items, ids(all))
+	}
+
+	if err := s.DeleteMany(ctx, "nonexistent", items); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testMoveMany(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		move   []int
+		target int
+		before bool
+		order  []int
+		errs   map[int]error
+	}{
+		{
+			name:   "move first and third before last",
+			move:   []int{0, 2},
+			target: 4,
+			before: true,
+			order:  []int{1, 3, 0, 2, 4},
+		},
+		{
+			name:   "move third and first after last",
+			move:   []int{2, 0},
+			target: 4,
+			order:  []int{1, 3, 4, 0, 2},
+		},
+		{
+			name:   "move last and second before first",
+			move:   []int{4, 1},
+			target: 0,
+			before: true,
+			order:  []int{1, 4, 0, 2, 3},
+		},
+		{
+			name:   "move second and fourth after third",
+			move:   []int{1, 3},
+			target: 2,
+			order:  []int{0, 2, 1, 3, 4},
+		},
+		{
+			name:   "move first before itself",
+			move:   []int{0, 1},
+			target: 0,
+			before: true,
+			order:  []int{0, 1, 2, 3, 4},
+			errs:   map[int]error{0: moodboard.ErrTargetInBatch},
+		},
+		{
+			name:   "move non-existent before non-existent",
+			move:   []int{0, -1},
+			target: -2,
+			before: true,
+			order:  []int{0, 1, 2, 3, 4},
+			errs:   map[int]error{-1: moodboard.ErrNoSuchItem, -2: moodboard.ErrNoSuchItem},
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+			items := make([]string, 5)
+
+			for i := range items {
+				id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+				if err != nil {
+					t.Fatalf("failed to create item: %v", err)
+				}
+
+				items[i] = id
+			}
+
+			// id returns the ID of the item at the specified index, with negative indexes being non-existent items.
+			id := func(index int) string {
+				if index < 0 {
+					return fmt.Sprintf("nonexistent%d", -index)
+				}
+
+				return items[index]
+			}
+
+			move := make([]string, len(c.move))
+
+			for i, index := range c.move {
+				move[i] = id(index)
+			}
+
+			var err error
+
+			if c.before {
+				err = s.MoveManyBefore(ctx, boardID, move, id(c.target))
+			} else {
+				err = s.MoveManyAfter(ctx, boardID, move, id(c.target))
+			}
+
+			if c.errs == nil && err != nil {
+				t.Fatalf("expected error to be nil but got %q", err)
+			} else if c.errs != nil {
+				var batchErr *moodboard.BatchError
+
+				if !errors.As(err, &batchErr) {
+					t.Fatalf("expected error to be a batch error but got %v", err)
+				}
+
+				expected := make(map[string]error, len(c.errs))
+
+				for index, err := range c.errs {
+					expected[id(index)] = err
+				}
+
+				if !reflect.DeepEqual(batchErr.Errors, expected) {
+					t.Fatalf("expected errors to be %v but got %v", expected, batchErr.Errors)
+				}
+			}
+
+			all, err := s.All(ctx, boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			order := make([]string, len(c.order))
+
+			for i, index := range c.order {
+				order[i] = items[index]
+			}
+
+			if !reflect.DeepEqual(ids(all), order) {
+				t.Fatalf("expected all to be %q but got %q", order, ids(all))
+			}
+		})
+	}
+}
+
+func testPage(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 5)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	page, err := s.Page(ctx, boardID, moodboard.PageOptions{Limit: 2})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := items[:2]; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	// Adding an item before the cursor shouldn't change what comes after it.
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if err := s.MoveToStart(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to move item: %v", err)
+	}
+
+	page, err = s.Page(ctx, boardID, moodboard.PageOptions{Cursor: page.NextCursor, Limit: 2})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := items[2:4]; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	// The last page shouldn't have a cursor.
+	page, err = s.Page(ctx, boardID, moodboard.PageOptions{Cursor: page.NextCursor, Limit: 2})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := items[4:]; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	if page.NextCursor != "" {
+		t.Fatalf("expected next cursor to be empty but got %q", page.NextCursor)
+	}
+
+	// Filters should be applied before the limit.
+	page, err = s.Page(ctx, boardID, moodboard.PageOptions{
+		Limit: 2,
+		Filter: func(item moodboard.Item) bool {
+			return item.ID != items[0] && item.ID != items[2]
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := []string{id, items[1]}; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	// No limit should return everything.
+	page, err = s.Page(ctx, boardID, moodboard.PageOptions{})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := append([]string{id}, items...); !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	if page.NextCursor != "" {
+		t.Fatalf("expected next cursor to be empty but got %q", page.NextCursor)
+	}
+
+	if _, err := s.Page(ctx, boardID, moodboard.PageOptions{Cursor: "!"}); err != moodboard.ErrInvalidCursor {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrInvalidCursor, err)
+	}
+
+	if _, err := s.Page(ctx, "nonexistent", moodboard.PageOptions{}); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testPageReorder(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	items := make([]string, 12)
+
+	for i := range items {
+		id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+
+		items[i] = id
+	}
+
+	page, err := s.Page(ctx, boardID, moodboard.PageOptions{Limit: 4})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := items[:4]; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+
+	// Reordering the board gives every item a new key, which shouldn't change where the next page starts.
+	order := append([]string{items[1], items[0]}, items[2:]...)
+
+	if err := s.Reorder(ctx, boardID, order); err != nil {
+		t.Fatalf("failed to reorder items: %v", err)
+	}
+
+	page, err = s.Page(ctx, boardID, moodboard.PageOptions{Cursor: page.NextCursor})
+
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+
+	if expected := items[4:]; !reflect.DeepEqual(ids(page.Items), expected) {
+		t.Fatalf("expected page to be %q but got %q", expected, ids(page.Items))
+	}
+}
+
+// cancellingReader is an io.Reader which cancels a context after its first read.
+type cancellingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancellingReader) Read(p []byte) (int, error) {
+	r.cancel()
+
+	return copy(p, "image"), nil
+}
+
+func testCancel(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		img    func(context.CancelFunc) io.Reader
+		before bool
+	}{
+		{
+			name: "cancel before create",
+			img: func(context.CancelFunc) io.Reader {
+				return bytes.NewReader(nil)
+			},
+			before: true,
+		},
+		{
+			name: "cancel during create",
+			img: func(cancel context.CancelFunc) io.Reader {
+				return cancellingReader{cancel: cancel}
+			},
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if c.before {
+				cancel()
+			}
+
+			if _, err := s.Create(ctx, boardID, c.img(cancel)); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected error to be %q but got %q", context.Canceled, err)
+			}
+
+			all, err := s.All(context.Background(), boardID)
+
+			if err != nil {
+				t.Fatalf("failed to get store contents: %v", err)
+			}
+
+			if len(all) != 0 {
+				t.Fatalf("expected to get 0 items but got %d", len(all))
+			}
+		})
+	}
+}
+
+func testBoards(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	first, err := s.CreateBoard(ctx, "first")
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	second, err := s.CreateBoard(ctx, "second")
+
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	boards, err := s.Boards(ctx)
+
+	if err != nil {
+		t.Fatalf("failed to get boards: %v", err)
+	}
+
+	if len(boards) != 2 {
+		t.Fatalf("expected to get 2 boards but got %d", len(boards))
+	}
+
+	if boards[0] != first || boards[1] != second {
+		t.Fatalf("expected boards to be [%v, %v] but got [%v, %v]", first, second, boards[0], boards[1])
+	}
+}
+
+func testRenameBoard(t *testing.T, newStore NewStoreFunc) {
+	cs := []struct {
+		name   string
+		exists bool
+		err    error
+	}{
+		{
+			name:   "rename",
+			exists: true,
+		},
+		{
+			name: "rename nonexistent",
+			err:  moodboard.ErrNoSuchBoard,
+		},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			boardID := NewBoard(t, s)
+
+			id := boardID
+
+			if !c.exists {
+				id = "nonexistent"
+			}
+
+			switch err := s.RenameBoard(ctx, id, "renamed"); {
+			case err != nil && c.err == nil:
+				t.Fatalf("expected error to be nil but got %q", err)
+			case err == nil && c.err != nil:
+				t.Fatalf("expected error to be %q but got nil", c.err)
+			case err != c.err:
+				t.Fatalf("expected error to be %q but got %q", c.err, err)
+			}
+
+			boards, err := s.Boards(ctx)
+
+			if err != nil {
+				t.Fatalf("failed to get boards: %v", err)
+			}
+
+			if len(boards) != 1 {
+				t.Fatalf("expected to get 1 board but got %d", len(boards))
+			}
+
+			name := "test"
+
+			if c.exists {
+				name = "renamed"
+			}
+
+			if boards[0].Name != name {
+				t.Fatalf("expected board to be named %q but got %q", name, boards[0].Name)
+			}
+		})
+	}
+}
+
+func testDeleteBoard(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	otherBoardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	otherID, err := s.Create(ctx, otherBoardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if err := s.DeleteBoard(ctx, boardID); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err)
+	}
+
+	if err := s.DeleteBoard(ctx, boardID); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	if _, err := s.All(ctx, boardID); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	if _, err := s.GetImage(ctx, boardID, id); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	// Items on other boards should be left alone.
+	all, err := s.All(ctx, otherBoardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 1 || all[0].ID != otherID {
+		t.Fatalf("expected all to be [%q] but got %v", otherID, all)
+	}
+}
+
+func testBoardIsolation(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	otherBoardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	otherID, err := s.Create(ctx, otherBoardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if _, err := s.GetImage(ctx, otherBoardID, id); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.MoveBefore(ctx, boardID, id, otherID); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if err := s.Delete(ctx, otherBoardID, id); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := s.Create(ctx, "nonexistent", bytes.NewReader(nil)); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 1 || all[0].ID != id {
+		t.Fatalf("expected all to be [%q] but got %v", id, all)
+	}
+}
+
+func testNoSuchItem(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	otherBoardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	otherID, err := s.Create(ctx, otherBoardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	trashedID, err := s.Create(ctx, boardID, bytes.NewReader(nil))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if err := s.Delete(ctx, boardID, trashedID); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	title := "title"
+
+	// Items which don't exist, items on other boards and items in the trash should all be treated as missing.
+	for _, missingID := range []string{"nonexistent", otherID, trashedID} {
+		ops := []struct {
+			name string
+			fn   func() error
+		}{
+			{name: "Update", fn: func() error {
+				_, err := s.Update(ctx, boardID, missingID, moodboard.ItemUpdate{Title: &title})
+
+				return err
+			}},
+			{name: "AddTag", fn: func() error {
+				_, err := s.AddTag(ctx, boardID, missingID, "tag")
+
+				return err
+			}},
+			{name: "RemoveTag", fn: func() error {
+				_, err := s.RemoveTag(ctx, boardID, missingID, "tag")
+
+				return err
+			}},
+			{name: "MoveBefore", fn: func() error { return s.MoveBefore(ctx, boardID, missingID, id) }},
+			{name: "MoveBefore target", fn: func() error { return s.MoveBefore(ctx, boardID, id, missingID) }},
+			{name: "MoveAfter", fn: func() error { return s.MoveAfter(ctx, boardID, missingID, id) }},
+			{name: "MoveAfter target", fn: func() error { return s.MoveAfter(ctx, boardID, id, missingID) }},
+			{name: "MoveToIndex", fn: func() error { return s.MoveToIndex(ctx, boardID, missingID, 0) }},
+			{name: "MoveToStart", fn: func() error { return s.MoveToStart(ctx, boardID, missingID) }},
+			{name: "MoveToEnd", fn: func() error { return s.MoveToEnd(ctx, boardID, missingID) }},
+			{name: "Delete", fn: func() error { return s.Delete(ctx, boardID, missingID) }},
+		}
+
+		for _, op := range ops {
+			if err := op.fn(); err != moodboard.ErrNoSuchItem {
+				t.Errorf("expected %s of %q to return %q but got %q", op.name, missingID, moodboard.ErrNoSuchItem, err)
+			}
+		}
+	}
+
+	// Images are kept whilst an item is in the trash, but items on other boards still don't exist.
+	for _, missingID := range []string{"nonexistent", otherID} {
+		if _, err := s.GetImage(ctx, boardID, missingID); err != moodboard.ErrNoSuchItem {
+			t.Errorf("expected GetImage of %q to return %q but got %q", missingID, moodboard.ErrNoSuchItem, err)
+		}
+	}
+
+	// Only items in the trash can be restored or purged.
+	for _, missingID := range []string{"nonexistent", otherID, id} {
+		if err := s.Restore(ctx, boardID, missingID); err != moodboard.ErrNoSuchItem {
+			t.Errorf("expected Restore of %q to return %q but got %q", missingID, moodboard.ErrNoSuchItem, err)
+		}
+
+		if err := s.Purge(ctx, boardID, missingID); err != moodboard.ErrNoSuchItem {
+			t.Errorf("expected Purge of %q to return %q but got %q", missingID, moodboard.ErrNoSuchItem, err)
+		}
+	}
+
+	// Nothing should have changed.
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if expected := []string{id}; !reflect.DeepEqual(ids(all), expected) {
+		t.Fatalf("expected all to be %q but got %q", expected, ids(all))
+	}
+}
+
+func testConcurrency(t *testing.T, newStore NewStoreFunc) {
+	const workers = 8
+	const perWorker = 10
+
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	var wg sync.WaitGroup
+
+	created := make([][]string, workers)
+
+	// Create items from several goroutines at once, whilst reading and moving the items which already exist.
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < perWorker; j++ {
+				id, err := s.Create(ctx, boardID, bytes.NewReader([]byte(fmt.Sprintf("image %d-%d", i, j))))
+
+				if err != nil {
+					t.Errorf("failed to create item: %v", err)
+
+					return
+				}
+
+				created[i] = append(created[i], id)
+
+				if _, err := s.All(ctx, boardID); err != nil {
+					t.Errorf("failed to get store contents: %v", err)
+
+					return
+				}
+
+				if err := s.MoveToStart(ctx, boardID, id); err != nil {
+					t.Errorf("failed to move item: %v", err)
+
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != workers*perWorker {
+		t.Fatalf("expected to get %d items but got %d", workers*perWorker, len(all))
+	}
+
+	seen := make(map[string]bool, len(all))
+
+	for _, item := range all {
+		if seen[item.ID] {
+			t.Fatalf("expected item %q to appear once but it appeared more than once", item.ID)
+		}
+
+		seen[item.ID] = true
+	}
+
+	// Every item should have kept its own image.
+	for i := range created {
+		for j, id := range created[i] {
+			if !seen[id] {
+				t.Fatalf("expected item %q to be on the board", id)
+			}
+
+			img, err := s.GetImage(ctx, boardID, id)
+
+			if err != nil {
+				t.Fatalf("failed to get image: %v", err)
+			}
+
+			buf, err := ioutil.ReadAll(img)
+
+			if closer, ok := img.(io.Closer); ok {
+				_ = closer.Close()
+			}
+
+			if err != nil {
+				t.Fatalf("failed to read image: %v", err)
+			}
+
+			if expected := fmt.Sprintf("image %d-%d", i, j); string(buf) != expected {
+				t.Fatalf("expected image to be %q but got %q", expected, buf)
+			}
+		}
+	}
+}
+
+func testLargeImage(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	// Random data doesn't compress, so the store has to deal with every byte of it.
+	expected := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(expected)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader(expected))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	img, err := s.GetImage(ctx, boardID, id)
+
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+
+	buf, err := ioutil.ReadAll(img)
+
+	if closer, ok := img.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("expected image to be %d bytes of random data but got %d different bytes", len(expected), len(buf))
+	}
+}
+
+func testUsage(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+	otherBoardID := NewBoard(t, s)
+
+	id, err := s.Create(ctx, boardID, bytes.NewReader([]byte("image")))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if _, err := s.Create(ctx, boardID, bytes.NewReader([]byte("another image"))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if _, err := s.Create(ctx, otherBoardID, bytes.NewReader([]byte("other image"))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 2 || all[0].Size != 5 || all[1].Size != 13 {
+		t.Fatalf("expected items to have sizes [5, 13] but got %v", all)
+	}
+
+	u, err := s.Usage(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	if u.Board != 18 || u.Total != 29 {
+		t.Fatalf("expected usage to be [18, 29] but got [%d, %d]", u.Board, u.Total)
+	}
+
+	// Items in the trash still take up space until they're purged.
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if u, err = s.Usage(ctx, boardID); err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	if u.Board != 18 || u.Total != 29 {
+		t.Fatalf("expected usage to be [18, 29] but got [%d, %d]", u.Board, u.Total)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	if u, err = s.Usage(ctx, boardID); err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	if u.Board != 13 || u.Total != 24 {
+		t.Fatalf("expected usage to be [13, 24] but got [%d, %d]", u.Board, u.Total)
+	}
+
+	if _, err := s.Usage(ctx, "nonexistent"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+// imageConfig reads the format and dimensions of an image, closing it afterwards.
+func imageConfig(t *testing.T, img io.Reader) (image.Config, string) {
+	t.Helper()
+
+	if closer, ok := img.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+
+	config, format, err := image.DecodeConfig(img)
+
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+
+	return config, format
+}
+
+func testThumbnails(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	src := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 2000; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	id, err := s.Create(ctx, boardID, &buf)
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	cs := []struct {
+		size   int
+		width  int
+		height int
+		format string
+	}{
+		{size: 1, width: 256, height: 128, format: "jpeg"},
+		{size: 256, width: 256, height: 128, format: "jpeg"},
+		{size: 300, width: 512, height: 256, format: "jpeg"},
+		{size: 1024, width: 1024, height: 512, format: "jpeg"},
+		{size: 1025, width: 2000, height: 1000, format: "png"},
+	}
+
+	for _, c := range cs {
+		img, err := s.GetThumbnail(ctx, boardID, id, c.size)
+
+		if err != nil {
+			t.Fatalf("failed to get thumbnail: %v", err)
+		}
+
+		config, format := imageConfig(t, img)
+
+		if config.Width != c.width || config.Height != c.height || format != c.format {
+			t.Fatalf(
+				"expected thumbnail for size %d to be a %dx%d %s but got a %dx%d %s",
+				c.size,
+				c.width,
+				c.height,
+				c.format,
+				config.Width,
+				config.Height,
+				format,
+			)
+		}
+	}
+
+	// Images which can't be decoded don't get thumbnails, so the original is returned instead.
+	otherID, err := s.Create(ctx, boardID, bytes.NewReader([]byte("image")))
+
+	if err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	img, err := s.GetThumbnail(ctx, boardID, otherID, 256)
+
+	if err != nil {
+		t.Fatalf("failed to get thumbnail: %v", err)
+	}
+
+	imgBytes, err := ioutil.ReadAll(img)
+
+	if closer, ok := img.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	if err != nil {
+		t.Fatalf("failed to read thumbnail: %v", err)
+	}
+
+	if string(imgBytes) != "image" {
+		t.Fatalf("expected thumbnail to be %q but got %q", "image", imgBytes)
+	}
+
+	// Thumbnails are kept whilst the item is in the trash, and removed along with it.
+	if err := s.Delete(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if img, err = s.GetThumbnail(ctx, boardID, id, 256); err != nil {
+		t.Fatalf("failed to get thumbnail: %v", err)
+	}
+
+	if config, _ := imageConfig(t, img); config.Width != 256 {
+		t.Fatalf("expected thumbnail to be 256 pixels wide but got %d", config.Width)
+	}
+
+	if err := s.Purge(ctx, boardID, id); err != nil {
+		t.Fatalf("failed to purge item: %v", err)
+	}
+
+	if _, err := s.GetThumbnail(ctx, boardID, id, 256); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := s.GetThumbnail(ctx, "nonexistent", otherID, 256); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+func testDimensions(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	if _, err := s.Create(ctx, boardID, &buf); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200)), nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// Images which need to be turned a quarter turn have their width and height swapped.
+	exif := []byte("Exif\x00\x00" +
+		"MM\x00\x2a\x00\x00\x00\x08" + // A big-endian TIFF header, with the first directory straight after it.
+		"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" + // A single entry with an orientation of 6.
+		"\x00\x00\x00\x00") // No more directories.
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	rotated := bytes.Join([][]byte{buf.Bytes()[:2], segment, buf.Bytes()[2:]}, nil)
+
+	if _, err := s.Create(ctx, boardID, bytes.NewReader(rotated)); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	// Images which can't be decoded don't have any dimensions.
+	if _, err := s.Create(ctx, boardID, bytes.NewReader([]byte("image"))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 3 {
+		t.Fatalf("expected to get 3 items but got %d", len(all))
+	}
+
+	if all[0].Width != 300 || all[0].Height != 200 || all[0].AspectRatio != 1.5 {
+		t.Fatalf("expected dimensions to be [300, 200, 1.5] but got %v", all[0])
+	}
+
+	if all[1].Width != 200 || all[1].Height != 300 || all[1].AspectRatio != 200.0/300.0 {
+		t.Fatalf("expected dimensions to be [200, 300, %v] but got %v", 200.0/300.0, all[1])
+	}
+
+	if all[2].Width != 0 || all[2].Height != 0 || all[2].AspectRatio != 0 {
+		t.Fatalf("expected dimensions to be [0, 0, 0] but got %v", all[2])
+	}
+}
+
+// checkPalette makes sure that a palette contains the expected colours.
+func checkPalette(t *testing.T, name string, expected, palette []moodboard.Colour) {
+	t.Helper()
+
+	if len(palette) != len(expected) {
+		t.Fatalf("expected %s palette to be %v but got %v", name, expected, palette)
+	}
+
+	for i := range palette {
+		if palette[i] != expected[i] {
+			t.Fatalf("expected %s palette to be %v but got %v", name, expected, palette)
+		}
+	}
+}
+
+func testPalette(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	// Three quarters of the first image is red and the rest is blue, and the second image is all green.
+	first := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	second := image.NewRGBA(image.Rect(0, 0, 16, 16))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x < 48 {
+				first.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+			} else {
+				first.Set(x, y, color.RGBA{B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	draw.Draw(second, second.Rect, image.NewUniform(color.RGBA{G: 0xff, A: 0xff}), image.Point{}, draw.Src)
+
+	ids := make([]string, 3)
+
+	for i, src := range []image.Image{first, second} {
+		var buf bytes.Buffer
+
+		if err := png.Encode(&buf, src); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+
+		var err error
+
+		if ids[i], err = s.Create(ctx, boardID, &buf); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	// Images which can't be decoded don't have a palette.
+	var err error
+
+	if ids[2], err = s.Create(ctx, boardID, bytes.NewReader([]byte("image"))); err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	if len(all) != 3 {
+		t.Fatalf("expected to get 3 items but got %d", len(all))
+	}
+
+	red := moodboard.Colour{Hex: "#ff0000"}
+	green := moodboard.Colour{Hex: "#00ff00"}
+	blue := moodboard.Colour{Hex: "#0000ff"}
+
+	weighted := func(c moodboard.Colour, weight float64) moodboard.Colour {
+		c.Weight = weight
+
+		return c
+	}
+
+	checkPalette(t, "first item", []moodboard.Colour{weighted(red, 0.75), weighted(blue, 0.25)}, all[0].Palette)
+	checkPalette(t, "second item", []moodboard.Colour{weighted(green, 1)}, all[1].Palette)
+	checkPalette(t, "third item", nil, all[2].Palette)
+
+	// Each item counts equally towards the palette of the board.
+	palette, err := s.Palette(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get palette: %v", err)
+	}
+
+	checkPalette(
+		t,
+		"board",
+		[]moodboard.Colour{weighted(green, 0.5), weighted(red, 0.375), weighted(blue, 0.125)},
+		palette,
+	)
+
+	// Items in the trash don't count.
+	if err := s.Delete(ctx, boardID, ids[1]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if palette, err = s.Palette(ctx, boardID); err != nil {
+		t.Fatalf("failed to get palette: %v", err)
+	}
+
+	checkPalette(t, "board", []moodboard.Colour{weighted(red, 0.75), weighted(blue, 0.25)}, palette)
+
+	// Empty boards don't have a palette.
+	if palette, err = s.Palette(ctx, NewBoard(t, s)); err != nil {
+		t.Fatalf("failed to get palette: %v", err)
+	}
+
+	checkPalette(t, "empty board", nil, palette)
+
+	if _, err := s.Palette(ctx, "nonexistent"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}
+
+// waves returns an encoded image of the specified size, which is covered in a pattern of light and dark patches.
+//
+// The pattern is stretched to fit the image, so images with the same number of waves look alike at any size.
+func waves(t *testing.T, width, height, wavesX, wavesY int, encode func(io.Writer, image.Image) error) io.Reader {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx := math.Sin(float64(x*wavesX) * math.Pi / float64(width))
+			fy := math.Cos(float64(y*wavesY) * math.Pi / float64(height))
+
+			img.SetGray(x, y, color.Gray{Y: uint8(128 + 100*fx*fy)})
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	return &buf
+}
+
+func testDuplicates(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+	s := newStore(t)
+	boardID := NewBoard(t, s)
+
+	encodeJPEG := func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 50})
+	}
+
+	// The second image is a smaller, recompressed copy of the first, and the third image has a different pattern.
+	images := []io.Reader{
+		waves(t, 400, 300, 3, 2, png.Encode),
+		waves(t, 200, 150, 3, 2, encodeJPEG),
+		waves(t, 400, 300, 2, 5, png.Encode),
+		bytes.NewReader([]byte("image")),
+	}
+
+	itemIDs := make([]string, len(images))
+
+	for i, img := range images {
+		var err error
+
+		if itemIDs[i], err = s.Create(ctx, boardID, img); err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+	}
+
+	all, err := s.All(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get store contents: %v", err)
+	}
+
+	for i, item := range all[:3] {
+		if len(item.Hash) != 16 {
+			t.Fatalf("expected item %d to have a 16 digit hash but got %q", i, item.Hash)
+		}
+	}
+
+	// Images which can't be decoded don't have a hash.
+	if all[3].Hash != "" {
+		t.Fatalf("expected item 3 to have no hash but got %q", all[3].Hash)
+	}
+
+	cs := []struct {
+		id      string
+		similar []string
+	}{
+		{id: itemIDs[0], similar: []string{itemIDs[1]}},
+		{id: itemIDs[1], similar: []string{itemIDs[0]}},
+		{id: itemIDs[2], similar: []string{}},
+		{id: itemIDs[3], similar: []string{}},
+	}
+
+	for i, c := range cs {
+		items, err := s.Similar(ctx, boardID, c.id)
+
+		if err != nil {
+			t.Fatalf("failed to get similar items: %v", err)
+		}
+
+		if !reflect.DeepEqual(ids(items), c.similar) {
+			t.Fatalf("expected items similar to item %d to be %v but got %v", i, c.similar, ids(items))
+		}
+	}
+
+	groups, err := s.Duplicates(ctx, boardID)
+
+	if err != nil {
+		t.Fatalf("failed to get duplicates: %v", err)
+	}
+
+	if len(groups) != 1 || !reflect.DeepEqual(ids(groups[0]), itemIDs[:2]) {
+		t.Fatalf("expected duplicates to be [%v] but got %v", itemIDs[:2], groups)
+	}
+
+	// Items in the trash aren't duplicates of anything.
+	if err := s.Delete(ctx, boardID, itemIDs[1]); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if groups, err = s.Duplicates(ctx, boardID); err != nil {
+		t.Fatalf("failed to get duplicates: %v", err)
+	}
+
+	if len(groups) != 0 {
+		t.Fatalf("expected no duplicates but got %v", groups)
+	}
+
+	if items, err := s.Similar(ctx, boardID, itemIDs[0]); err != nil || len(items) != 0 {
+		t.Fatalf("expected result to be [[], nil] but got [%v, %q]", items, err)
+	}
+
+	if _, err := s.Similar(ctx, boardID, "nonexistent"); err != moodboard.ErrNoSuchItem {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchItem, err)
+	}
+
+	if _, err := s.Similar(ctx, "nonexistent", itemIDs[0]); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+
+	if _, err := s.Duplicates(ctx, "nonexistent"); err != moodboard.ErrNoSuchBoard {
+		t.Fatalf("expected error to be %q but got %q", moodboard.ErrNoSuchBoard, err)
+	}
+}